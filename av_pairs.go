@@ -5,7 +5,7 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
-	"unicode/utf16"
+	"time"
 )
 
 type AvPairType uint16
@@ -40,21 +40,87 @@ const (
 	MsvChannelBindings
 )
 
+// MsvAvFlags bits
+const (
+	MsvAvFlagAuthenticationConstrained = 0x00000001
+	MsvAvFlagMICProvided               = 0x00000002
+	MsvAvFlagUntrustedSPNSource        = 0x00000004
+)
+
+// Decoded MsvAvFlags value
+type AvFlags struct {
+	AuthConstrained    bool
+	MICProvided        bool
+	UntrustedSPNSource bool
+}
+
+// Decode a 4-byte MsvAvFlags value, unknown bits are ignored
+func ParseAvFlags(b []byte) AvFlags {
+	var flags uint32
+	if len(b) >= 4 {
+		flags = binary.LittleEndian.Uint32(b)
+	}
+	return AvFlags{
+		AuthConstrained:    flags&MsvAvFlagAuthenticationConstrained != 0,
+		MICProvided:        flags&MsvAvFlagMICProvided != 0,
+		UntrustedSPNSource: flags&MsvAvFlagUntrustedSPNSource != 0,
+	}
+}
+
+// 4-byte MsvAvFlags value
+func (f AvFlags) Encode() []byte {
+	var flags uint32
+	if f.AuthConstrained {
+		flags |= MsvAvFlagAuthenticationConstrained
+	}
+	if f.MICProvided {
+		flags |= MsvAvFlagMICProvided
+	}
+	if f.UntrustedSPNSource {
+		flags |= MsvAvFlagUntrustedSPNSource
+	}
+	bs := make([]byte, 4)
+	binary.LittleEndian.PutUint32(bs, flags)
+	return bs
+}
+
+// MS-NLMP 2.2.2.2 Single_Host_Data, the MsvAvSingleHost value
+type SingleHostData struct {
+	CustomData [8]byte
+	MachineID  [32]byte
+}
+
+// Decode a Single_Host_Data structure, Size must be 48 and is the only
+// length accepted
+func ParseSingleHostData(b []byte) (SingleHostData, error) {
+	var sh SingleHostData
+	if len(b) != 48 {
+		return sh, fmt.Errorf("%w: Single_Host_Data is %d bytes, want 48", ErrMalformedMessage, len(b))
+	}
+	if size := binary.LittleEndian.Uint32(b[:4]); size != 48 {
+		return sh, fmt.Errorf("%w: Single_Host_Data Size %d, want 48", ErrMalformedMessage, size)
+	}
+	copy(sh.CustomData[:], b[8:16])
+	copy(sh.MachineID[:], b[16:48])
+	return sh, nil
+}
+
+// 48-byte Single_Host_Data value
+func (sh SingleHostData) Encode() []byte {
+	bs := make([]byte, 48)
+	binary.LittleEndian.PutUint32(bs[:4], 48)
+	copy(bs[8:16], sh.CustomData[:])
+	copy(bs[16:48], sh.MachineID[:])
+	return bs
+}
+
 // Helper struct that contains a list of AvPairs with helper methods for running through them
 type AvPairs struct {
 	List []AvPair
 }
 
 func utf16ToString(bytes []byte) string {
-	var data []uint16
-
-	// NOTE: This is definitely not the best way to do this, but when I tried using a buffer.Read I could not get it to work
-	for offset := 0; offset < len(bytes); offset = offset + 2 {
-		i := binary.LittleEndian.Uint16(bytes[offset : offset+2])
-		data = append(data, i)
-	}
-
-	return string(utf16.Decode(data))
+	return bytes2StringUTF16(bytes)
 }
 func (p *AvPairs) AddAvPair(avId AvPairType, bytes []byte) {
 	a := &AvPair{AvId: avId, AvLen: uint16(len(bytes)), Value: bytes}
@@ -68,6 +134,9 @@ func ReadAvPairs(data []byte) *AvPairs {
 	offset := 0
 	for i := 0; len(data) > 0 && i < 11; i++ {
 		pair := ReadAvPair(data, offset)
+		if pair == nil {
+			break
+		}
 		offset = offset + 4 + int(pair.AvLen)
 		pairs.List = append(pairs.List, *pair)
 		if pair.AvId == MsvAvEOL {
@@ -141,7 +210,11 @@ type AvPair struct {
 	Value []byte
 }
 
+// nil if the pair at offset runs past data
 func ReadAvPair(data []byte, offset int) *AvPair {
+	if offset < 0 || len(data)-offset < 4 || len(data)-offset-4 < int(binary.LittleEndian.Uint16(data[offset+2:offset+4])) {
+		return nil
+	}
 	pair := new(AvPair)
 	pair.AvId = AvPairType(binary.LittleEndian.Uint16(data[offset : offset+2]))
 	pair.AvLen = binary.LittleEndian.Uint16(data[offset+2 : offset+4])
@@ -149,6 +222,159 @@ func ReadAvPair(data []byte, offset int) *AvPair {
 	return pair
 }
 
+// Set the MIC provided bit of MsvAvFlags, the pair is added in front of
+// MsvAvEOL if it's absent.
+func (p *AvPairs) SetMICFlag() {
+	value := make([]byte, 4)
+	binary.LittleEndian.PutUint32(value, p.Flags()|MsvAvFlagMICProvided)
+	p.Set(MsvAvFlags, value)
+}
+
+// The NetBIOS and DNS names and MsvAvTargetName are UTF-16LE strings, the
+// other values binary
+func isUnicodeAvPair(avId AvPairType) bool {
+	switch avId {
+	case MsvAvNbComputerName, MsvAvNbDomainName, MsvAvDnsComputerName, MsvAvDnsDomainName, MsvAvDnsTreeName, MsvAvTargetName:
+		return true
+	}
+	return false
+}
+
+// Value of the first pair with avId, nil if absent
+func (p *AvPairs) Get(avId AvPairType) []byte {
+	return p.ByteValue(avId)
+}
+
+// Replace the value of avId in place, or add the pair in front of MsvAvEOL
+func (p *AvPairs) Set(avId AvPairType, value []byte) {
+	pair := AvPair{AvId: avId, AvLen: uint16(len(value)), Value: value}
+	for i := range p.List {
+		if p.List[i].AvId == avId {
+			p.List[i] = pair
+			return
+		}
+	}
+
+	if n := len(p.List); n > 0 && p.List[n-1].AvId == MsvAvEOL {
+		p.List = append(p.List[:n-1], pair, p.List[n-1])
+	} else {
+		p.List = append(p.List, pair, AvPair{AvId: MsvAvEOL})
+	}
+}
+
+// Target info for the NTLMv2 response: the server's pairs in their order,
+// each addition replacing the server's value in place or appended, and
+// MsvAvEOL last. Neither argument is modified.
+func MergeTargetInfo(serverTargetInfo, additions *AvPairs) *AvPairs {
+	merged := &AvPairs{List: append([]AvPair{}, serverTargetInfo.List...)}
+	for _, pair := range additions.List {
+		if pair.AvId != MsvAvEOL {
+			merged.Set(pair.AvId, pair.Value)
+		}
+	}
+	if n := len(merged.List); n == 0 || merged.List[n-1].AvId != MsvAvEOL {
+		merged.List = append(merged.List, AvPair{AvId: MsvAvEOL})
+	}
+	return merged
+}
+
+// Set MsvAvTargetName to the UTF-16LE service principal name of the
+// server, e.g. "HTTP/server.example.com"
+func (p *AvPairs) SetTargetName(spn string) {
+	p.Set(MsvAvTargetName, encodeUTF16LE([]byte(spn)))
+}
+
+// MsvAvTimestamp as time.Time, false if absent
+func (p *AvPairs) Timestamp() (time.Time, bool) {
+	value := p.Get(MsvAvTimestamp)
+	if len(value) != 8 {
+		return time.Time{}, false
+	}
+	return TimeFromWindowsTimestamp(value), true
+}
+
+// MsvAvFlags value, 0 if absent
+func (p *AvPairs) Flags() uint32 {
+	value := p.Get(MsvAvFlags)
+	if len(value) != 4 {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(value)
+}
+
+// Same as Bytes, but always terminated by MsvAvEOL
+func (p *AvPairs) Marshal() []byte {
+	bs := p.Bytes()
+	if n := len(p.List); n == 0 || p.List[n-1].AvId != MsvAvEOL {
+		bs = append(bs, 0, 0, 0, 0)
+	}
+	return bs
+}
+
+// Like ReadAvPairs, but checks every AvLen against the buffer instead of
+// panicking. The list keeps the order of bs and ends with MsvAvEOL, bytes
+// after it are ignored.
+func ParseAVPairsOrdered(bs []byte) (*AvPairs, error) {
+	pairs := new(AvPairs)
+	if err := ParseAVPairInto(bs, pairs); err != nil {
+		return nil, err
+	}
+	return pairs, nil
+}
+
+// Same as ParseAVPairsOrdered into dst, whose List is truncated and
+// reused, so parsing many lists costs no allocation once it has grown.
+// The values alias bs. On error dst holds the pairs read so far.
+func ParseAVPairInto(bs []byte, dst *AvPairs) error {
+	dst.List = dst.List[:0]
+	for offset := 0; ; {
+		if len(bs)-offset < 4 {
+			return fmt.Errorf("%w: AV pair list without MsvAvEOL", ErrMalformedMessage)
+		}
+		pair := AvPair{
+			AvId:  AvPairType(binary.LittleEndian.Uint16(bs[offset:])),
+			AvLen: binary.LittleEndian.Uint16(bs[offset+2:]),
+		}
+		offset += 4
+		if len(bs)-offset < int(pair.AvLen) {
+			return fmt.Errorf("%w: AV pair %d length %d out of range", ErrMalformedMessage, pair.AvId, pair.AvLen)
+		}
+		pair.Value = bs[offset : offset+int(pair.AvLen)]
+		offset += int(pair.AvLen)
+
+		dst.List = append(dst.List, pair)
+		if pair.AvId == MsvAvEOL {
+			return nil
+		}
+	}
+}
+
+// Strict check of an AV pair list, for spotting anomalies the parsers
+// tolerate: MsvAvEOL must have AvLen 0 and be the last bytes of bs, no
+// AvId may repeat.
+func ValidateAVPairs(bs []byte) error {
+	pairs, err := ParseAVPairsOrdered(bs)
+	if err != nil {
+		return err
+	}
+	seen := map[AvPairType]bool{}
+	end := 0
+	for _, pair := range pairs.List {
+		end += 4 + int(pair.AvLen)
+		if pair.AvId == MsvAvEOL && pair.AvLen != 0 {
+			return fmt.Errorf("%w: MsvAvEOL with AvLen %d", ErrMalformedMessage, pair.AvLen)
+		}
+		if seen[pair.AvId] {
+			return fmt.Errorf("%w: AV pair %d repeated", ErrMalformedMessage, pair.AvId)
+		}
+		seen[pair.AvId] = true
+	}
+	if end != len(bs) {
+		return fmt.Errorf("%w: %d bytes after MsvAvEOL", ErrMalformedMessage, len(bs)-end)
+	}
+	return nil
+}
+
 func (a *AvPair) UnicodeStringValue() string {
 	return utf16ToString(a.Value)
 }
@@ -0,0 +1,298 @@
+package ntlmssp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseAVPairsOrdered(t *testing.T) {
+	// MS-NLMP 4.2.4
+	bs := decodeHex("02000c0044006f006d00610069006e0001000c0053006500720076006500720000000000")
+	pairs, err := ParseAVPairsOrdered(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pairs.List) != 3 || pairs.List[0].AvId != MsvAvNbDomainName || pairs.List[1].AvId != MsvAvNbComputerName {
+		t.Fatalf("pairs = %v", pairs)
+	}
+	if got := pairs.StringValue(MsvAvNbComputerName); got != "Server" {
+		t.Errorf("MsvAvNbComputerName = %q", got)
+	}
+	if got := pairs.Marshal(); !bytes.Equal(got, bs) {
+		t.Errorf("Marshal = %x, want %x", got, bs)
+	}
+
+	for _, bad := range [][]byte{
+		{},
+		bs[:len(bs)-4],
+		{0x02, 0x00, 0x10, 0x00, 'a', 0},
+	} {
+		if _, err := ParseAVPairsOrdered(bad); err == nil {
+			t.Errorf("ParseAVPairsOrdered(%x): expected error", bad)
+		}
+	}
+}
+
+func TestAvPairs_Set(t *testing.T) {
+	pairs := new(AvPairs)
+	pairs.Set(MsvAvNbDomainName, encodeUTF16LE([]byte("Domain")))
+	pairs.SetMICFlag()
+
+	now := time.Date(2021, 6, 1, 12, 0, 0, 100, time.UTC)
+	timestamp := make([]byte, 8)
+	binary.LittleEndian.PutUint64(timestamp, timeToFileTime(now))
+	pairs.Set(MsvAvTimestamp, timestamp)
+
+	parsed, err := ParseAVPairsOrdered(pairs.Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []AvPairType{MsvAvNbDomainName, MsvAvFlags, MsvAvTimestamp, MsvAvEOL}
+	if len(parsed.List) != len(want) {
+		t.Fatalf("pairs = %v", parsed)
+	}
+	for i := range want {
+		if parsed.List[i].AvId != want[i] {
+			t.Errorf("pair %d = %d, want %d", i, parsed.List[i].AvId, want[i])
+		}
+	}
+	if got := parsed.Flags(); got != MsvAvFlagMICProvided {
+		t.Errorf("Flags = %x", got)
+	}
+	if got, ok := parsed.Timestamp(); !ok || !got.Equal(now) {
+		t.Errorf("Timestamp = %v, %v, want %v", got, ok, now)
+	}
+
+	pairs.Set(MsvAvNbDomainName, encodeUTF16LE([]byte("Other")))
+	if got := pairs.StringValue(MsvAvNbDomainName); got != "Other" || len(pairs.List) != 4 {
+		t.Errorf("Set did not replace the value: %v", pairs)
+	}
+}
+
+func TestAvPairs_SetTargetName(t *testing.T) {
+	pairs := new(AvPairs)
+	pairs.SetTargetName("HTTP/server.example.com")
+
+	want := append([]byte{0x09, 0x00, 46, 0x00}, encodeUTF16LE([]byte("HTTP/server.example.com"))...)
+	want = append(want, 0, 0, 0, 0)
+	if got := pairs.Marshal(); !bytes.Equal(got, want) {
+		t.Errorf("Marshal = %x, want %x", got, want)
+	}
+	if got := pairs.StringValue(MsvAvTargetName); got != "HTTP/server.example.com" {
+		t.Errorf("MsvAvTargetName = %q", got)
+	}
+
+	cm, _ := NewChallengeMsg(nil)
+	cm.SetTargetInfo(map[string]interface{}{"MsvAvTargetName": "HTTP/server.example.com"})
+	if got := cm.TargetInfo(); !bytes.Equal(got, want) {
+		t.Errorf("SetTargetInfo = %x, want %x", got, want)
+	}
+}
+
+func TestMergeTargetInfo(t *testing.T) {
+	// MsvAvNbDomainName, MsvAvNbComputerName, MsvAvTimestamp, MsvAvEOL
+	server, err := ParseAVPairsOrdered(decodeHex("02000c0044006f006d00610069006e0001000c00530065007200760065007200" +
+		"07000800000102030405060700000000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverLen := len(server.List)
+
+	additions := new(AvPairs)
+	additions.SetTargetName("HTTP/server")
+	additions.Set(MsvChannelBindings, ChannelBindingHash(nil))
+	additions.Set(MsvAvTimestamp, decodeHex("0807060504030201"))
+
+	merged := MergeTargetInfo(server, additions)
+	want := []AvPairType{MsvAvNbDomainName, MsvAvNbComputerName, MsvAvTimestamp, MsvAvTargetName, MsvChannelBindings, MsvAvEOL}
+	if len(merged.List) != len(want) {
+		t.Fatalf("merged = %v", merged)
+	}
+	for i := range want {
+		if merged.List[i].AvId != want[i] {
+			t.Errorf("pair %d = %d, want %d", i, merged.List[i].AvId, want[i])
+		}
+	}
+	if got := hex.EncodeToString(merged.Get(MsvAvTimestamp)); got != "0807060504030201" {
+		t.Errorf("MsvAvTimestamp = %s, not overridden", got)
+	}
+	if got := merged.StringValue(MsvAvTargetName); got != "HTTP/server" {
+		t.Errorf("MsvAvTargetName = %q", got)
+	}
+
+	if len(server.List) != serverLen || hex.EncodeToString(server.Get(MsvAvTimestamp)) != "0001020304050607" {
+		t.Errorf("server target info modified: %v", server)
+	}
+
+	// a list without MsvAvEOL still gets it last
+	if got := MergeTargetInfo(new(AvPairs), new(AvPairs)).Marshal(); !bytes.Equal(got, []byte{0, 0, 0, 0}) {
+		t.Errorf("empty merge = %x", got)
+	}
+}
+
+func TestAVPairs_TrailingBytes(t *testing.T) {
+	// MS-NLMP 4.2.4 followed by 8 bytes of padding
+	valid := decodeHex("02000c0044006f006d00610069006e0001000c0053006500720076006500720000000000")
+	padded := append(append([]byte{}, valid...), decodeHex("0100ffff00112233")...)
+
+	if got := ParseAVPair(padded); len(got) != 2 || got["MsvAvNbComputerName"] != "Server" {
+		t.Errorf("ParseAVPair = %v", got)
+	}
+	pairs, err := ParseAVPairsOrdered(padded)
+	if err != nil || len(pairs.List) != 3 || !bytes.Equal(pairs.Marshal(), valid) {
+		t.Errorf("ParseAVPairsOrdered = %v, %v", pairs, err)
+	}
+	if ti, err := ParseTargetInfo(padded); err != nil || ti.NetBIOSDomainName != "Domain" {
+		t.Errorf("ParseTargetInfo = %+v, %v", ti, err)
+	}
+
+	if err := ValidateAVPairs(valid); err != nil {
+		t.Errorf("ValidateAVPairs(valid): %v", err)
+	}
+	repeated := decodeHex("01000200410001000200420000000000")
+	for _, bad := range [][]byte{padded, valid[:len(valid)-4], decodeHex("0000020041000000"), repeated} {
+		if err := ValidateAVPairs(bad); !errors.Is(err, ErrMalformedMessage) {
+			t.Errorf("ValidateAVPairs(%x) = %v, want ErrMalformedMessage", bad, err)
+		}
+	}
+}
+
+func TestParseAvFlags(t *testing.T) {
+	for bits := uint32(0); bits < 8; bits++ {
+		value := make([]byte, 4)
+		binary.LittleEndian.PutUint32(value, bits)
+
+		flags := ParseAvFlags(value)
+		if flags.AuthConstrained != (bits&1 != 0) || flags.MICProvided != (bits&2 != 0) || flags.UntrustedSPNSource != (bits&4 != 0) {
+			t.Errorf("ParseAvFlags(%x) = %+v", value, flags)
+		}
+		if got := flags.Encode(); !bytes.Equal(got, value) {
+			t.Errorf("Encode = %x, want %x", got, value)
+		}
+	}
+
+	if flags := ParseAvFlags(nil); flags != (AvFlags{}) {
+		t.Errorf("ParseAvFlags(nil) = %+v", flags)
+	}
+}
+
+func TestParseSingleHostData(t *testing.T) {
+	// MsvAvSingleHost, Size 48, CustomData with the integrity level, MachineID
+	machineID := "1a2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f708192a3b4c5d6e7f809"
+	tinfo := decodeHex("08003000" + "30000000" + "00000000" + "0000000000300000" + machineID + "00000000")
+
+	v, ok := ParseAVPair(tinfo)["MsvAvSingleHost"].(SingleHostData)
+	if !ok {
+		t.Fatalf("MsvAvSingleHost = %#v", ParseAVPair(tinfo)["MsvAvSingleHost"])
+	}
+	if hex.EncodeToString(v.CustomData[:]) != "0000000000300000" || hex.EncodeToString(v.MachineID[:]) != machineID {
+		t.Errorf("SingleHostData = %x", v)
+	}
+	if !bytes.Equal(v.Encode(), tinfo[4:52]) {
+		t.Errorf("Encode = %x, want %x", v.Encode(), tinfo[4:52])
+	}
+
+	cm, _ := NewChallengeMsg(nil)
+	cm.SetTargetInfo(map[string]interface{}{"MsvAvSingleHost": v})
+	if !bytes.Equal(cm.TargetInfo(), tinfo) {
+		t.Errorf("SetTargetInfo = %x, want %x", cm.TargetInfo(), tinfo)
+	}
+
+	for _, b := range [][]byte{tinfo[4:51], append([]byte{0x20}, tinfo[5:52]...)} {
+		if _, err := ParseSingleHostData(b); err == nil {
+			t.Errorf("ParseSingleHostData(%x): expected error", b)
+		}
+	}
+}
+
+func TestParseAVPairSafe(t *testing.T) {
+	// MsvAvNbDomainName "Domain", MsvAvNbComputerName "Server", MsvAvEOL
+	tinfo := decodeHex("02000c0044006f006d00610069006e0001000c0053006500720076006500720000000000")
+	pairs, err := ParseAVPairSafe(tinfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pairs["MsvAvNbDomainName"] != "Domain" || pairs["MsvAvNbComputerName"] != "Server" {
+		t.Errorf("pairs = %v", pairs)
+	}
+
+	for name, bs := range map[string][]byte{
+		"empty":       nil,
+		"missing EOL": tinfo[:len(tinfo)-4],
+		"truncated":   tinfo[:len(tinfo)-7],
+		"short EOL":   tinfo[:len(tinfo)-2],
+		"header only": tinfo[:2],
+	} {
+		pairs, err := ParseAVPairSafe(bs)
+		if err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+		// the pairs before the error are returned, and ParseAVPair doesn't panic
+		if len(ParseAVPair(bs)) != len(pairs) {
+			t.Errorf("%s: ParseAVPair = %v, ParseAVPairSafe = %v", name, ParseAVPair(bs), pairs)
+		}
+	}
+}
+
+func TestParseAVPair_Timestamp(t *testing.T) {
+	want := time.Date(2026, 10, 14, 12, 30, 0, 0, time.UTC)
+	tinfo := append(append([]byte{7, 0, 8, 0}, WindowsTimestamp(want)...), 0, 0, 0, 0)
+	got, ok := ParseAVPair(tinfo)["MsvAvTimestamp"].(time.Time)
+	if !ok || !got.Equal(want) {
+		t.Fatalf("MsvAvTimestamp = %#v, want %v", ParseAVPair(tinfo)["MsvAvTimestamp"], want)
+	}
+
+	// the parsed map can be set again
+	cm, _ := NewChallengeMsg(nil)
+	if err := cm.SetTargetInfo(ParseAVPair(tinfo)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cm.TargetInfo(), tinfo) {
+		t.Errorf("TargetInfo = %x, want %x", cm.TargetInfo(), tinfo)
+	}
+
+	// not a FILETIME, kept as is
+	short := []byte{7, 0, 4, 0, 1, 2, 3, 4, 0, 0, 0, 0}
+	if v, ok := ParseAVPair(short)["MsvAvTimestamp"].([]byte); !ok || !bytes.Equal(v, short[4:8]) {
+		t.Errorf("MsvAvTimestamp of 4 bytes = %#v", ParseAVPair(short)["MsvAvTimestamp"])
+	}
+}
+
+func TestParseAVPairInto(t *testing.T) {
+	// MsvAvNbDomainName "Domain", MsvAvNbComputerName "Server", MsvAvEOL
+	tinfo := decodeHex("02000c0044006f006d00610069006e0001000c0053006500720076006500720000000000")
+	var pairs AvPairs
+	for i := 0; i < 2; i++ {
+		if err := ParseAVPairInto(tinfo, &pairs); err != nil {
+			t.Fatal(err)
+		}
+		if len(pairs.List) != 3 || pairs.StringValue(MsvAvNbComputerName) != "Server" {
+			t.Errorf("pass %d: pairs = %v", i, pairs.List)
+		}
+	}
+	if err := ParseAVPairInto(tinfo[:len(tinfo)-4], &pairs); err == nil || len(pairs.List) != 2 {
+		t.Errorf("missing EOL: err %v, %d pairs", err, len(pairs.List))
+	}
+}
+
+// Target info of the IIS challenge in type2_test.go
+var benchTargetInfo = decodeHex("02001e005700570057002d003900460034003600380033004600430045003500420001001e005700570057002d003900460034003600380033004600430045003500420004001e007700770077002d003900660034003600380033006600630065003500620003001e007700770077002d0039006600340036003800330066006300650035006200060004000100000000000000")
+
+func BenchmarkParseAVPair(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ParseAVPair(benchTargetInfo)
+	}
+}
+
+func BenchmarkParseAVPairInto(b *testing.B) {
+	var pairs AvPairs
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ParseAVPairInto(benchTargetInfo, &pairs)
+	}
+}
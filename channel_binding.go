@@ -0,0 +1,45 @@
+package ntlmssp
+
+import (
+	"crypto"
+	"crypto/md5"
+	"crypto/x509"
+	"encoding/binary"
+
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// MsvAvChannelBindings value for NTLM over TLS, the MD5 of a
+// gss_channel_bindings_struct (RFC 2744 3.11) whose application data is
+// the RFC 5929 tls-server-end-point binding of the server certificate.
+// Add it with AvPairs.Set(MsvChannelBindings, hash) before computing the
+// NTLMv2 response, so NTProofStr covers it. Without a TLS channel, a nil
+// certificate, it is the all-zero null binding.
+func ChannelBindingHash(tlsServerCertDER []byte) []byte {
+	if tlsServerCertDER == nil {
+		return make([]byte, 16)
+	}
+
+	// RFC 5929 4.1, MD5 and SHA-1 signatures are hashed with SHA-256
+	hash := crypto.SHA256
+	if cert, err := x509.ParseCertificate(tlsServerCertDER); err == nil {
+		switch cert.SignatureAlgorithm {
+		case x509.SHA384WithRSA, x509.ECDSAWithSHA384, x509.SHA384WithRSAPSS:
+			hash = crypto.SHA384
+		case x509.SHA512WithRSA, x509.ECDSAWithSHA512, x509.SHA512WithRSAPSS:
+			hash = crypto.SHA512
+		}
+	}
+	h := hash.New()
+	h.Write(tlsServerCertDER)
+	appData := append([]byte("tls-server-end-point:"), h.Sum(nil)...)
+
+	// initiator and acceptor addresses are empty
+	bs := make([]byte, 20, 20+len(appData))
+	binary.LittleEndian.PutUint32(bs[16:], uint32(len(appData)))
+	bs = append(bs, appData...)
+
+	sum := md5.Sum(bs)
+	return sum[:]
+}
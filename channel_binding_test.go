@@ -0,0 +1,60 @@
+package ntlmssp
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestChannelBindingHash(t *testing.T) {
+	// self-signed ECDSA P-256 / SHA-256 certificate for server.example.com
+	cert := decodeHex("3082019130820137a003020102021451f9ee73bb4616a1dc128c8919928d2ccdb81e7c300a06082a8648ce3d040302301d311b301906035504030c127365727665722e6578616d706c652e636f6d3020170d3236313031343034303735375a180f32313236303932303034303735375a301d311b301906035504030c127365727665722e6578616d706c652e636f6d3059301306072a8648ce3d020106082a8648ce3d03010703420004f29b784da39eaaee80569681082db1fb245b845ceb8792e62fccc632328d510a0b60937f7846e51346237d35bb71953f5937fd893323c6dab3dd3cba810088daa3533051301d0603551d0e041604148cf9a7be409a8c5ece31203ccd808c89d15c861a301f0603551d230418301680148cf9a7be409a8c5ece31203ccd808c89d15c861a300f0603551d130101ff040530030101ff300a06082a8648ce3d040302034800304502202916ee7b09b4e5c153b4bfbd8f5c89968e5ef981b6934f6abd05d14dbd371fac022100c083d2e22ebbeb092400a47e99403964add5282132670f691b8fdc67b9f40e73")
+	hash := ChannelBindingHash(cert)
+	if got, want := hex.EncodeToString(hash), "e3c77f42c0ba0e871759095da97bd7ec"; got != want {
+		t.Errorf("ChannelBindingHash = %s, want %s", got, want)
+	}
+
+	pairs := new(AvPairs)
+	pairs.Set(MsvChannelBindings, hash)
+	if got, want := hex.EncodeToString(pairs.Marshal()), "0a001000"+hex.EncodeToString(hash)+"00000000"; got != want {
+		t.Errorf("target info = %s, want %s", got, want)
+	}
+}
+
+func TestChannelBindingHash_Null(t *testing.T) {
+	if got := hex.EncodeToString(ChannelBindingHash(nil)); got != "00000000000000000000000000000000" {
+		t.Errorf("ChannelBindingHash(nil) = %s", got)
+	}
+
+	// the channel bindings the client puts in its NTLMv2 response
+	bindings := func(client *Client, tinfo map[string]interface{}) []byte {
+		cm, _ := NewChallengeMsg(nil)
+		cm.NegotiateFlags = NEGOTIATE_UNICODE_CHARSET | NEGOTIATE_NTLM
+		cm.SetServerChallenge(nil)
+		if err := cm.SetTargetInfo(tinfo); err != nil {
+			t.Fatal(err)
+		}
+		client.Negotiate()
+		type3, err := client.ProcessChallenge(cm.Marshal('<'))
+		if err != nil {
+			t.Fatal(err)
+		}
+		am, _ := NewAuthenticateMsg(type3)
+		resp := am.NtChallengeResponseBytes()
+		return ReadAvPairs(resp[44 : len(resp)-4]).Get(MsvChannelBindings)
+	}
+
+	cred := Credentials{User: "User", Domain: "Domain", Password: "Password"}
+	if cb := bindings(NewClient(cred), map[string]interface{}{"MsvAvNbComputerName": "SERVER"}); cb != nil {
+		t.Errorf("unrequested channel bindings %x", cb)
+	}
+	cb := bindings(NewClient(cred), map[string]interface{}{"MsvAvNbComputerName": "SERVER", "MsvAvChannelBindings": decodeHex("ffffffffffffffffffffffffffffffff")})
+	if hex.EncodeToString(cb) != "00000000000000000000000000000000" {
+		t.Errorf("channel bindings = %x, want the null binding", cb)
+	}
+
+	client := NewClient(cred)
+	client.ChannelBindings = decodeHex("e3c77f42c0ba0e871759095da97bd7ec")
+	if cb := bindings(client, map[string]interface{}{"MsvAvNbComputerName": "SERVER"}); hex.EncodeToString(cb) != "e3c77f42c0ba0e871759095da97bd7ec" {
+		t.Errorf("configured channel bindings = %x", cb)
+	}
+}
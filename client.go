@@ -0,0 +1,401 @@
+package ntlmssp
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"time"
+)
+
+const defaultClientFlags = NEGOTIATE_UNICODE_CHARSET | NEGOTIATE_OEM_CHARSET | NEGOTIATE_REQUEST_TARGET_NAME |
+	NEGOTIATE_SIGN | NEGOTIATE_SEAL | NEGOTIATE_NTLM | NEGOTIATE_ALWAYS_SIGN |
+	NEGOTIATE_EXTENDED_SESSION_SECURITY | NEGOTIATE_128BIT_SESSION_KEY |
+	NEGOTIATE_EXPLICIT_KEY_EXCHANGE | NEGOTIATE_56BIT_ENCRYPTION
+
+// Client runs the initiator side of the handshake over any transport:
+// send Negotiate(), answer the server's CHALLENGE_MESSAGE with
+// ProcessChallenge(), then use SecurityContext() for the session.
+type Client struct {
+	Credentials
+	// Sent in both the NEGOTIATE_MESSAGE and the AUTHENTICATE_MESSAGE,
+	// the local computer name if empty, see Hostname
+	Workstation string
+	// MsvAvChannelBindings sent to the server, see ChannelBindingHash. If
+	// nil, the null binding is sent only when the server's target info
+	// has a MsvAvChannelBindings.
+	ChannelBindings []byte
+	// NEGOTIATE_* flags the server must agree to, ProcessChallenge fails
+	// rather than downgrade the session, e.g. NEGOTIATE_SIGN|NEGOTIATE_SEAL
+	RequireFlags uint32
+	// Negotiate the OEM charset only, for legacy servers without Unicode.
+	// The names in the AUTHENTICATE_MESSAGE are then raw OEM bytes, the
+	// NTLMv2 hash is computed over UTF-16 as always.
+	OEM bool
+	// Send neither the MIC nor the MIC provided bit in MsvAvFlags, even if
+	// the server sends MsvAvTimestamp. The AUTHENTICATE_MESSAGE then has
+	// its payload right after the 64 bytes header, the layout of Windows
+	// Server 2003 and earlier that some legacy servers insist on.
+	NoMIC bool
+	// MsvAvSingleHost sent in the NTLMv2 response. NEGOTIATE_LOCAL_CALL
+	// is requested with it, a server on the same machine answers with its
+	// context handle, see LocalContext.
+	SingleHost *SingleHostData
+	// Accept an all-zero ServerChallenge, for testing against broken
+	// servers only: responses to a fixed challenge can be precomputed
+	AllowWeakChallenge bool
+	// Sent with NEGOTIATE_VERSION in the NEGOTIATE_MESSAGE and the
+	// AUTHENTICATE_MESSAGE, e.g. to look like a given Windows release.
+	// No Version block if nil.
+	Version *Version
+	// Fail on a challenge without MsvAvTimestamp and keep a SHA-256 of
+	// the server's target info, see ServerTimestamp and
+	// ServerTargetInfoHash. Weak assurance that the challenge is fresh.
+	VerifyServerTargetInfo bool
+	// Send an NTLMv1 response, the NTLM2 session response if the server
+	// negotiates NEGOTIATE_EXTENDED_SESSION_SECURITY, for legacy servers
+	// without NTLMv2. ChannelBindings, SingleHost and the MIC travel in
+	// the NTLMv2 response and are not sent then.
+	NTLMv1 bool
+
+	negotiateMsg    []byte
+	flags           uint32
+	sessionKey      []byte
+	localContext    []byte
+	serverTimestamp time.Time
+	targetInfoHash  []byte
+	ctx             *SecurityContext
+}
+
+func NewClient(cred Credentials) *Client {
+	return &Client{Credentials: cred}
+}
+
+func (c *Client) workstation() string {
+	if c.Workstation != "" {
+		return c.Workstation
+	}
+	return localComputerName()
+}
+
+func (c *Client) clientFlags() uint32 {
+	flags := uint32(defaultClientFlags) | c.RequireFlags
+	if c.OEM {
+		flags &^= NEGOTIATE_UNICODE_CHARSET
+	}
+	if c.SingleHost != nil {
+		flags |= NEGOTIATE_LOCAL_CALL
+	}
+	if c.Version != nil {
+		flags |= NEGOTIATE_VERSION
+	}
+	return flags
+}
+
+// NEGOTIATE_MESSAGE, the first leg of the handshake
+func (c *Client) Negotiate() []byte {
+	type1, _ := NewNegotiateMsg(nil)
+	type1.NegotiateFlags = c.clientFlags()
+	if c.Version != nil {
+		type1.SetVersion(*c.Version)
+	}
+	// OEM, the charset is not negotiated yet
+	type1.SetWorkstation([]byte(c.workstation()))
+	c.negotiateMsg = type1.Bytes()
+	return c.negotiateMsg
+}
+
+// AUTHENTICATE_MESSAGE for the server's CHALLENGE_MESSAGE, with an
+// NTLMv2 response unless NTLMv1 is set. A MIC is added to an NTLMv2
+// response when the server sends MsvAvTimestamp, as required by MS-NLMP
+// 3.1.5.1.2, unless NoMIC is set. Empty Credentials authenticate
+// anonymously.
+func (c *Client) ProcessChallenge(type2 []byte) ([]byte, error) {
+	if c.negotiateMsg == nil {
+		return nil, fmt.Errorf("ntlmssp: ProcessChallenge called before Negotiate")
+	}
+	cm, err := NewChallengeMsg(type2)
+	if err != nil {
+		return nil, err
+	}
+	if err := cm.Validate(); err != nil {
+		return nil, err
+	}
+	if cm.ServerChallenge == [8]byte{} && !c.AllowWeakChallenge {
+		return nil, fmt.Errorf("%w: all-zero server challenge", ErrMalformedMessage)
+	}
+
+	flags := cm.NegotiateFlags & (c.clientFlags() | NEGOTIATE_TARGET_INFO)
+	if flags&NEGOTIATE_NTLM == 0 {
+		return nil, fmt.Errorf("%w: server did not negotiate NTLM", ErrFlagDowngrade)
+	}
+	if flags&(NEGOTIATE_UNICODE_CHARSET|NEGOTIATE_OEM_CHARSET) == 0 {
+		return nil, fmt.Errorf("%w: server did not negotiate a charset", ErrFlagDowngrade)
+	}
+	if missing := c.RequireFlags &^ flags; missing != 0 {
+		return nil, fmt.Errorf("%w: server did not negotiate %#x", ErrFlagDowngrade, missing)
+	}
+
+	// MS-NLMP 2.2.1.2, TargetInfoFields is ignored without
+	// NEGOTIATE_TARGET_INFO. Without target info the NTLMv2 blob gets a
+	// lone MsvAvEOL and the client's own timestamp.
+	serverTargetInfo := cm.TargetInfo()
+	if cm.NegotiateFlags&NEGOTIATE_TARGET_INFO == 0 {
+		serverTargetInfo = nil
+	}
+	serverTimestamp, hasTimestamp := time.Time{}, false
+	if serverTargetInfo != nil {
+		if pairs, err := ParseAVPairsOrdered(serverTargetInfo); err == nil {
+			serverTimestamp, hasTimestamp = pairs.Timestamp()
+		}
+	}
+	if c.VerifyServerTargetInfo && !hasTimestamp {
+		return nil, fmt.Errorf("ntlmssp: no MsvAvTimestamp in the server's target info")
+	}
+
+	var lmresp, ntresp, keyExchangeKey []byte
+	useMIC := false
+	if c.anonymous() {
+		// MS-NLMP 3.3.2, the session base key of anonymous is Z(16)
+		flags |= NEGOTIATE_ANONYMOUS
+		keyExchangeKey = make([]byte, 16)
+	} else if c.NTLMv1 {
+		ntHash, err := c.ntHash()
+		if err != nil {
+			return nil, err
+		}
+		lmresp, ntresp, keyExchangeKey = c.ntlmv1Responses(flags, ntHash, cm.ServerChallenge[:])
+	} else {
+		ntHash, err := c.ntHash()
+		if err != nil {
+			return nil, err
+		}
+
+		pairs := &AvPairs{List: []AvPair{{AvId: MsvAvEOL}}}
+		if serverTargetInfo != nil {
+			if pairs, err = ParseAVPairsOrdered(serverTargetInfo); err != nil {
+				return nil, err
+			}
+		}
+
+		// echo the server's timestamp exactly
+		timestamp := pairs.Get(MsvAvTimestamp)
+		if !hasTimestamp {
+			timestamp = WindowsTimestamp(time.Now())
+		}
+		useMIC = hasTimestamp && !c.NoMIC
+		if useMIC {
+			pairs.SetMICFlag()
+		}
+		additions := new(AvPairs)
+		if c.ChannelBindings != nil {
+			additions.Set(MsvChannelBindings, c.ChannelBindings)
+		} else if pairs.Get(MsvChannelBindings) != nil {
+			additions.Set(MsvChannelBindings, ChannelBindingHash(nil))
+		}
+		if c.SingleHost != nil {
+			additions.Set(MsAvRestrictions, c.SingleHost.Encode())
+		}
+		pairs = MergeTargetInfo(pairs, additions)
+
+		clientChallenge := make([]byte, 8)
+		io.ReadFull(Rand, clientChallenge)
+
+		// NTLMv2 KXKEY is the session base key
+		ntowf := ntowfv2(ntHash, c.User, c.Domain)
+		ntresp, keyExchangeKey = ComputeNTLMv2Response(ntowf, cm.ServerChallenge[:], clientChallenge, timestamp, pairs.Marshal())
+		lmresp = LMResponseForV2(ntowf, cm.ServerChallenge[:], clientChallenge, hasTimestamp)
+	}
+
+	exportedSessionKey := keyExchangeKey
+	var encryptedSessionKey []byte
+	if flags&NEGOTIATE_EXPLICIT_KEY_EXCHANGE != 0 {
+		exportedSessionKey = NewExportedSessionKey()
+		encryptedSessionKey = EncryptSessionKey(keyExchangeKey, exportedSessionKey)
+	}
+
+	type3, _ := NewAuthenticateMsg(nil)
+	type3.NegotiateFlags = flags
+	// only if the server kept NEGOTIATE_VERSION
+	if c.Version != nil && flags&NEGOTIATE_VERSION != 0 {
+		type3.SetVersion(*c.Version)
+	}
+	if useMIC {
+		type3.ReserveMIC()
+	}
+	if c.anonymous() {
+		type3.SetAnonymous()
+	} else {
+		type3.SetLmChallengeResponse(lmresp)
+		type3.SetNtChallengeResponse(ntresp)
+	}
+	type3.SetDomainName([]byte(c.Domain))
+	type3.SetUserName([]byte(c.User))
+	type3.SetWorkstation([]byte(c.workstation()))
+	type3.SetEncryptedRandomSessionKey(encryptedSessionKey)
+	if useMIC {
+		if err := type3.SetMIC(exportedSessionKey, c.negotiateMsg, type2); err != nil {
+			return nil, err
+		}
+	}
+
+	c.flags = flags
+	c.sessionKey = exportedSessionKey
+	c.localContext = nil
+	if flags&NEGOTIATE_LOCAL_CALL != 0 {
+		c.localContext = append([]byte{}, cm.Reserved[:]...)
+	}
+	c.serverTimestamp = serverTimestamp
+	c.targetInfoHash = nil
+	if c.VerifyServerTargetInfo {
+		sum := sha256.Sum256(serverTargetInfo)
+		c.targetInfoHash = sum[:]
+	}
+	c.ctx = nil
+	return type3.Bytes(), nil
+}
+
+// MS-NLMP 3.3.1 LM and NT responses and their KXKEY. Without the LM hash
+// the NT response is sent twice, as with NoLMResponseNTLMv1.
+func (c *Client) ntlmv1Responses(flags uint32, ntHash, serverChallenge []byte) (lm, nt, keyExchangeKey []byte) {
+	lmHash := c.LMOWFv1()
+	if flags&NEGOTIATE_EXTENDED_SESSION_SECURITY != 0 {
+		clientChallenge := make([]byte, 8)
+		io.ReadFull(Rand, clientChallenge)
+		lm, nt = ComputeNTLM2SessionResponse(ntHash, serverChallenge, clientChallenge)
+	} else {
+		nt = ComputeNTv1Response(ntHash, serverChallenge)
+		lm = nt
+		if lmHash != nil {
+			lm = ComputeLMv1Response(lmHash, serverChallenge)
+		}
+	}
+	return lm, nt, KXKey(flags, md4Hash(ntHash), lm, serverChallenge, lmHash)
+}
+
+// Flags negotiated with the server, 0 before ProcessChallenge
+func (c *Client) NegotiatedFlags() uint32 {
+	return c.flags
+}
+
+// Server context handle of a NEGOTIATE_LOCAL_CALL challenge, nil if the
+// server is not on the same machine or did not agree to it
+func (c *Client) LocalContext() []byte {
+	return c.localContext
+}
+
+// MsvAvTimestamp of the last challenge, false if the server sent none.
+// Compare it with the local clock to spot stale or replayed challenges.
+func (c *Client) ServerTimestamp() (time.Time, bool) {
+	return c.serverTimestamp, !c.serverTimestamp.IsZero()
+}
+
+// SHA-256 of the target info of the last challenge, nil unless
+// VerifyServerTargetInfo is set
+func (c *Client) ServerTargetInfoHash() []byte {
+	return c.targetInfoHash
+}
+
+// ExportedSessionKey of the handshake, nil before ProcessChallenge. It is
+// the random key sent encrypted with NEGOTIATE_EXPLICIT_KEY_EXCHANGE and
+// the KeyExchangeKey otherwise, 16 bytes either way.
+func (c *Client) SessionKey() []byte {
+	return c.sessionKey
+}
+
+// Signing and sealing context of the established session, nil before
+// ProcessChallenge
+func (c *Client) SecurityContext() *SecurityContext {
+	if c.sessionKey == nil {
+		return nil
+	}
+	if c.ctx == nil {
+		c.ctx = NewSecurityContext(c.flags, c.sessionKey, "Client")
+	}
+	return c.ctx
+}
+
+// Overwrite the NT hash of the Credentials, the hashes of Prepare, the
+// session key and the SecurityContext keys with zeros, e.g. with defer
+// once the session is over. Slices returned before, such as SessionKey,
+// are zeroed too.
+func (c *Client) Wipe() {
+	zero(c.NTHash)
+	c.Credentials.Wipe()
+	zero(c.sessionKey)
+	if c.ctx != nil {
+		c.ctx.Wipe()
+	}
+}
+
+// Option configures the Client used by BuildType3
+type Option func(*Client)
+
+// Client.ChannelBindings
+func ChannelBindings(cb []byte) Option {
+	return func(c *Client) { c.ChannelBindings = cb }
+}
+
+// Client.RequireFlags
+func RequireFlags(flags uint32) Option {
+	return func(c *Client) { c.RequireFlags = flags }
+}
+
+// Client.OEM
+func OEM() Option {
+	return func(c *Client) { c.OEM = true }
+}
+
+// Client.NoMIC
+func NoMIC() Option {
+	return func(c *Client) { c.NoMIC = true }
+}
+
+// Client.Version
+func ClientVersion(v Version) Option {
+	return func(c *Client) { c.Version = &v }
+}
+
+// Client.Workstation, instead of the workstation argument of BuildType3
+func Workstation(name string) Option {
+	return func(c *Client) { c.Workstation = name }
+}
+
+// Client.NTLMv1
+func NTLMv1() Option {
+	return func(c *Client) { c.NTLMv1 = true }
+}
+
+// Client.VerifyServerTargetInfo
+func VerifyServerTargetInfo() Option {
+	return func(c *Client) { c.VerifyServerTargetInfo = true }
+}
+
+// Client.AllowWeakChallenge
+func AllowWeakChallenge() Option {
+	return func(c *Client) { c.AllowWeakChallenge = true }
+}
+
+// The NEGOTIATE_MESSAGE sent to the server, covered by the MIC. By default
+// it is the one Client.Negotiate() returns for the same options.
+func NegotiateMessage(type1 []byte) Option {
+	return func(c *Client) { c.negotiateMsg = type1 }
+}
+
+// AUTHENTICATE_MESSAGE for type2 in one call, with the ExportedSessionKey
+// for NewSecurityContext
+func BuildType3(type2 []byte, cred Credentials, workstation string, opts ...Option) (type3 []byte, sessionKey []byte, err error) {
+	c := NewClient(cred)
+	c.Workstation = workstation
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.negotiateMsg == nil {
+		c.Negotiate()
+	}
+
+	type3, err = c.ProcessChallenge(type2)
+	if err != nil {
+		return nil, nil, err
+	}
+	return type3, c.SessionKey(), nil
+}
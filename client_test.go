@@ -0,0 +1,624 @@
+package ntlmssp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestClient(t *testing.T) {
+	client := NewClient(Credentials{User: "User", Domain: "Domain", Password: "Password"})
+	type1 := client.Negotiate()
+	nm, err := NewNegotiateMsg(type1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	timestamp := make([]byte, 8)
+	binary.LittleEndian.PutUint64(timestamp, timeToFileTime(time.Now()))
+	cm, _ := NewChallengeMsg(nil)
+	cm.NegotiateFlags = nm.NegotiateFlags | NEGOTIATE_TARGET_INFO
+	cm.SetServerChallenge(nil)
+	cm.SetTargetInfo(map[string]interface{}{
+		"MsvAvNbDomainName":   "Domain",
+		"MsvAvNbComputerName": "Server",
+		"MsvAvTimestamp":      timestamp,
+	})
+	type2 := cm.Marshal('<')
+
+	bs, err := client.ProcessChallenge(type2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	am, err := NewAuthenticateMsg(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if am.UserName() != "User" || am.DomainName() != "Domain" {
+		t.Errorf("UserName = %q, DomainName = %q", am.UserName(), am.DomainName())
+	}
+	if !bytes.Equal(am.LmChallengeResponse(), make([]byte, 24)) {
+		t.Errorf("LmChallengeResponse = %x, want Z(24) with MsvAvTimestamp", am.LmChallengeResponse())
+	}
+
+	resp := am.NtChallengeResponseBytes()
+	expected, sessionBaseKey := ComputeNTLMv2Response(NTOWFv2("Password", "User", "Domain"),
+		cm.ServerChallenge[:], resp[32:40], resp[24:32], resp[44:len(resp)-4])
+	if !bytes.Equal(expected, resp) {
+		t.Fatal("NTLMv2 response does not verify")
+	}
+	if !ParseAvFlags(ReadAvPairs(resp[44:]).ByteValue(MsvAvFlags)).MICProvided {
+		t.Error("MIC provided bit not set")
+	}
+
+	sessionKey := DecryptSessionKey(sessionBaseKey, am.EncryptedRandomSessionKey())
+	if !bytes.Equal(sessionKey, client.SessionKey()) {
+		t.Fatalf("session key = %x, want %x", sessionKey, client.SessionKey())
+	}
+	if ok, err := VerifyMIC(am, sessionKey, type1, type2); !ok || err != nil {
+		t.Errorf("VerifyMIC = %v, %v", ok, err)
+	}
+
+	server := NewSecurityContext(am.NegotiateFlags, sessionKey, "Server")
+	sealed, signature, err := client.SecurityContext().Seal([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := server.Unseal(sealed, signature); err != nil || string(msg) != "hello" {
+		t.Errorf("Unseal = %q, %v", msg, err)
+	}
+}
+
+func TestClient_ProcessChallengeErrors(t *testing.T) {
+	client := NewClient(Credentials{User: "User", Domain: "Domain", Password: "Password"})
+	cm, _ := NewChallengeMsg(nil)
+	cm.SetServerChallenge(nil)
+	cm.NegotiateFlags = NEGOTIATE_UNICODE_CHARSET | NEGOTIATE_NTLM
+	if _, err := client.ProcessChallenge(cm.Marshal('<')); err == nil {
+		t.Error("ProcessChallenge before Negotiate: expected error")
+	}
+
+	client.Negotiate()
+	if _, err := client.ProcessChallenge([]byte("NTLMSSP\x00")); err == nil {
+		t.Error("truncated challenge: expected error")
+	}
+	cm.NegotiateFlags = NEGOTIATE_UNICODE_CHARSET
+	if _, err := client.ProcessChallenge(cm.Marshal('<')); err == nil {
+		t.Error("challenge without NEGOTIATE_NTLM: expected error")
+	}
+	if client.SecurityContext() != nil {
+		t.Error("SecurityContext before a successful handshake")
+	}
+}
+
+func TestClient_PassTheHash(t *testing.T) {
+	byPassword := Credentials{User: "User", Domain: "Domain", Password: "Password"}
+	byHash := Credentials{User: "User", Domain: "Domain", NTHash: NtHash([]byte("Password"))}
+
+	h1, err1 := byPassword.ntHash()
+	h2, err2 := byHash.ntHash()
+	if err1 != nil || err2 != nil || !bytes.Equal(h1, h2) {
+		t.Fatalf("ntHash = %x, %v and %x, %v", h1, err1, h2, err2)
+	}
+
+	// MS-NLMP 4.2.4.2.2, the NTLMv2 response only depends on the hash
+	serverChallenge := decodeHex("0123456789abcdef")
+	clientChallenge := decodeHex("aaaaaaaaaaaaaaaa")
+	timestamp := make([]byte, 8)
+	targetInfo := decodeHex("02000c0044006f006d00610069006e0001000c0053006500720076006500720000000000")
+	r1, k1 := ComputeNTLMv2Response(NTOWFv2("Password", "User", "Domain"), serverChallenge, clientChallenge, timestamp, targetInfo)
+	r2, k2 := ComputeNTLMv2Response(ntowfv2(h2, "User", "Domain"), serverChallenge, clientChallenge, timestamp, targetInfo)
+	if !bytes.Equal(r1, r2) || !bytes.Equal(k1, k2) {
+		t.Error("password and hash responses differ")
+	}
+
+	server := NewServer()
+	server.SetCredentials("User", "Domain", NtHash([]byte("Password")))
+	if _, err := handshake(NewClient(byHash), server); err != nil {
+		t.Errorf("pass-the-hash handshake: %v", err)
+	}
+
+	both := byHash
+	both.Password = "Password"
+	if _, err := both.ntHash(); err == nil {
+		t.Error("Password and NTHash together: expected error")
+	}
+}
+
+func TestClient_RequireFlags(t *testing.T) {
+	client := NewClient(Credentials{User: "User", Domain: "Domain", Password: "Password"})
+	client.RequireFlags = NEGOTIATE_SIGN | NEGOTIATE_SEAL
+	client.Negotiate()
+
+	cm, _ := NewChallengeMsg(nil)
+	cm.SetServerChallenge(nil)
+	// the server drops NEGOTIATE_SEAL
+	cm.NegotiateFlags = NEGOTIATE_UNICODE_CHARSET | NEGOTIATE_NTLM | NEGOTIATE_EXTENDED_SESSION_SECURITY | NEGOTIATE_SIGN
+	if _, err := client.ProcessChallenge(cm.Marshal('<')); err == nil {
+		t.Error("challenge without NEGOTIATE_SEAL: expected error")
+	}
+
+	cm.NegotiateFlags |= NEGOTIATE_SEAL
+	type3, err := client.ProcessChallenge(cm.Marshal('<'))
+	if err != nil {
+		t.Fatal(err)
+	}
+	am, _ := NewAuthenticateMsg(type3)
+	if am.NegotiateFlags&(NEGOTIATE_SIGN|NEGOTIATE_SEAL) != NEGOTIATE_SIGN|NEGOTIATE_SEAL || am.NegotiateFlags&^cm.NegotiateFlags != 0 {
+		t.Errorf("type3 flags = %x, challenge flags = %x", am.NegotiateFlags, cm.NegotiateFlags)
+	}
+}
+
+func TestClient_OEM(t *testing.T) {
+	server := NewServer()
+	server.SetCredentials("User", "Domain", NtHash([]byte("Password")))
+
+	client := NewClient(Credentials{User: "User", Domain: "Domain", Password: "Password"})
+	client.Workstation = "WS"
+	client.OEM = true
+	type1 := client.Negotiate()
+	if nm, _ := NewNegotiateMsg(type1); nm.NegotiateFlags&NEGOTIATE_UNICODE_CHARSET != 0 {
+		t.Errorf("NEGOTIATE_MESSAGE flags %x offer Unicode", nm.NegotiateFlags)
+	}
+
+	type2, err := server.Challenge(type1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm, _ := NewChallengeMsg(type2)
+	if cm.SupportsUnicode() || cm.NegotiateFlags&NEGOTIATE_OEM_CHARSET == 0 || cm.TargetName() != "Domain" {
+		t.Errorf("challenge flags %x, TargetName %q", cm.NegotiateFlags, cm.TargetName())
+	}
+
+	type3, err := client.ProcessChallenge(type2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	am, _ := NewAuthenticateMsg(type3)
+	if string(am.UserNameBytes()) != "User" || string(am.DomainNameBytes()) != "Domain" || string(am.WorkstationBytes()) != "WS" {
+		t.Errorf("OEM fields %q, %q, %q", am.UserNameBytes(), am.DomainNameBytes(), am.WorkstationBytes())
+	}
+	if _, err := server.Authenticate(type3); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBuildType3(t *testing.T) {
+	cred := Credentials{User: "User", Domain: "Domain", Password: "Password"}
+	server := NewServer()
+	server.SetCredentials("User", "Domain", NtHash([]byte("Password")))
+
+	type2, err := server.Challenge((&Client{Credentials: cred, Workstation: "WS"}).Negotiate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	type3, sessionKey, err := BuildType3(type2, cred, "WS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := server.Authenticate(type3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Workstation != "WS" || !bytes.Equal(session.SessionKey, sessionKey) {
+		t.Errorf("session = %+v, sessionKey = %x", session, sessionKey)
+	}
+
+	// with options, the NEGOTIATE_MESSAGE actually sent is passed along
+	client := NewClient(cred)
+	client.OEM = true
+	type1 := client.Negotiate()
+	if type2, err = server.Challenge(type1); err != nil {
+		t.Fatal(err)
+	}
+	type3, _, err = BuildType3(type2, cred, "WS", OEM(), RequireFlags(NEGOTIATE_SEAL), NegotiateMessage(type1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Authenticate(type3); err != nil {
+		t.Error(err)
+	}
+
+	if _, _, err := BuildType3(type2, cred, "WS", RequireFlags(NEGOTIATE_DATAGRAM_CONNECTIONLESS)); err == nil {
+		t.Error("BuildType3 with an unsatisfied RequireFlags: expected error")
+	}
+}
+
+func TestClient_NoMIC(t *testing.T) {
+	cred := Credentials{User: "User", Domain: "Domain", Password: "Password"}
+	server := NewServer()
+	server.SetCredentials("User", "Domain", NtHash([]byte("Password")))
+	type2, err := server.Challenge((&Client{Credentials: cred, Workstation: "WS"}).Negotiate())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withMIC, _, err := BuildType3(type2, cred, "WS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	noMIC, _, err := BuildType3(type2, cred, "WS", NoMIC())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name     string
+		type3    []byte
+		opts     []Option
+		hasMIC   bool
+		offset   uint32
+		addedLen uint16
+	}{
+		// empty Version and MIC in front of the payload, MsvAvFlags added
+		{"MIC", withMIC, nil, true, AuthenticateMsgPayloadOffset + 8 + 16, 8},
+		{"no MIC", noMIC, []Option{NoMIC()}, false, AuthenticateMsgPayloadOffset, 0},
+	}
+	cm, _ := NewChallengeMsg(type2)
+	for _, c := range cases {
+		am, err := NewAuthenticateMsg(c.type3)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if (am.MIC() != nil) != c.hasMIC {
+			t.Errorf("%s: MIC = %x", c.name, am.MIC())
+		}
+		if am.LmChallengeResponseBufferOffset != c.offset {
+			t.Errorf("%s: LmChallengeResponseBufferOffset = %d, want %d", c.name, am.LmChallengeResponseBufferOffset, c.offset)
+		}
+		// NTProofStr, blob header and trailing Z(4) around the target info
+		if want := 16 + 28 + uint16(len(cm.TargetInfo())) + c.addedLen + 4; am.NtChallengeResponseLen != want || am.NtChallengeResponseMaxLen != want {
+			t.Errorf("%s: NtChallengeResponseLen = %d, MaxLen = %d, want %d", c.name, am.NtChallengeResponseLen, am.NtChallengeResponseMaxLen, want)
+		}
+		// a challenge per AUTHENTICATE_MESSAGE
+		fresh, err := server.Challenge((&Client{Credentials: cred, Workstation: "WS"}).Negotiate())
+		if err != nil {
+			t.Fatal(err)
+		}
+		type3, _, err := BuildType3(fresh, cred, "WS", c.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := server.Authenticate(type3); err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		}
+	}
+
+	am, _ := NewAuthenticateMsg(noMIC)
+	_, blob, err := ParseNTLMv2ResponseBlob(am.NtChallengeResponseBytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags := blob.TargetInfo.Get(MsvAvFlags); flags != nil {
+		t.Errorf("MsvAvFlags = %x, want none", flags)
+	}
+}
+
+func TestClient_WeakChallenge(t *testing.T) {
+	cm, _ := NewChallengeMsg(nil)
+	cm.NegotiateFlags = NEGOTIATE_UNICODE_CHARSET | NEGOTIATE_NTLM | NEGOTIATE_EXTENDED_SESSION_SECURITY
+	cred := Credentials{User: "User", Domain: "Domain", Password: "Password"}
+
+	if _, _, err := BuildType3(cm.Bytes(), cred, "WS"); err == nil {
+		t.Error("all-zero server challenge: expected error")
+	}
+	if _, _, err := BuildType3(cm.Bytes(), cred, "WS", AllowWeakChallenge()); err != nil {
+		t.Errorf("AllowWeakChallenge: %v", err)
+	}
+}
+
+func TestClient_Version(t *testing.T) {
+	v := Version{MajorVersion: WindowsMajorVersion10, MinorVersion: WindowsMinorVersion0, BuildNumber: 19041}
+	cred := Credentials{User: "User", Domain: "Domain", Password: "Password"}
+
+	for _, c := range []struct {
+		version *Version
+		noMIC   bool
+		// offset of the first payload field of each message
+		type1, type2, type3 uint32
+	}{
+		{nil, false, NegotiateMsgPayloadOffset, ChallengeMsgPayloadOffset, AuthenticateMsgPayloadOffset + 8 + 16},
+		{&v, false, NegotiateMsgPayloadOffset + 8, ChallengeMsgPayloadOffset + 8, AuthenticateMsgPayloadOffset + 8 + 16},
+		{nil, true, NegotiateMsgPayloadOffset, ChallengeMsgPayloadOffset, AuthenticateMsgPayloadOffset},
+		{&v, true, NegotiateMsgPayloadOffset + 8, ChallengeMsgPayloadOffset + 8, AuthenticateMsgPayloadOffset + 8},
+	} {
+		name := fmt.Sprintf("version %v, no MIC %v", c.version != nil, c.noMIC)
+		server := NewServer()
+		server.SetCredentials("User", "Domain", NtHash([]byte("Password")))
+		server.Version = c.version
+		client := NewClient(cred)
+		client.Workstation = "WS"
+		client.Version = c.version
+		client.NoMIC = c.noMIC
+
+		type1 := client.Negotiate()
+		nm, _ := NewNegotiateMsg(type1)
+		type2, err := server.Challenge(type1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cm, _ := NewChallengeMsg(type2)
+		type3, err := client.ProcessChallenge(type2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		am, _ := NewAuthenticateMsg(type3)
+
+		if nm.WorkstationBufferOffset != c.type1 {
+			t.Errorf("%s: Workstation at %d, want %d", name, nm.WorkstationBufferOffset, c.type1)
+		}
+		if cm.TargetNameBufferOffset != c.type2 || cm.TargetInfoBufferOffset != c.type2+uint32(cm.TargetNameLen) {
+			t.Errorf("%s: TargetName at %d, TargetInfo at %d", name, cm.TargetNameBufferOffset, cm.TargetInfoBufferOffset)
+		}
+		if am.LmChallengeResponseBufferOffset != c.type3 {
+			t.Errorf("%s: LmChallengeResponse at %d, want %d", name, am.LmChallengeResponseBufferOffset, c.type3)
+		}
+		var want []byte
+		if c.version != nil {
+			marshaled := v.Marshal()
+			want = marshaled[:]
+		}
+		for i, msg := range []struct {
+			flags   uint32
+			version []byte
+		}{{nm.NegotiateFlags, nm.Version()}, {cm.NegotiateFlags, cm.Version()}, {am.NegotiateFlags, am.Version()}} {
+			if (msg.flags&NEGOTIATE_VERSION != 0) != (c.version != nil) || !bytes.Equal(msg.version, want) {
+				t.Errorf("%s: type%d Version = %x, flags %x", name, i+1, msg.version, msg.flags)
+			}
+		}
+		for _, err := range []error{nm.Validate(), cm.Validate(), am.Validate()} {
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+			}
+		}
+		if _, err := server.Authenticate(type3); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestClient_VersionCleared(t *testing.T) {
+	v := Version{MajorVersion: WindowsMajorVersion10, MinorVersion: WindowsMinorVersion0, BuildNumber: 19041}
+	server := NewServer()
+	server.SetCredentials("User", "Domain", NTHash("Password"))
+	client := NewClient(Credentials{User: "User", Domain: "Domain", Password: "Password"})
+	client.Version = &v
+
+	// the server has no Version, it clears NEGOTIATE_VERSION
+	type1 := client.Negotiate()
+	type2, err := server.Challenge(type1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type3, err := client.ProcessChallenge(type2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	am, _ := NewAuthenticateMsg(type3)
+	if am.NegotiateFlags&NEGOTIATE_VERSION != 0 || am.Version() != nil {
+		t.Errorf("Version = %x, flags %x", am.Version(), am.NegotiateFlags)
+	}
+	report, err := ValidateTranscript(type1, type2, type3, "Password", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.FlagsConsistent || !report.MICValid {
+		t.Errorf("report %+v", report)
+	}
+	if _, err := server.Authenticate(type3); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClient_NTLMv1(t *testing.T) {
+	// the server offers NEGOTIATE_EXTENDED_SESSION_SECURITY, cleared for
+	// a plain NTLMv1 response
+	noESS := func(type2 []byte) []byte {
+		bs := append([]byte{}, type2...)
+		binary.LittleEndian.PutUint32(bs[20:], binary.LittleEndian.Uint32(bs[20:])&^NEGOTIATE_EXTENDED_SESSION_SECURITY)
+		return bs
+	}
+	password := Credentials{User: "User", Domain: "Domain", Password: "Password"}
+	hash := Credentials{User: "User", Domain: "Domain", NTHash: NTHash("Password")}
+
+	for _, c := range []struct {
+		name      string
+		cred      Credentials
+		challenge func([]byte) []byte
+		level     AuthLevel
+		typ       string
+		err       error
+	}{
+		{"NTLM2 session", password, nil, AuthLevelNTLMv1, "NTLM2Session", nil},
+		{"NTLMv1", password, noESS, AuthLevelNTLMv1, "NTLMv1", nil},
+		{"NTLMv1 NT hash", hash, noESS, AuthLevelNTLMv1, "NTLMv1", nil},
+		{"NTLMv1 with NTLM2 session", password, noESS, AuthLevelNTLM2Session, "NTLMv1", ErrAuthLevel},
+		{"NTLM2 session by default", password, nil, 0, "NTLM2Session", ErrAuthLevel},
+	} {
+		server := NewServer()
+		server.SetCredentials("User", "Domain", NTHash("Password"))
+		server.MinAuthLevel = c.level
+		client := NewClient(c.cred)
+		client.NTLMv1 = true
+
+		type2, err := server.Challenge(client.Negotiate())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.challenge != nil {
+			type2 = c.challenge(type2)
+		}
+		type3, err := client.ProcessChallenge(type2)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		am, _ := NewAuthenticateMsg(type3)
+		if am.ResponseType() != c.typ || am.MIC() != nil {
+			t.Errorf("%s: ResponseType = %s, MIC = %x", c.name, am.ResponseType(), am.MIC())
+		}
+
+		session, err := server.Authenticate(type3)
+		if !errors.Is(err, c.err) {
+			t.Errorf("%s: err = %v, want %v", c.name, err, c.err)
+			continue
+		}
+		if err == nil && !bytes.Equal(session.SessionKey, client.SessionKey()) {
+			t.Errorf("%s: SessionKey = %x, client %x", c.name, session.SessionKey, client.SessionKey())
+		}
+	}
+
+	server := NewServer()
+	server.SetCredentials("User", "Domain", NTHash("Password"))
+	server.MinAuthLevel = AuthLevelNTLMv1
+	type2, err := server.Challenge(NewClient(password).Negotiate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	type3, sessionKey, err := BuildType3(type2, password, "WS", NTLMv1())
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := server.Authenticate(type3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(session.SessionKey, sessionKey) {
+		t.Errorf("BuildType3: SessionKey = %x, want %x", sessionKey, session.SessionKey)
+	}
+}
+
+func TestClient_ServerTimestamp(t *testing.T) {
+	serverTime := time.Date(2026, 10, 14, 9, 0, 0, 0, time.UTC)
+	server := NewServer()
+	server.SetCredentials("User", "Domain", NtHash([]byte("Password")))
+	server.Now = func() time.Time { return serverTime }
+
+	client := NewClient(Credentials{User: "User", Domain: "Domain", Password: "Password"})
+	client.VerifyServerTargetInfo = true
+	if _, ok := client.ServerTimestamp(); ok {
+		t.Error("ServerTimestamp before ProcessChallenge")
+	}
+	type2, err := server.Challenge(client.Negotiate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ProcessChallenge(type2); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := client.ServerTimestamp(); !ok || !got.Equal(serverTime) {
+		t.Errorf("ServerTimestamp = %v, %v, want %v", got, ok, serverTime)
+	}
+	cm, _ := NewChallengeMsg(type2)
+	if sum := sha256.Sum256(cm.TargetInfo()); !bytes.Equal(client.ServerTargetInfoHash(), sum[:]) {
+		t.Errorf("ServerTargetInfoHash = %x, want %x", client.ServerTargetInfoHash(), sum)
+	}
+
+	// no target info at all
+	cm, _ = NewChallengeMsg(nil)
+	cm.NegotiateFlags = NEGOTIATE_UNICODE_CHARSET | NEGOTIATE_NTLM
+	cm.SetServerChallenge(nil)
+	cred := Credentials{User: "User", Domain: "Domain", Password: "Password"}
+	if _, _, err := BuildType3(cm.Bytes(), cred, "WS", VerifyServerTargetInfo()); err == nil {
+		t.Error("VerifyServerTargetInfo without MsvAvTimestamp: expected error")
+	}
+	client = NewClient(cred)
+	client.Negotiate()
+	if _, err := client.ProcessChallenge(cm.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := client.ServerTimestamp(); ok || client.ServerTargetInfoHash() != nil {
+		t.Error("ServerTimestamp or ServerTargetInfoHash without target info")
+	}
+}
+
+func TestClient_Workstation(t *testing.T) {
+	defer func(h func() (string, error)) { Hostname = h }(Hostname)
+	Hostname = func() (string, error) { return "laptop.corp.example", nil }
+
+	for _, c := range []struct {
+		workstation string
+		oem         bool
+		want        string
+	}{
+		{"", false, "LAPTOP"},
+		{"", true, "LAPTOP"},
+		{"WS", false, "WS"},
+	} {
+		server := NewServer()
+		server.SetCredentials("User", "Domain", NtHash([]byte("Password")))
+		client := NewClient(Credentials{User: "User", Domain: "Domain", Password: "Password"})
+		client.Workstation = c.workstation
+		client.OEM = c.oem
+
+		type1 := client.Negotiate()
+		nm, _ := NewNegotiateMsg(type1)
+		if got := nm.Workstation(); got != c.want {
+			t.Errorf("%+v: NEGOTIATE_MESSAGE Workstation = %q", c, got)
+		}
+		type2, err := server.Challenge(type1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		type3, err := client.ProcessChallenge(type2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		am, _ := NewAuthenticateMsg(type3)
+		wantLen := len(c.want)
+		if !c.oem {
+			wantLen *= 2
+		}
+		if got := am.Workstation(); got != c.want || int(am.WorkstationLen) != wantLen {
+			t.Errorf("%+v: AUTHENTICATE_MESSAGE Workstation = %q (%d bytes)", c, got, am.WorkstationLen)
+		}
+	}
+
+	cm, _ := NewChallengeMsg(nil)
+	cm.NegotiateFlags = NEGOTIATE_UNICODE_CHARSET | NEGOTIATE_NTLM
+	cm.SetServerChallenge(nil)
+	type3, _, err := BuildType3(cm.Bytes(), Credentials{User: "User", Password: "Password"}, "", Workstation("OVERRIDE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if am, _ := NewAuthenticateMsg(type3); am.Workstation() != "OVERRIDE" {
+		t.Errorf("Workstation option: %q", am.Workstation())
+	}
+}
+
+func TestClient_NoTargetInfo(t *testing.T) {
+	cred := Credentials{User: "User", Domain: "Domain", Password: "Password"}
+	empty, _ := NewChallengeMsg(nil)
+	empty.NegotiateFlags = NEGOTIATE_UNICODE_CHARSET | NEGOTIATE_NTLM | NEGOTIATE_EXTENDED_SESSION_SECURITY
+	empty.SetServerChallenge(nil)
+
+	// a target info with MsvAvTimestamp, but NEGOTIATE_TARGET_INFO clear
+	ignored, _ := NewChallengeMsg(nil)
+	ignored.SetServerChallenge(nil)
+	ignored.SetTargetInfoStruct(TargetInfo{NetBIOSComputerName: "SERVER", Timestamp: time.Now()})
+	ignored.NegotiateFlags = empty.NegotiateFlags
+
+	for name, cm := range map[string]*ChallengeMsg{"empty": empty, "flag clear": ignored} {
+		type3, _, err := BuildType3(cm.Bytes(), cred, "WS")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		am, _ := NewAuthenticateMsg(type3)
+		_, blob, err := ParseNTLMv2ResponseBlob(am.NtChallengeResponseBytes())
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(blob.TargetInfo.List) != 1 || blob.TargetInfo.List[0].AvId != MsvAvEOL {
+			t.Errorf("%s: target info %v, want MsvAvEOL only", name, blob.TargetInfo.List)
+		}
+		if skew := time.Since(blob.Timestamp); skew < 0 || skew > time.Minute {
+			t.Errorf("%s: Timestamp = %v", name, blob.Timestamp)
+		}
+		if am.MIC() != nil || bytes.Equal(am.LmChallengeResponse(), make([]byte, 24)) {
+			t.Errorf("%s: MIC %x, LmChallengeResponse %x", name, am.MIC(), am.LmChallengeResponse())
+		}
+	}
+}
@@ -1,59 +1,129 @@
 package ntlmssp
 
 import (
-	"bytes"
-	"crypto/rand"
+	"crypto/hmac"
+	"io"
 	"time"
 )
 
+// Deprecated: use ComputeLMv1Response
 func ComputeLMResponse(challenge []byte, lmhash []byte) []byte {
-	lmhash = append(lmhash, []byte{0, 0, 0, 0, 0}...)
+	return ComputeLMv1Response(lmhash, challenge)
+}
 
-	output := append(desEnc(padding(lmhash[:7]), challenge), desEnc(padding(lmhash[7:14]), challenge)...)
-	output = append(output, desEnc(padding(lmhash[14:]), challenge)...)
-	return output
+// MS-NLMP 3.3.1, LMv1 response is DESL(LMOWFv1, ServerChallenge)
+func ComputeLMv1Response(lmHash, serverChallenge []byte) []byte {
+	return desl(lmHash, serverChallenge)
 }
 
-func ComputeLMv2Response(challenge []byte, usernameWithDomainOrServer []byte, nthash []byte, clientNonce []byte) []byte {
-	if clientNonce == nil {
-		clientNonce = make([]byte, 8)
-		rand.Read(clientNonce)
+// MS-NLMP 3.3.2, LMv2 response is
+// HMAC_MD5(NTOWFv2, ServerChallenge || ClientChallenge) || ClientChallenge.
+// It returns nil if the client challenge is not 8 bytes.
+func ComputeLMv2Response(ntlmv2Hash, serverChallenge, clientChallenge []byte) []byte {
+	if len(clientChallenge) != 8 {
+		return nil
+	}
+	return append(hmacMd5(ntlmv2Hash, append(append([]byte{}, serverChallenge...), clientChallenge...)), clientChallenge...)
+}
+
+// LmChallengeResponse to send with an NTLMv2 response. zeroed gives
+// Z(24), what MS-NLMP 3.1.5.1.2 asks for when the server sent
+// MsvAvTimestamp: the NTLMv2 response then carries the server's time and
+// the LMv2 response has nothing to add. Otherwise it is the LMv2
+// response, for servers that still check it.
+func LMResponseForV2(ntlmv2Hash, serverChallenge, clientChallenge []byte, zeroed bool) []byte {
+	if zeroed {
+		return make([]byte, 24)
 	}
-	hsh := hmacMd5(nthash, bytes.ToUpper(usernameWithDomainOrServer))
-	return append(hmacMd5(hsh, append(challenge, clientNonce...)), clientNonce...)
+	return ComputeLMv2Response(ntlmv2Hash, serverChallenge, clientChallenge)
 }
 
+// Deprecated: use ComputeNTv1Response, which takes the NT hash first
 func ComputeNTLMv1Response(challenge []byte, nthash []byte) []byte {
-	return ComputeLMResponse(challenge, nthash)
+	return ComputeNTv1Response(nthash, challenge)
 }
 
-func ComputeNTLMv2Response(challenge []byte, usernameWithDomainOrServer []byte, nthash []byte, clientChallenge []byte) []byte {
-	if clientChallenge == nil {
-		nonce := [8]byte{}
-		rand.Read(nonce[:])
-		cc := NTLMv2ClientChallenge{
-			RespType:            1,
-			HiRespType:          1,
-			Reserved1:           0,
-			Reserved2:           0,
-			TimeStamp:           (uint64(time.Now().UnixNano()) / 100) + 116444736000000000,
-			ChallengeFromClient: nonce,
-			Reserved3:           0,
-			AVPair:              nil,
-		}
-		clientChallenge = cc.Marshal()
+// MS-NLMP 3.3.1, NTLMv1 response is DESL(NTOWFv1, ServerChallenge)
+func ComputeNTv1Response(ntHash, serverChallenge []byte) []byte {
+	return desl(ntHash, serverChallenge)
+}
+
+// MS-NLMP 3.3.2, returns NTProofStr || temp and the SessionBaseKey.
+// timestamp is the 8 bytes FILETIME and targetInfo is the AV pair list
+// including its MsvAvEOL terminator. For a server without target info,
+// an empty targetInfo is sent as a lone MsvAvEOL and an empty timestamp
+// is the current time.
+func ComputeNTLMv2Response(ntlmv2Hash, serverChallenge, clientChallenge, timestamp, targetInfo []byte) (ntChallengeResponse, sessionBaseKey []byte) {
+	if len(targetInfo) == 0 {
+		targetInfo = []byte{0, 0, 0, 0}
+	}
+	if len(timestamp) == 0 {
+		timestamp = WindowsTimestamp(time.Now())
 	}
-	return ComputeLMv2Response(challenge, usernameWithDomainOrServer, nthash, clientChallenge)
+	temp := []byte{1, 1, 0, 0, 0, 0, 0, 0}
+	temp = append(temp, timestamp...)
+	temp = append(temp, clientChallenge...)
+	temp = append(temp, 0, 0, 0, 0)
+	temp = append(temp, targetInfo...)
+	temp = append(temp, 0, 0, 0, 0)
+
+	ntProofStr := hmacMd5(ntlmv2Hash, append(append([]byte{}, serverChallenge...), temp...))
+	return append(ntProofStr, temp...), hmacMd5(ntlmv2Hash, ntProofStr)
 }
 
+// Check a client's NTLMv2 response, the NTProofStr is recomputed over the
+// rest of the response and compared in constant time
+func VerifyNTLMv2Response(ntlmv2Hash, serverChallenge, ntChallengeResponse []byte) bool {
+	if len(ntChallengeResponse) < 16 {
+		return false
+	}
+	ntProofStr := hmacMd5(ntlmv2Hash, append(append([]byte{}, serverChallenge...), ntChallengeResponse[16:]...))
+	return hmac.Equal(ntProofStr, ntChallengeResponse[:16])
+}
+
+// Test a candidate password against a captured NTLMv2 handshake, the
+// NTProofStr is recomputed over the temp blob of ntChallengeResponse,
+// which must parse with ParseNTLMv2ResponseBlob
+func VerifyPassword(password, user, domain string, serverChallenge, ntChallengeResponse []byte) bool {
+	if _, _, err := ParseNTLMv2ResponseBlob(ntChallengeResponse); err != nil {
+		return false
+	}
+	return VerifyNTLMv2Response(NTOWFv2(password, user, domain), serverChallenge, ntChallengeResponse)
+}
+
+// Deprecated: use ComputeNTLM2SessionResponse
 func ComputeNTLMv2SessionResponse(challenge []byte, clientNonce []byte, nthash []byte) []byte {
 	if clientNonce == nil {
 		clientNonce = make([]byte, 8)
-		rand.Read(clientNonce)
+		io.ReadFull(Rand, clientNonce)
 	}
 
-	sessionHash := md5Hash(append(challenge, clientNonce...))[:8]
-	nthash = append(nthash, []byte{0, 0, 0, 0, 0}...)
-	output := append(desEnc(padding(nthash[:7]), sessionHash), desEnc(padding(nthash[7:14]), sessionHash)...)
-	return append(output, desEnc(padding(nthash[14:21]), sessionHash)...)
+	_, nt := ComputeNTLM2SessionResponse(nthash, challenge, clientNonce)
+	return nt
+}
+
+// MS-NLMP 3.3.1 NTLMv1 with NEGOTIATE_EXTENDED_SESSION_SECURITY, the LM
+// response carries the client challenge padded with zeros and the NT
+// response is DESL(NTOWFv1, MD5(ServerChallenge || ClientChallenge)[:8]).
+// Only the first 8 bytes of clientChallenge are used, both responses are
+// nil if it is shorter.
+func ComputeNTLM2SessionResponse(ntHash, serverChallenge, clientChallenge []byte) (lm, nt []byte) {
+	if len(clientChallenge) < 8 {
+		return nil, nil
+	}
+	lm = make([]byte, 24)
+	copy(lm, clientChallenge[:8])
+
+	sessionHash := md5Hash(append(append([]byte{}, serverChallenge...), clientChallenge[:8]...))[:8]
+	return lm, desl(ntHash, sessionHash)
+}
+
+// Compute the NTLMv1 LM and NT responses for a challenge, the NTLM2
+// session response is used if the challenge negotiates
+// NEGOTIATE_EXTENDED_SESSION_SECURITY.
+func ComputeNTLMv1Responses(cm *ChallengeMsg, ntHash, lmHash, clientChallenge []byte) (lm, nt []byte) {
+	if cm.NegotiateFlags&NEGOTIATE_EXTENDED_SESSION_SECURITY != 0 {
+		return ComputeNTLM2SessionResponse(ntHash, cm.ServerChallenge[:], clientChallenge)
+	}
+	return ComputeLMv1Response(lmHash, cm.ServerChallenge[:]), ComputeNTv1Response(ntHash, cm.ServerChallenge[:])
 }
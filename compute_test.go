@@ -0,0 +1,245 @@
+package ntlmssp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func decodeHex(s string) []byte {
+	bs, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return bs
+}
+
+// MS-NLMP 4.2.4
+func TestComputeNTLMv2Response(t *testing.T) {
+	ntlmv2Hash := NTOWFv2("Password", "User", "Domain")
+	serverChallenge := decodeHex("0123456789abcdef")
+	clientChallenge := decodeHex("aaaaaaaaaaaaaaaa")
+	timestamp := make([]byte, 8)
+	// MsvAvNbDomainName "Domain", MsvAvNbComputerName "Server", MsvAvEOL
+	targetInfo := decodeHex("02000c0044006f006d00610069006e0001000c0053006500720076006500720000000000")
+
+	resp, sessionBaseKey := ComputeNTLMv2Response(ntlmv2Hash, serverChallenge, clientChallenge, timestamp, targetInfo)
+
+	if got, want := hex.EncodeToString(resp[:16]), "68cd0ab851e51c96aabc927bebef6a1c"; got != want {
+		t.Errorf("NTProofStr = %s, want %s", got, want)
+	}
+	if got, want := hex.EncodeToString(sessionBaseKey), "8de40ccadbc14a82f15cb0ad0de95ca3"; got != want {
+		t.Errorf("SessionBaseKey = %s, want %s", got, want)
+	}
+
+	temp := append(decodeHex("0101000000000000"), timestamp...)
+	temp = append(temp, clientChallenge...)
+	temp = append(temp, 0, 0, 0, 0)
+	temp = append(temp, targetInfo...)
+	temp = append(temp, 0, 0, 0, 0)
+	if !bytes.Equal(resp[16:], temp) {
+		t.Errorf("temp = %x, want %x", resp[16:], temp)
+	}
+}
+
+func TestParseNTLMv2ResponseBlob(t *testing.T) {
+	targetInfo := decodeHex("02000c0044006f006d00610069006e0001000c0053006500720076006500720000000000")
+	timestamp := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	resp, _ := ComputeNTLMv2Response(NTOWFv2("Password", "User", "Domain"), decodeHex("0123456789abcdef"),
+		decodeHex("aaaaaaaaaaaaaaaa"), WindowsTimestamp(timestamp), targetInfo)
+
+	ntProofStr, blob, err := ParseNTLMv2ResponseBlob(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ntProofStr, resp[:16]) {
+		t.Errorf("NTProofStr = %x, want %x", ntProofStr, resp[:16])
+	}
+	if blob.RespType != 1 || blob.HiRespType != 1 || !blob.Timestamp.Equal(timestamp) ||
+		hex.EncodeToString(blob.ClientChallenge[:]) != "aaaaaaaaaaaaaaaa" {
+		t.Errorf("blob = %+v", blob)
+	}
+	if !bytes.Equal(blob.TargetInfo.Marshal(), targetInfo) || blob.TargetInfo.Get(MsvAvNbComputerName) == nil {
+		t.Errorf("TargetInfo = %x, want %x", blob.TargetInfo.Marshal(), targetInfo)
+	}
+
+	for _, bad := range [][]byte{resp[:43], append(append([]byte{}, resp[:16]...), 2), resp[:48]} {
+		if len(bad) == 17 {
+			bad = append(bad, resp[17:]...)
+		}
+		if _, _, err := ParseNTLMv2ResponseBlob(bad); err == nil {
+			t.Errorf("ParseNTLMv2ResponseBlob(%x): expected error", bad)
+		}
+	}
+}
+
+func TestComputeNTLMv2Response_NoTargetInfo(t *testing.T) {
+	resp, _ := ComputeNTLMv2Response(NTOWFv2("Password", "User", "Domain"), decodeHex("0123456789abcdef"),
+		decodeHex("aaaaaaaaaaaaaaaa"), nil, nil)
+	_, blob, err := ParseNTLMv2ResponseBlob(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp) != 16+28+4+4 || len(blob.TargetInfo.List) != 1 {
+		t.Errorf("response %x, target info %v", resp, blob.TargetInfo.List)
+	}
+	if skew := time.Since(blob.Timestamp); skew < 0 || skew > time.Minute {
+		t.Errorf("Timestamp = %v", blob.Timestamp)
+	}
+}
+
+func TestNTLMv2ClientChallenge_Marshal(t *testing.T) {
+	// MsvAvTimestamp, then MsvAvEOL
+	timestamp := WindowsTimestamp(time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC))
+	targetInfo := append(append([]byte{7, 0, 8, 0}, timestamp...), 0, 0, 0, 0)
+	resp, _ := ComputeNTLMv2Response(NTOWFv2("Password", "User", "Domain"), decodeHex("0123456789abcdef"),
+		decodeHex("aaaaaaaaaaaaaaaa"), timestamp, targetInfo)
+
+	// the parsed MsvAvTimestamp is a time.Time
+	if got := ParseNTLMv2Response(resp).ClientChallenge.Marshal(); !bytes.Equal(got, resp[16:len(resp)-4]) {
+		t.Errorf("Marshal = %x, want %x", got, resp[16:len(resp)-4])
+	}
+}
+
+func TestVerifyNTLMv2Response(t *testing.T) {
+	ntlmv2Hash := NTOWFv2("Password", "User", "Domain")
+	serverChallenge := decodeHex("0123456789abcdef")
+	targetInfo := decodeHex("02000c0044006f006d00610069006e0001000c0053006500720076006500720000000000")
+	resp, _ := ComputeNTLMv2Response(ntlmv2Hash, serverChallenge, decodeHex("aaaaaaaaaaaaaaaa"), make([]byte, 8), targetInfo)
+
+	if !VerifyNTLMv2Response(ntlmv2Hash, serverChallenge, resp) {
+		t.Error("VerifyNTLMv2Response rejected the correct response")
+	}
+	for _, i := range []int{0, 15, 16, len(resp) - 1} {
+		flipped := append([]byte{}, resp...)
+		flipped[i] ^= 1
+		if VerifyNTLMv2Response(ntlmv2Hash, serverChallenge, flipped) {
+			t.Errorf("VerifyNTLMv2Response accepted a flipped byte at %d", i)
+		}
+	}
+	if VerifyNTLMv2Response(ntlmv2Hash, serverChallenge, resp[:15]) {
+		t.Error("VerifyNTLMv2Response accepted a short response")
+	}
+}
+
+func TestVerifyPassword(t *testing.T) {
+	serverChallenge := decodeHex("0123456789abcdef")
+	targetInfo := decodeHex("02000c0044006f006d00610069006e0001000c0053006500720076006500720000000000")
+	resp, _ := ComputeNTLMv2Response(NTOWFv2("Password", "User", "Domain"), serverChallenge,
+		decodeHex("aaaaaaaaaaaaaaaa"), WindowsTimestamp(time.Now()), targetInfo)
+
+	if !VerifyPassword("Password", "User", "Domain", serverChallenge, resp) {
+		t.Error("VerifyPassword rejected the password")
+	}
+	// NTOWFv2 uppercases the user only
+	if !VerifyPassword("Password", "user", "Domain", serverChallenge, resp) {
+		t.Error("VerifyPassword is case sensitive in the user")
+	}
+	for _, c := range []struct{ password, user, domain string }{
+		{"password", "User", "Domain"},
+		{"Password", "User", "DOMAIN"},
+		{"", "User", "Domain"},
+	} {
+		if VerifyPassword(c.password, c.user, c.domain, serverChallenge, resp) {
+			t.Errorf("VerifyPassword accepted %+v", c)
+		}
+	}
+	if VerifyPassword("Password", "User", "Domain", decodeHex("0123456789abcdee"), resp) {
+		t.Error("VerifyPassword accepted another server challenge")
+	}
+	if VerifyPassword("Password", "User", "Domain", serverChallenge, resp[:40]) {
+		t.Error("VerifyPassword accepted a truncated response")
+	}
+}
+
+// MS-NLMP 4.2.2.2
+func TestComputeNTLMv1Response(t *testing.T) {
+	serverChallenge := decodeHex("0123456789abcdef")
+
+	if got, want := hex.EncodeToString(ComputeNTv1Response(NtHash([]byte("Password")), serverChallenge)),
+		"67c43011f30298a2ad35ece64f16331c44bdbed927841f94"; got != want {
+		t.Errorf("NTLMv1 response = %s, want %s", got, want)
+	}
+	// the deprecated name keeps its original argument order
+	if got, want := hex.EncodeToString(ComputeNTLMv1Response(serverChallenge, NtHash([]byte("Password")))),
+		"67c43011f30298a2ad35ece64f16331c44bdbed927841f94"; got != want {
+		t.Errorf("ComputeNTLMv1Response = %s, want %s", got, want)
+	}
+	if got, want := hex.EncodeToString(ComputeLMv1Response(LmHash([]byte("Password")), serverChallenge)),
+		"98def7b87f88aa5dafe2df779688a172def11c7d5ccdef13"; got != want {
+		t.Errorf("LMv1 response = %s, want %s", got, want)
+	}
+}
+
+// MS-NLMP 4.2.3.2
+func TestComputeNTLM2SessionResponse(t *testing.T) {
+	ntHash := NtHash([]byte("Password"))
+	lmHash := LmHash([]byte("Password"))
+	clientChallenge := decodeHex("aaaaaaaaaaaaaaaa")
+
+	cm, _ := NewChallengeMsg(nil)
+	cm.SetServerChallenge(decodeHex("0123456789abcdef"))
+
+	cm.NegotiateFlags = NEGOTIATE_NTLM | NEGOTIATE_EXTENDED_SESSION_SECURITY
+	lm, nt := ComputeNTLMv1Responses(cm, ntHash, lmHash, clientChallenge)
+	if got, want := hex.EncodeToString(lm), "aaaaaaaaaaaaaaaa00000000000000000000000000000000"; got != want {
+		t.Errorf("LM response = %s, want %s", got, want)
+	}
+	if got, want := hex.EncodeToString(nt), "7537f803ae367128ca458204bde7caf81e97ed2683267232"; got != want {
+		t.Errorf("NT response = %s, want %s", got, want)
+	}
+
+	cm.NegotiateFlags = NEGOTIATE_NTLM
+	lm, nt = ComputeNTLMv1Responses(cm, ntHash, lmHash, clientChallenge)
+	if got, want := hex.EncodeToString(lm), "98def7b87f88aa5dafe2df779688a172def11c7d5ccdef13"; got != want {
+		t.Errorf("LM response = %s, want %s", got, want)
+	}
+	if got, want := hex.EncodeToString(nt), "67c43011f30298a2ad35ece64f16331c44bdbed927841f94"; got != want {
+		t.Errorf("NT response = %s, want %s", got, want)
+	}
+
+	for _, short := range [][]byte{nil, {0}, clientChallenge[:7]} {
+		if lm, nt := ComputeNTLM2SessionResponse(ntHash, cm.ServerChallenge[:], short); lm != nil || nt != nil {
+			t.Errorf("ComputeNTLM2SessionResponse(%x) = %x, %x, want nil", short, lm, nt)
+		}
+	}
+}
+
+func TestLMResponseForV2(t *testing.T) {
+	ntlmv2Hash := NTOWFv2("Password", "User", "Domain")
+	serverChallenge := decodeHex("0123456789abcdef")
+	clientChallenge := decodeHex("aaaaaaaaaaaaaaaa")
+
+	cases := []struct {
+		zeroed bool
+		want   string
+	}{
+		{true, "000000000000000000000000000000000000000000000000"},
+		// MS-NLMP 4.2.4.2.1
+		{false, "86c35097ac9cec102554764a57cccc19aaaaaaaaaaaaaaaa"},
+	}
+	for _, c := range cases {
+		resp := LMResponseForV2(ntlmv2Hash, serverChallenge, clientChallenge, c.zeroed)
+		if len(resp) != 24 || hex.EncodeToString(resp) != c.want {
+			t.Errorf("zeroed %v: LMResponseForV2 = %x (%d bytes), want %s", c.zeroed, resp, len(resp), c.want)
+		}
+	}
+}
+
+// MS-NLMP 4.2.4.2.1
+func TestComputeLMv2Response(t *testing.T) {
+	ntlmv2Hash := NTOWFv2("Password", "User", "Domain")
+	serverChallenge := decodeHex("0123456789abcdef")
+
+	resp := ComputeLMv2Response(ntlmv2Hash, serverChallenge, decodeHex("aaaaaaaaaaaaaaaa"))
+	if got, want := hex.EncodeToString(resp), "86c35097ac9cec102554764a57cccc19aaaaaaaaaaaaaaaa"; got != want {
+		t.Errorf("LMv2 response = %s, want %s", got, want)
+	}
+
+	for _, cc := range [][]byte{nil, decodeHex("aaaaaaaaaaaaaa"), decodeHex("aaaaaaaaaaaaaaaaaa")} {
+		if resp := ComputeLMv2Response(ntlmv2Hash, serverChallenge, cc); resp != nil {
+			t.Errorf("client challenge of %d bytes accepted", len(cc))
+		}
+	}
+}
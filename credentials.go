@@ -0,0 +1,100 @@
+package ntlmssp
+
+import "fmt"
+
+// Account to authenticate with. Password and NTHash are mutually
+// exclusive, NTHash is NtHash of the password and allows authenticating
+// without knowing it. The zero value is the anonymous account.
+type Credentials struct {
+	User     string
+	Domain   string
+	Password string
+	NTHash   []byte
+
+	// hashes of Prepare, shared by the copies of c
+	prepared *preparedHashes
+}
+
+type preparedHashes struct {
+	nt, lm []byte
+}
+
+func (c Credentials) anonymous() bool {
+	return c.User == "" && c.Password == "" && c.NTHash == nil
+}
+
+func (c Credentials) ntHash() ([]byte, error) {
+	if c.prepared != nil && c.prepared.nt != nil {
+		return c.prepared.nt, nil
+	}
+	if c.NTHash != nil {
+		if c.Password != "" {
+			return nil, fmt.Errorf("ntlmssp: both Password and NTHash are set")
+		}
+		if len(c.NTHash) != 16 {
+			return nil, fmt.Errorf("ntlmssp: NTHash must be 16 bytes, got %d", len(c.NTHash))
+		}
+		return c.NTHash, nil
+	}
+	return NTHash(c.Password), nil
+}
+
+// Compute the NT hash, and the LM hash when Password is set, once for
+// every later handshake with c or a copy of it. Call Wipe when done.
+func (c *Credentials) Prepare() error {
+	nt, err := c.ntHash()
+	if err != nil {
+		return err
+	}
+	prepared := &preparedHashes{nt: append([]byte{}, nt...)}
+	if c.NTHash == nil {
+		prepared.lm = LMHash(c.Password)
+	}
+	c.prepared = prepared
+	return nil
+}
+
+// NTOWFv1 of Prepare, computed on each call otherwise
+func (c Credentials) NTOWFv1() ([]byte, error) {
+	return c.ntHash()
+}
+
+// LMOWFv1 of Prepare, computed on each call otherwise. nil when only
+// NTHash is known.
+func (c Credentials) LMOWFv1() []byte {
+	if c.prepared != nil && c.prepared.nt != nil {
+		return c.prepared.lm
+	}
+	if c.NTHash != nil {
+		return nil
+	}
+	return LMHash(c.Password)
+}
+
+// NTOWFv2 for User and Domain, from the NT hash of Prepare if called
+func (c Credentials) NTOWFv2() ([]byte, error) {
+	nt, err := c.ntHash()
+	if err != nil {
+		return nil, err
+	}
+	return ntowfv2(nt, c.User, c.Domain), nil
+}
+
+// Overwrite the hashes of Prepare with zeros and forget them, in c and
+// all its copies, which compute them again if used. Password and NTHash
+// are left to the caller.
+func (c *Credentials) Wipe() {
+	if c.prepared == nil {
+		return
+	}
+	zero(c.prepared.nt)
+	zero(c.prepared.lm)
+	c.prepared.nt, c.prepared.lm = nil, nil
+	c.prepared = nil
+}
+
+func zero(bs []byte) {
+	for i := range bs {
+		bs[i] = 0
+	}
+}
@@ -0,0 +1,62 @@
+package ntlmssp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCredentials_Prepare(t *testing.T) {
+	cred := Credentials{User: "User", Domain: "Domain", Password: "Password"}
+	if err := cred.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	nt, _ := cred.NTOWFv1()
+	if !bytes.Equal(nt, NTHash("Password")) || !bytes.Equal(cred.LMOWFv1(), LMHash("Password")) {
+		t.Errorf("NTOWFv1 = %x, LMOWFv1 = %x", nt, cred.LMOWFv1())
+	}
+	if v2, _ := cred.NTOWFv2(); !bytes.Equal(v2, NTOWFv2("Password", "User", "Domain")) {
+		t.Errorf("NTOWFv2 = %x", v2)
+	}
+
+	// a copy handed to a Client shares the hashes
+	server := NewServer()
+	server.SetCredentials("User", "Domain", NTHash("Password"))
+	if _, err := handshake(NewClient(cred), server); err != nil {
+		t.Fatal(err)
+	}
+
+	lm := cred.LMOWFv1()
+	cred.Wipe()
+	if !bytes.Equal(nt, make([]byte, 16)) || !bytes.Equal(lm, make([]byte, 16)) {
+		t.Errorf("after Wipe: NT hash %x, LM hash %x", nt, lm)
+	}
+	if nt, _ := cred.NTOWFv1(); !bytes.Equal(nt, NTHash("Password")) {
+		t.Errorf("NTOWFv1 after Wipe = %x", nt)
+	}
+
+	hashOnly := Credentials{User: "User", NTHash: NTHash("Password")}
+	if err := hashOnly.Prepare(); err != nil || hashOnly.LMOWFv1() != nil {
+		t.Errorf("Prepare with NTHash: %v, LMOWFv1 = %x", err, hashOnly.LMOWFv1())
+	}
+	if err := (&Credentials{Password: "x", NTHash: make([]byte, 16)}).Prepare(); err == nil {
+		t.Error("Prepare with Password and NTHash: expected error")
+	}
+}
+
+func BenchmarkCredentials_NTOWFv2(b *testing.B) {
+	cred := Credentials{User: "User", Domain: "Domain", Password: "Password"}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		cred.NTOWFv2()
+	}
+}
+
+func BenchmarkCredentials_NTOWFv2Prepared(b *testing.B) {
+	cred := Credentials{User: "User", Domain: "Domain", Password: "Password"}
+	cred.Prepare()
+	defer cred.Wipe()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		cred.NTOWFv2()
+	}
+}
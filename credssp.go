@@ -0,0 +1,139 @@
+package ntlmssp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/asn1"
+	"fmt"
+)
+
+// CredSSP version sent in TSRequest, MS-CSSP 2.2.1. From version 5 the
+// pubKeyAuth is a hash over the clientNonce and the public key.
+const credSSPVersion = 6
+
+const (
+	clientServerHashMagic = "CredSSP Client-To-Server Binding Hash\x00"
+	serverClientHashMagic = "CredSSP Server-To-Client Binding Hash\x00"
+)
+
+// MS-CSSP 2.2.1 TSRequest
+type tsRequest struct {
+	Version     int        `asn1:"explicit,tag:0"`
+	NegoTokens  []negoData `asn1:"explicit,optional,tag:1"`
+	AuthInfo    []byte     `asn1:"explicit,optional,tag:2"`
+	PubKeyAuth  []byte     `asn1:"explicit,optional,tag:3"`
+	ErrorCode   int        `asn1:"explicit,optional,tag:4"`
+	ClientNonce []byte     `asn1:"explicit,optional,tag:5"`
+}
+
+// MS-CSSP 2.2.1.1 NegoData
+type negoData struct {
+	NegoToken []byte `asn1:"explicit,tag:0"`
+}
+
+// MS-CSSP 2.2.1.2 TSCredentials
+type tsCredentials struct {
+	CredType    int    `asn1:"explicit,tag:0"`
+	Credentials []byte `asn1:"explicit,tag:1"`
+}
+
+// MS-CSSP 2.2.1.2.1 TSPasswordCreds
+type tsPasswordCreds struct {
+	DomainName []byte `asn1:"explicit,tag:0"`
+	UserName   []byte `asn1:"explicit,tag:1"`
+	Password   []byte `asn1:"explicit,tag:2"`
+}
+
+// TSRequest carrying an NTLM message of the CredSSP handshake
+func NewTSRequest(negoToken []byte) []byte {
+	return MarshalTSRequest(negoToken, nil, nil, nil)
+}
+
+// TSRequest with the optional fields, nil ones are left out. clientNonce
+// goes with the pubKeyAuth of ClientPubKeyAuth.
+func MarshalTSRequest(negoToken, authInfo, pubKeyAuth, clientNonce []byte) []byte {
+	req := tsRequest{
+		Version:     credSSPVersion,
+		AuthInfo:    authInfo,
+		PubKeyAuth:  pubKeyAuth,
+		ClientNonce: clientNonce,
+	}
+	if negoToken != nil {
+		req.NegoTokens = []negoData{{negoToken}}
+	}
+	bs, _ := asn1.Marshal(req)
+	return bs
+}
+
+// Fields of a TSRequest from the peer, an error if it carries an errorCode
+func ParseTSRequest(b []byte) (negoTokens [][]byte, authInfo, pubKeyAuth []byte, err error) {
+	var req tsRequest
+	if rest, err := asn1.Unmarshal(b, &req); err != nil {
+		return nil, nil, nil, fmt.Errorf("ntlmssp: malformed TSRequest: %v", err)
+	} else if len(rest) != 0 {
+		return nil, nil, nil, fmt.Errorf("ntlmssp: trailing data after TSRequest")
+	}
+	if req.ErrorCode != 0 {
+		return nil, nil, nil, fmt.Errorf("ntlmssp: CredSSP error %#x", uint32(req.ErrorCode))
+	}
+
+	for _, token := range req.NegoTokens {
+		negoTokens = append(negoTokens, token.NegoToken)
+	}
+	return negoTokens, req.AuthInfo, req.PubKeyAuth, nil
+}
+
+// pubKeyAuth of the client, MS-CSSP 3.1.5. pubKey is the
+// SubjectPublicKey of the TLS server certificate, the 32 bytes clientNonce
+// is sent in the same TSRequest. A nil clientNonce gives the Seal of
+// pubKey itself of CredSSP version 2 to 4.
+func ClientPubKeyAuth(sc *SecurityContext, pubKey, clientNonce []byte) ([]byte, error) {
+	if clientNonce == nil {
+		return sc.Wrap(pubKey, true)
+	}
+	return sc.Wrap(pubKeyHash(clientServerHashMagic, clientNonce, pubKey), true)
+}
+
+// Check the server's pubKeyAuth, for the clientNonce given to
+// ClientPubKeyAuth
+func VerifyServerPubKeyAuth(sc *SecurityContext, pubKey, clientNonce, pubKeyAuth []byte) error {
+	plain, err := sc.Unwrap(pubKeyAuth)
+	if err != nil {
+		return err
+	}
+
+	var expected []byte
+	if clientNonce == nil {
+		// the first byte of the public key incremented by one
+		expected = append([]byte{}, pubKey...)
+		if len(expected) > 0 {
+			expected[0]++
+		}
+	} else {
+		expected = pubKeyHash(serverClientHashMagic, clientNonce, pubKey)
+	}
+	if !hmac.Equal(plain, expected) {
+		return fmt.Errorf("ntlmssp: server public key mismatch")
+	}
+	return nil
+}
+
+func pubKeyHash(magic string, clientNonce, pubKey []byte) []byte {
+	hsh := sha256.New()
+	hsh.Write([]byte(magic))
+	hsh.Write(clientNonce)
+	hsh.Write(pubKey)
+	return hsh.Sum(nil)
+}
+
+// authInfo of the delegated password credentials, TSCredentials sealed
+// with sc
+func CredSSPAuthInfo(sc *SecurityContext, domain, user, password string) ([]byte, error) {
+	creds, _ := asn1.Marshal(tsPasswordCreds{
+		DomainName: encodeUTF16LE([]byte(domain)),
+		UserName:   encodeUTF16LE([]byte(user)),
+		Password:   encodeUTF16LE([]byte(password)),
+	})
+	bs, _ := asn1.Marshal(tsCredentials{CredType: 1, Credentials: creds})
+	return sc.Wrap(bs, true)
+}
@@ -0,0 +1,86 @@
+package ntlmssp
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"encoding/hex"
+	"testing"
+)
+
+func TestParseTSRequest(t *testing.T) {
+	type2 := "4e544c4d53535000020000000c000c003800000033828ae20123456789abcdef00000000000000002400240044000000060070170000000f53006500720076006500720002000c0044006f006d00610069006e0001000c0053006500720076006500720000000000"
+	// TSRequest version 6 of an RDP server with the MS-NLMP 4.2.4.3 challenge
+	captured := "3077a003020106a170306e306ca06a0468" + type2
+
+	tokens, authInfo, pubKeyAuth, err := ParseTSRequest(decodeHex(captured))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1 || hex.EncodeToString(tokens[0]) != type2 || authInfo != nil || pubKeyAuth != nil {
+		t.Errorf("negoTokens = %x, authInfo = %x, pubKeyAuth = %x", tokens, authInfo, pubKeyAuth)
+	}
+	if got := hex.EncodeToString(NewTSRequest(decodeHex(type2))); got != captured {
+		t.Errorf("NewTSRequest = %s, want %s", got, captured)
+	}
+
+	// errorCode STATUS_LOGON_FAILURE
+	for _, bad := range []string{"", captured[:len(captured)-2], captured + "00", "300da003020106a4060204c000006d"} {
+		if _, _, _, err := ParseTSRequest(decodeHex(bad)); err == nil {
+			t.Errorf("ParseTSRequest(%s): expected error", bad)
+		}
+	}
+}
+
+func TestPubKeyAuth(t *testing.T) {
+	flags := uint32(NEGOTIATE_EXTENDED_SESSION_SECURITY | NEGOTIATE_128BIT_SESSION_KEY | NEGOTIATE_SIGN | NEGOTIATE_SEAL | NEGOTIATE_EXPLICIT_KEY_EXCHANGE)
+	key := decodeHex("55555555555555555555555555555555")
+	pubKey := decodeHex("3082010a0282010100c0ffee")
+	nonce := bytes.Repeat([]byte{0xaa}, 32)
+
+	for _, clientNonce := range [][]byte{nonce, nil} {
+		client := NewSecurityContext(flags, key, "Client")
+		server := NewSecurityContext(flags, key, "Server")
+
+		pubKeyAuth, err := ClientPubKeyAuth(client, pubKey, clientNonce)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, got, err := ParseTSRequest(MarshalTSRequest(nil, nil, pubKeyAuth, clientNonce))
+		if err != nil || !bytes.Equal(got, pubKeyAuth) {
+			t.Fatalf("pubKeyAuth = %x, %v", got, err)
+		}
+
+		plain, err := server.Unwrap(pubKeyAuth)
+		expected, reply := pubKey, append([]byte{pubKey[0] + 1}, pubKey[1:]...)
+		if clientNonce != nil {
+			expected = pubKeyHash(clientServerHashMagic, clientNonce, pubKey)
+			reply = pubKeyHash(serverClientHashMagic, clientNonce, pubKey)
+		}
+		if err != nil || !bytes.Equal(plain, expected) {
+			t.Fatalf("nonce %x: client pubKeyAuth = %x, %v", clientNonce, plain, err)
+		}
+
+		serverAuth, _ := server.Wrap(reply, true)
+		if err := VerifyServerPubKeyAuth(client, pubKey, clientNonce, serverAuth); err != nil {
+			t.Errorf("nonce %x: %v", clientNonce, err)
+		}
+		wrongAuth, _ := server.Wrap(pubKey, true)
+		if err := VerifyServerPubKeyAuth(client, pubKey, clientNonce, wrongAuth); err == nil {
+			t.Errorf("nonce %x: server pubKeyAuth of the client accepted", clientNonce)
+		}
+
+		authInfo, _ := CredSSPAuthInfo(client, "Domain", "User", "Password")
+		plain, err = server.Unwrap(authInfo)
+		var creds tsCredentials
+		var password tsPasswordCreds
+		if err == nil {
+			_, err = asn1.Unmarshal(plain, &creds)
+		}
+		if err == nil {
+			_, err = asn1.Unmarshal(creds.Credentials, &password)
+		}
+		if err != nil || creds.CredType != 1 || bytes2StringUTF16(password.UserName) != "User" || bytes2StringUTF16(password.Password) != "Password" {
+			t.Errorf("authInfo = %+v, %+v, %v", creds, password, err)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package ntlmssp
+
+import "errors"
+
+// Errors of the package wrap one of these, test them with errors.Is.
+var (
+	// The message ends before its fixed fields or a length prefixed field
+	ErrMessageTooShort = errors.New("ntlmssp: message too short")
+	// Bad NTLMSSP signature or message type, or a field pointing outside
+	// the message
+	ErrMalformedMessage = errors.New("ntlmssp: malformed message")
+
+	// The NT response does not match the credentials
+	ErrNTProofMismatch = errors.New("ntlmssp: NT response mismatch")
+	// The MIC of the AUTHENTICATE_MESSAGE does not match
+	ErrMICMismatch = errors.New("ntlmssp: MIC mismatch")
+	// Returned by VerifyMIC when the client claims a MIC in MsvAvFlags but
+	// the MIC field is absent or zeroed, which indicates the MIC has been
+	// stripped.
+	ErrMICMissing = errors.New("ntlmssp: MIC provided bit set but MIC is missing")
+	// NTLMSSP_MESSAGE_SIGNATURE of a signed or sealed message does not
+	// match
+	ErrBadSignature = errors.New("ntlmssp: invalid message signature")
+
+	// The peer did not negotiate a flag the handshake needs
+	ErrFlagDowngrade = errors.New("ntlmssp: required flags not negotiated")
+	// The response is below Server.MinAuthLevel
+	ErrAuthLevel = errors.New("ntlmssp: response below the minimum auth level")
+	// The NTLMv2 response timestamp is further than Server.MaxClockSkew
+	// from the challenge
+	ErrClockSkew = errors.New("ntlmssp: response timestamp out of range")
+)
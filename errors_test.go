@@ -0,0 +1,100 @@
+package ntlmssp
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestErrors(t *testing.T) {
+	newServer := func() *Server {
+		server := NewServer()
+		server.SetCredentials("User", "Domain", NtHash([]byte("Password")))
+		return server
+	}
+	client := NewClient(Credentials{User: "User", Domain: "Domain", Password: "Password"})
+	server := newServer()
+	type2, _ := server.Challenge(client.Negotiate())
+	type3, _ := client.ProcessChallenge(type2)
+
+	tampered := append([]byte{}, type3...)
+	tampered[len(tampered)-1] ^= 1
+	sc := NewSecurityContext(NEGOTIATE_EXTENDED_SESSION_SECURITY|NEGOTIATE_SIGN, make([]byte, 16), "Client")
+
+	cases := []struct {
+		name string
+		err  func() error
+		want error
+	}{
+		{"short message", func() error { _, err := NewChallengeMsg(type2[:20]); return err }, ErrMessageTooShort},
+		{"short header", func() error { _, err := NewAuthenticateMsg(type3[:40]); return err }, ErrMessageTooShort},
+		{"bad signature", func() error { _, err := NewNegotiateMsg(append([]byte("NTLMSSQ\x00"), type2[8:]...)); return err }, ErrMalformedMessage},
+		{"wrong type", func() error { _, err := NewNegotiateMsg(type2); return err }, ErrMalformedMessage},
+		{"buffer out of range", func() error { _, err := NewChallengeMsg(type2[:len(type2)-1]); return err }, ErrMalformedMessage},
+		{"AV pairs", func() error { _, err := ParseAVPairSafe([]byte{2, 0, 8, 0}); return err }, ErrMalformedMessage},
+		{"wrong password", func() error {
+			_, err := handshake(NewClient(Credentials{User: "User", Domain: "Domain", Password: "wrong"}), newServer())
+			return err
+		}, ErrNTProofMismatch},
+		{"tampered", func() error { _, err := server.Authenticate(tampered); return err }, ErrMICMismatch},
+		{"message signature", func() error { return sc.Verify([]byte("message"), make([]byte, 16)) }, ErrBadSignature},
+		{"required flags", func() error {
+			c := NewClient(Credentials{User: "User", Domain: "Domain", Password: "Password"})
+			c.RequireFlags = NEGOTIATE_DATAGRAM_CONNECTIONLESS
+			_, err := handshake(c, newServer())
+			return err
+		}, ErrFlagDowngrade},
+		{"auth level", func() error {
+			server.Challenge(client.Negotiate())
+			am, _ := NewAuthenticateMsg(nil)
+			am.SetLmChallengeResponse(make([]byte, 24))
+			am.SetNtChallengeResponse(make([]byte, 24))
+			am.SetDomainName([]byte("Domain"))
+			am.SetUserName([]byte("User"))
+			_, err := server.Authenticate(am.Marshal('<'))
+			return err
+		}, ErrAuthLevel},
+		{"clock skew", func() error {
+			s := newServer()
+			s.MaxClockSkew = time.Minute
+			cm, _ := NewChallengeMsg(mustChallenge(t, s, client.Negotiate()))
+			resp, _ := ComputeNTLMv2Response(NTOWFv2("Password", "User", "Domain"), cm.ServerChallenge[:],
+				make([]byte, 8), WindowsTimestamp(time.Now().Add(time.Hour)), cm.TargetInfo())
+			am, _ := NewAuthenticateMsg(nil)
+			am.SetLmChallengeResponse(make([]byte, 24))
+			am.SetNtChallengeResponse(resp)
+			am.SetDomainName([]byte("Domain"))
+			am.SetUserName([]byte("User"))
+			_, err := s.Authenticate(am.Bytes())
+			return err
+		}, ErrClockSkew},
+		{"zero challenge", func() error {
+			c := NewClient(Credentials{User: "User", Domain: "Domain", Password: "Password"})
+			cm, _ := NewChallengeMsg(append([]byte{}, type2...))
+			cm.ServerChallenge = [8]byte{}
+			c.Negotiate()
+			_, err := c.ProcessChallenge(cm.Bytes())
+			return err
+		}, ErrMalformedMessage},
+	}
+
+	for _, c := range cases {
+		err := c.err()
+		if !errors.Is(err, c.want) {
+			t.Errorf("%s: err = %v, want %v", c.name, err, c.want)
+		}
+		for _, other := range []error{ErrMessageTooShort, ErrMalformedMessage, ErrNTProofMismatch, ErrMICMismatch, ErrBadSignature, ErrFlagDowngrade, ErrAuthLevel, ErrClockSkew} {
+			if other != c.want && errors.Is(err, other) {
+				t.Errorf("%s: err = %v matches %v", c.name, err, other)
+			}
+		}
+	}
+}
+
+func mustChallenge(t *testing.T, server *Server, type1 []byte) []byte {
+	type2, err := server.Challenge(type1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return type2
+}
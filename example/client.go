@@ -1,10 +1,9 @@
 package main
 
 import (
-	"encoding/base64"
 	"fmt"
-	"ntlmssp"
 
+	"github.com/JKme/go-ntlmssp"
 	"github.com/eddieivan01/nic"
 )
 
@@ -17,7 +16,7 @@ func client() {
 		return
 	}
 
-	type1 := ntlmssp.NewNegotiateMsg(nil)
+	type1, _ := ntlmssp.NewNegotiateMsg(nil)
 	type1.NegotiateFlags |= ntlmssp.NEGOTIATE_OEM_DOMAIN_SUPPLIED |
 		ntlmssp.NEGOTIATE_OEM_WORKSTATION_SUPPLIED |
 		ntlmssp.NEGOTIATE_128BIT_SESSION_KEY |
@@ -30,7 +29,7 @@ func client() {
 
 	resp, err = nic.Post(url, nic.H{
 		Headers: nic.KV{
-			"Authorization": "NTLM " + base64.StdEncoding.EncodeToString(type1.Marshal('<')),
+			"Authorization": ntlmssp.EncodeHeader(type1.Bytes()),
 		},
 	})
 	if err != nil {
@@ -38,16 +37,19 @@ func client() {
 		return
 	}
 
-	// trip "NTLM "
-	bs, err := base64.StdEncoding.DecodeString(resp.Header.Get("WWW-Authenticate")[5:])
+	bs, err := ntlmssp.DecodeHeader(resp.Header.Get("WWW-Authenticate"))
 	if err != nil {
 		fmt.Println("type2 error")
 		return
 	}
-	type2 := ntlmssp.NewChallengeMsg(bs)
+	type2, err := ntlmssp.NewChallengeMsg(bs)
+	if err != nil {
+		fmt.Println("type2 error,", err)
+		return
+	}
 	type2.Display()
 
-	type3 := ntlmssp.NewAuthenticateMsg(nil)
+	type3, _ := ntlmssp.NewAuthenticateMsg(nil)
 	type3.NegotiateFlags = type2.NegotiateFlags
 	// type3.NegotiateFlags &^= ntlmssp.NEGOTIATE_EXTENDED_SESSION_SECURITY
 
@@ -58,7 +60,7 @@ func client() {
 
 	resp, err = nic.Post(url, nic.H{
 		Headers: nic.KV{
-			"Authorization": "NTLM " + base64.StdEncoding.EncodeToString(type3.Marshal('<')),
+			"Authorization": ntlmssp.EncodeHeader(type3.Bytes()),
 		},
 	})
 
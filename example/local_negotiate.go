@@ -1,10 +1,14 @@
+//go:build windows
+// +build windows
+
 package main
 
 import (
 	"fmt"
-	"ntlmssp"
 	"syscall"
 	"unsafe"
+
+	"github.com/JKme/go-ntlmssp"
 )
 
 var (
@@ -87,13 +91,13 @@ func localNegotiate() {
 	secBufDescClient := secBufferDesc{}
 	initTokenContextBuffer(&secBufDescClient, &secBufClient)
 
-	type1 := ntlmssp.NewNegotiateMsg(nil)
+	type1, _ := ntlmssp.NewNegotiateMsg(nil)
 	type1.NegotiateFlags |= ntlmssp.NEGOTIATE_128BIT_SESSION_KEY |
 		ntlmssp.NEGOTIATE_56BIT_ENCRYPTION |
 		ntlmssp.NEGOTIATE_UNICODE_CHARSET |
 		ntlmssp.NEGOTIATE_EXTENDED_SESSION_SECURITY
 
-	bs := type1.Marshal('<')
+	bs := type1.Bytes()
 	secBufClient.cbBuffer = uint32(len(bs))
 	secBufClient.pvBuffer = (uintptr)(unsafe.Pointer(&bs[0]))
 
@@ -119,17 +123,21 @@ func localNegotiate() {
 		fmt.Println(err)
 		return
 	}
-	type2 := ntlmssp.NewChallengeMsg(loadByteArray(secBufServer.pvBuffer, secBufServer.cbBuffer))
+	type2, err := ntlmssp.NewChallengeMsg(loadByteArray(secBufServer.pvBuffer, secBufServer.cbBuffer))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
 	type2.Display()
 
-	type3 := ntlmssp.NewAuthenticateMsg(nil)
+	type3, _ := ntlmssp.NewAuthenticateMsg(nil)
 	type3.NegotiateFlags = type2.NegotiateFlags
 	// type3.NegotiateFlags &^= ntlmssp.NEGOTIATE_EXTENDED_SESSION_SECURITY
 	type3.SetUserName(username)
 	type3.SetWorkstation(servername)
 	type3.SetNTLMResponse(1, type2.ServerChallenge[:], password)
 	type3.Display()
-	bs = type3.Marshal('<')
+	bs = type3.Bytes()
 
 	initTokenContextBuffer(&secBufDescClient, &secBufClient)
 	secBufClient.pvBuffer = (uintptr)(unsafe.Pointer(&bs[0]))
@@ -2,9 +2,9 @@ package main
 
 import (
 	"bytes"
-	"encoding/base64"
 	"net/http"
-	"ntlmssp"
+
+	"github.com/JKme/go-ntlmssp"
 )
 
 var challenge = []byte("\x00\x11\x22\x33\x44\x55\x66\x77")
@@ -18,18 +18,32 @@ func handler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	bs, err := base64.StdEncoding.DecodeString(auth[5:])
+	bs, err := ntlmssp.DecodeHeader(auth)
 	if err != nil {
 		w.Header().Set("WWW-Authenticate", "NTLM")
 		w.WriteHeader(401)
-		w.Write([]byte("Malformed base64"))
+		w.Write([]byte("Malformed authorization header"))
 		return
 	}
 
-	switch bs[8] {
+	msgType, err := ntlmssp.DetectMessageType(bs)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", "NTLM")
+		w.WriteHeader(401)
+		w.Write([]byte("Malformed NTLMSSP"))
+		return
+	}
+
+	switch msgType {
 	case 1:
-		type1 := ntlmssp.NewNegotiateMsg(bs)
-		type2 := ntlmssp.NewChallengeMsg(nil)
+		type1, err := ntlmssp.NewNegotiateMsg(bs)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", "NTLM")
+			w.WriteHeader(401)
+			w.Write([]byte("Malformed NTLMSSP"))
+			return
+		}
+		type2, _ := ntlmssp.NewChallengeMsg(nil)
 
 		type2.NegotiateFlags = type1.NegotiateFlags
 		type2.NegotiateFlags &^= ntlmssp.NEGOTIATE_VERSION
@@ -44,46 +58,47 @@ func handler(w http.ResponseWriter, r *http.Request) {
 			"MsvAvDnsDomainName":   "XYZ.LAB",
 		})
 
-		w.Header().Set("WWW-Authenticate", "NTLM "+base64.StdEncoding.EncodeToString(type2.Marshal('<')))
+		w.Header().Set("WWW-Authenticate", ntlmssp.EncodeHeader(type2.Bytes()))
 		w.WriteHeader(401)
 	case 3:
-		type3 := ntlmssp.NewAuthenticateMsg(bs)
+		type3, err := ntlmssp.NewAuthenticateMsg(bs)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", "NTLM")
+			w.WriteHeader(401)
+			w.Write([]byte("Malformed NTLMSSP"))
+			return
+		}
 		ok := false
 		if type3.NtChallengeResponseLen <= 24 {
 			// NTLMv2 session
 			if type3.NegotiateFlags&ntlmssp.NEGOTIATE_EXTENDED_SESSION_SECURITY != 0 {
-				ntsResp := ntlmssp.ComputeNTLMv2SessionResponse(
+				_, ntsResp := ntlmssp.ComputeNTLM2SessionResponse(
+					ntlmssp.NTHash(string(pwd)),
 					challenge,
-					type3.LmChallengeResponse()[:8],
-					ntlmssp.NtHash(pwd))
-				if bytes.Equal(ntsResp, type3.NtChallengeResponseBytes()) {
+					type3.LmChallengeResponse())
+				// nil for an LM response shorter than the client challenge
+				if ntsResp != nil && bytes.Equal(ntsResp, type3.NtChallengeResponseBytes()) {
 					ok = true
 				}
 			} else {
 				// NTLM
-				ntResp := ntlmssp.ComputeNTLMv1Response(challenge, ntlmssp.NtHash(pwd))
+				ntResp := ntlmssp.ComputeNTv1Response(ntlmssp.NTHash(string(pwd)), challenge)
 				if bytes.Equal(ntResp, type3.NtChallengeResponseBytes()) {
 					ok = true
 				}
 			}
 		} else {
 			// NTLMv2
-			userNameWithDomainOrServer := type3.UserNameBytes()
-			if type3.DomainNameLen != 0 {
-				userNameWithDomainOrServer = append(userNameWithDomainOrServer, type3.DomainNameBytes()...)
-			} else if type3.WorkstationLen != 0 {
-				userNameWithDomainOrServer = append(userNameWithDomainOrServer, type3.WorkstationBytes()...)
+			domainOrServer := type3.DomainName()
+			if domainOrServer == "" {
+				domainOrServer = type3.Workstation()
 			}
 
-			ntResp := ntlmssp.ComputeNTLMv2Response(
+			ok = ntlmssp.VerifyNTLMv2Response(
+				ntlmssp.NTOWFv2(string(pwd), type3.UserName(), domainOrServer),
 				challenge,
-				userNameWithDomainOrServer,
-				ntlmssp.NtHash(pwd),
-				type3.NtChallengeResponseBytes()[16:],
+				type3.NtChallengeResponseBytes(),
 			)
-			if bytes.Equal(ntResp, type3.NtChallengeResponseBytes()) {
-				ok = true
-			}
 		}
 
 		if ok {
@@ -0,0 +1,125 @@
+package ntlmssp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Builder for NegotiateFlags, e.g.
+// NegotiateFlags(0).Unicode().NTLMv2().Sign().Seal().Value()
+type NegotiateFlags uint32
+
+func (f NegotiateFlags) Unicode() NegotiateFlags {
+	return f | NEGOTIATE_UNICODE_CHARSET
+}
+
+func (f NegotiateFlags) OEM() NegotiateFlags {
+	return f | NEGOTIATE_OEM_CHARSET
+}
+
+// NEGOTIATE_NTLM with NEGOTIATE_EXTENDED_SESSION_SECURITY, there is no
+// dedicated bit for NTLMv2
+func (f NegotiateFlags) NTLMv2() NegotiateFlags {
+	return f | NEGOTIATE_NTLM | NEGOTIATE_EXTENDED_SESSION_SECURITY
+}
+
+func (f NegotiateFlags) ExtendedSessionSecurity() NegotiateFlags {
+	return f | NEGOTIATE_EXTENDED_SESSION_SECURITY
+}
+
+func (f NegotiateFlags) KeyExchange() NegotiateFlags {
+	return f | NEGOTIATE_EXPLICIT_KEY_EXCHANGE
+}
+
+func (f NegotiateFlags) Sign() NegotiateFlags {
+	return f | NEGOTIATE_SIGN
+}
+
+func (f NegotiateFlags) Seal() NegotiateFlags {
+	return f | NEGOTIATE_SEAL
+}
+
+func (f NegotiateFlags) Version() NegotiateFlags {
+	return f | NEGOTIATE_VERSION
+}
+
+func (f NegotiateFlags) Has(flag uint32) bool {
+	return uint32(f)&flag == flag
+}
+
+func (f NegotiateFlags) Value() uint32 {
+	return uint32(f)
+}
+
+// Flags that only the server sets in the CHALLENGE_MESSAGE
+const serverOnlyFlags = NEGOTIATE_TARGET_INFO | NEGOTIATE_TARGET_TYPE_SERVER | NEGOTIATE_TARGET_TYPE_DOMAIN
+
+// Flags of the CHALLENGE_MESSAGE for the client's NEGOTIATE_MESSAGE flags
+// and the flags supported by the server, MS-NLMP 3.2.5.1.1. Only one of
+// the charsets is kept, Unicode preferred, and extended session security
+// wins over NEGOTIATE_LM_SESSION_KEY.
+func Negotiated(client, server uint32) uint32 {
+	flags := client & server &^ (NEGOTIATE_UNICODE_CHARSET | NEGOTIATE_OEM_CHARSET | serverOnlyFlags)
+	flags |= server & serverOnlyFlags
+
+	if client&server&NEGOTIATE_UNICODE_CHARSET != 0 {
+		flags |= NEGOTIATE_UNICODE_CHARSET
+	} else if client&server&NEGOTIATE_OEM_CHARSET != 0 {
+		flags |= NEGOTIATE_OEM_CHARSET
+	}
+
+	if flags&NEGOTIATE_EXTENDED_SESSION_SECURITY != 0 {
+		flags &^= NEGOTIATE_LM_SESSION_KEY
+	}
+	return flags
+}
+
+// Names of the flags defined by MS-NLMP 2.2.2.5, lowest bit first. The
+// r1-r10 bits are reserved and left out.
+var negotiateFlagNames = []struct {
+	flag uint32
+	name string
+}{
+	{NEGOTIATE_UNICODE_CHARSET, "NEGOTIATE_UNICODE_CHARSET"},
+	{NEGOTIATE_OEM_CHARSET, "NEGOTIATE_OEM_CHARSET"},
+	{NEGOTIATE_REQUEST_TARGET_NAME, "NEGOTIATE_REQUEST_TARGET_NAME"},
+	{NEGOTIATE_SIGN, "NEGOTIATE_SIGN"},
+	{NEGOTIATE_SEAL, "NEGOTIATE_SEAL"},
+	{NEGOTIATE_DATAGRAM_CONNECTIONLESS, "NEGOTIATE_DATAGRAM_CONNECTIONLESS"},
+	{NEGOTIATE_LM_SESSION_KEY, "NEGOTIATE_LM_SESSION_KEY"},
+	{NEGOTIATE_NTLM, "NEGOTIATE_NTLM"},
+	{NEGOTIATE_ANONYMOUS, "NEGOTIATE_ANONYMOUS"},
+	{NEGOTIATE_OEM_DOMAIN_SUPPLIED, "NEGOTIATE_OEM_DOMAIN_SUPPLIED"},
+	{NEGOTIATE_OEM_WORKSTATION_SUPPLIED, "NEGOTIATE_OEM_WORKSTATION_SUPPLIED"},
+	{NEGOTIATE_ALWAYS_SIGN, "NEGOTIATE_ALWAYS_SIGN"},
+	{NEGOTIATE_TARGET_TYPE_DOMAIN, "NEGOTIATE_TARGET_TYPE_DOMAIN"},
+	{NEGOTIATE_TARGET_TYPE_SERVER, "NEGOTIATE_TARGET_TYPE_SERVER"},
+	{NEGOTIATE_EXTENDED_SESSION_SECURITY, "NEGOTIATE_EXTENDED_SESSION_SECURITY"},
+	{NEGOTIATE_IDENTITY_LEVEL_TOKEN, "NEGOTIATE_IDENTITY_LEVEL_TOKEN"},
+	{NEGOTIATE_REQUEST_NON_NT_SESSION_KEY, "NEGOTIATE_REQUEST_NON_NT_SESSION_KEY"},
+	{NEGOTIATE_TARGET_INFO, "NEGOTIATE_TARGET_INFO"},
+	{NEGOTIATE_VERSION, "NEGOTIATE_VERSION"},
+	{NEGOTIATE_128BIT_SESSION_KEY, "NEGOTIATE_128BIT_SESSION_KEY"},
+	{NEGOTIATE_EXPLICIT_KEY_EXCHANGE, "NEGOTIATE_EXPLICIT_KEY_EXCHANGE"},
+	{NEGOTIATE_56BIT_ENCRYPTION, "NEGOTIATE_56BIT_ENCRYPTION"},
+}
+
+// The set flags as "NEGOTIATE_UNICODE_CHARSET | NEGOTIATE_NTLM | ...",
+// lowest bit first, for logs and tests. Reserved bits that are set come
+// last as a single 0x... value, no flags at all is "0".
+func NegotiateFlagsString(flags uint32) string {
+	var names []string
+	for _, f := range negotiateFlagNames {
+		if flags&f.flag != 0 {
+			names = append(names, f.name)
+			flags &^= f.flag
+		}
+	}
+	if len(names) == 0 && flags == 0 {
+		return "0"
+	}
+	if flags != 0 {
+		names = append(names, fmt.Sprintf("0x%x", flags))
+	}
+	return strings.Join(names, " | ")
+}
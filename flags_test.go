@@ -0,0 +1,65 @@
+package ntlmssp
+
+import "testing"
+
+func TestNegotiateFlags(t *testing.T) {
+	flags := NegotiateFlags(0).Unicode().NTLMv2().Sign().Seal()
+	want := uint32(NEGOTIATE_UNICODE_CHARSET | NEGOTIATE_NTLM | NEGOTIATE_EXTENDED_SESSION_SECURITY | NEGOTIATE_SIGN | NEGOTIATE_SEAL)
+	if flags.Value() != want {
+		t.Errorf("Value = %x, want %x", flags.Value(), want)
+	}
+	if !flags.Has(NEGOTIATE_SIGN|NEGOTIATE_SEAL) || flags.Has(NEGOTIATE_SIGN|NEGOTIATE_VERSION) {
+		t.Errorf("Has is wrong for %x", flags.Value())
+	}
+}
+
+func TestNegotiated(t *testing.T) {
+	server := NegotiateFlags(0).Unicode().OEM().NTLMv2().Sign().Seal().KeyExchange().Version().Value() |
+		NEGOTIATE_LM_SESSION_KEY | NEGOTIATE_TARGET_INFO | NEGOTIATE_TARGET_TYPE_DOMAIN | NEGOTIATE_128BIT_SESSION_KEY
+
+	cases := []struct {
+		name   string
+		client uint32
+		want   uint32
+	}{
+		{"unicode preferred",
+			NEGOTIATE_UNICODE_CHARSET | NEGOTIATE_OEM_CHARSET | NEGOTIATE_NTLM,
+			NEGOTIATE_UNICODE_CHARSET | NEGOTIATE_NTLM | NEGOTIATE_TARGET_INFO | NEGOTIATE_TARGET_TYPE_DOMAIN},
+		{"OEM only",
+			NEGOTIATE_OEM_CHARSET | NEGOTIATE_NTLM,
+			NEGOTIATE_OEM_CHARSET | NEGOTIATE_NTLM | NEGOTIATE_TARGET_INFO | NEGOTIATE_TARGET_TYPE_DOMAIN},
+		{"ESS over LM key",
+			NEGOTIATE_UNICODE_CHARSET | NEGOTIATE_NTLM | NEGOTIATE_EXTENDED_SESSION_SECURITY | NEGOTIATE_LM_SESSION_KEY,
+			NEGOTIATE_UNICODE_CHARSET | NEGOTIATE_NTLM | NEGOTIATE_EXTENDED_SESSION_SECURITY | NEGOTIATE_TARGET_INFO | NEGOTIATE_TARGET_TYPE_DOMAIN},
+		{"unsupported dropped",
+			NEGOTIATE_UNICODE_CHARSET | NEGOTIATE_NTLM | NEGOTIATE_56BIT_ENCRYPTION | NEGOTIATE_128BIT_SESSION_KEY | NEGOTIATE_SEAL,
+			NEGOTIATE_UNICODE_CHARSET | NEGOTIATE_NTLM | NEGOTIATE_128BIT_SESSION_KEY | NEGOTIATE_SEAL | NEGOTIATE_TARGET_INFO | NEGOTIATE_TARGET_TYPE_DOMAIN},
+	}
+	for _, c := range cases {
+		if got := Negotiated(c.client, server); got != c.want {
+			t.Errorf("%s: Negotiated = %x, want %x", c.name, got, c.want)
+		}
+	}
+}
+
+func TestNegotiateFlagsString(t *testing.T) {
+	cases := []struct {
+		flags uint32
+		want  string
+	}{
+		{0, "0"},
+		{NEGOTIATE_UNICODE_CHARSET | NEGOTIATE_NTLM | NEGOTIATE_EXPLICIT_KEY_EXCHANGE,
+			"NEGOTIATE_UNICODE_CHARSET | NEGOTIATE_NTLM | NEGOTIATE_EXPLICIT_KEY_EXCHANGE"},
+		{0xe2898215,
+			"NEGOTIATE_UNICODE_CHARSET | NEGOTIATE_REQUEST_TARGET_NAME | NEGOTIATE_SIGN | NEGOTIATE_NTLM | " +
+				"NEGOTIATE_ALWAYS_SIGN | NEGOTIATE_TARGET_TYPE_DOMAIN | NEGOTIATE_EXTENDED_SESSION_SECURITY | " +
+				"NEGOTIATE_TARGET_INFO | NEGOTIATE_VERSION | NEGOTIATE_128BIT_SESSION_KEY | " +
+				"NEGOTIATE_EXPLICIT_KEY_EXCHANGE | NEGOTIATE_56BIT_ENCRYPTION"},
+		{NEGOTIATE_SEAL | NEGOTIATE_R10_UNUSED | NEGOTIATE_R1_UNUSED, "NEGOTIATE_SEAL | 0x10000008"},
+	}
+	for _, c := range cases {
+		if got := NegotiateFlagsString(c.flags); got != c.want {
+			t.Errorf("NegotiateFlagsString(%x) = %q, want %q", c.flags, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+//go:build go1.18
+// +build go1.18
+
+package ntlmssp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// Seeds besides testdata/fuzz
+func fuzzSeeds(f *testing.F) {
+	client := NewClient(Credentials{User: "User", Domain: "Domain", Password: "Password"})
+	type1 := client.Negotiate()
+	type2, _ := NewServer().Challenge(type1)
+	type3, _ := client.ProcessChallenge(type2)
+	for _, bs := range [][]byte{type1, type2, type3} {
+		f.Add(bs)
+	}
+}
+
+func FuzzNegotiateUnMarshal(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, bs []byte) {
+		nm, err := NewNegotiateMsg(bs)
+		if err != nil {
+			return
+		}
+		nm.Validate()
+		nm.DomainName()
+		nm.Workstation()
+		nm.Version()
+		nm.Dump(ioutil.Discard)
+
+		out := nm.Marshal('<')
+		if !bytes.Equal(out, bs[:len(out)]) {
+			t.Errorf("Marshal(UnMarshal(%x)) = %x", bs, out)
+		}
+	})
+}
+
+func FuzzChallengeUnMarshal(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, bs []byte) {
+		cm, err := NewChallengeMsg(bs)
+		if err != nil {
+			return
+		}
+		cm.Validate()
+		cm.TargetName()
+		ParseAVPair(cm.TargetInfo())
+		cm.Version()
+		cm.Dump(ioutil.Discard)
+		cm.String(bs)
+
+		out := cm.Marshal('<')
+		if !bytes.Equal(out, bs[:len(out)]) {
+			t.Errorf("Marshal(UnMarshal(%x)) = %x", bs, out)
+		}
+	})
+}
+
+func FuzzAuthenticateUnMarshal(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, bs []byte) {
+		am, err := NewAuthenticateMsg(bs)
+		if err != nil {
+			return
+		}
+		am.Validate()
+		am.LmChallengeResponse()
+		am.NtChallengeResponse()
+		am.UserName()
+		am.DomainName()
+		am.Workstation()
+		am.EncryptedRandomSessionKey()
+		am.Version()
+		am.MIC()
+		am.IsAnonymous()
+		am.Dump(ioutil.Discard)
+
+		out := am.Marshal('<')
+		if !bytes.Equal(out, bs[:len(out)]) {
+			t.Errorf("Marshal(UnMarshal(%x)) = %x", bs, out)
+		}
+	})
+}
+
+func FuzzParseAVPair(f *testing.F) {
+	f.Add(decodeHex("02000c0044006f006d00610069006e0001000c0053006500720076006500720000000000"))
+	f.Fuzz(func(t *testing.T, bs []byte) {
+		ParseAVPair(bs)
+		ParseAVPairSafe(bs)
+		ReadAvPairs(bs)
+		if pairs, err := ParseAVPairsOrdered(bs); err == nil {
+			if out := pairs.Marshal(); !bytes.Equal(out, bs[:len(out)]) {
+				t.Errorf("Marshal(ParseAVPairsOrdered(%x)) = %x", bs, out)
+			}
+		}
+	})
+}
+
+// Authenticate a fuzzed AUTHENTICATE_MESSAGE against a real challenge
+func FuzzServerAuthenticate(f *testing.F) {
+	fuzzSeeds(f)
+	forged, _ := NewAuthenticateMsg(nil)
+	forged.NegotiateFlags = NEGOTIATE_UNICODE_CHARSET | NEGOTIATE_NTLM | NEGOTIATE_ANONYMOUS
+	forged.ReserveMIC()
+	forged.SetLmChallengeResponse([]byte{0})
+	forged.SetNtChallengeResponse(make([]byte, 30))
+	f.Add(forged.Bytes())
+
+	type1 := NewClient(Credentials{User: "User", Domain: "Domain", Password: "Password"}).Negotiate()
+	f.Fuzz(func(t *testing.T, bs []byte) {
+		server := NewServer()
+		server.SetCredentials("User", "Domain", NtHash([]byte("Password")))
+		server.AllowAnonymous = true
+		server.MinAuthLevel = AuthLevelNTLMv1
+		server.MaxClockSkew = time.Hour
+		type2, err := server.Challenge(type1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		server.Authenticate(bs)
+
+		am, err := NewAuthenticateMsg(bs)
+		if err != nil {
+			return
+		}
+		VerifyMIC(am, make([]byte, 16), type1, type2)
+		am.SetMIC(make([]byte, 16), type1, type2)
+	})
+}
@@ -0,0 +1,61 @@
+package ntlmssp
+
+import (
+	"fmt"
+)
+
+// GSS_Wrap() of RFC 2743, the token is the NTLMSSP_MESSAGE_SIGNATURE
+// followed by the message, sealed if confidential
+func (sc *SecurityContext) Wrap(plaintext []byte, confidential bool) (token []byte, err error) {
+	if confidential {
+		sealed, signature, err := sc.Seal(plaintext)
+		if err != nil {
+			return nil, err
+		}
+		return append(signature, sealed...), nil
+	}
+
+	signature, err := sc.Sign(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return append(signature, plaintext...), nil
+}
+
+// GSS_Unwrap() of RFC 2743, tokens of both Wrap modes are accepted. The
+// token is tried as sealed first when sealing is negotiated, on a copy of
+// the RC4 state so the other mode can still be checked.
+func (sc *SecurityContext) Unwrap(token []byte) (plaintext []byte, err error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.flags&(NEGOTIATE_SIGN|NEGOTIATE_SEAL) == 0 {
+		return nil, fmt.Errorf("ntlmssp: signing not negotiated")
+	}
+	if err := sc.checkMode(false); err != nil {
+		return nil, err
+	}
+	if len(token) < 16 {
+		return nil, fmt.Errorf("%w: wrap token of %d bytes", ErrMessageTooShort, len(token))
+	}
+	signature, data := token[:16], token[16:]
+
+	if sc.flags&NEGOTIATE_SEAL != 0 {
+		handle := *sc.unsealHandle
+		message := make([]byte, len(data))
+		handle.XORKeyStream(message, data)
+		if sc.checkSignature(sc.mac(&handle, sc.verifyKey, sc.peerSeqNum, message), signature) == nil {
+			*sc.unsealHandle = handle
+			sc.peerSeqNum++
+			return message, nil
+		}
+	}
+
+	handle := *sc.unsealHandle
+	if err := sc.checkSignature(sc.mac(&handle, sc.verifyKey, sc.peerSeqNum, data), signature); err != nil {
+		return nil, err
+	}
+	*sc.unsealHandle = handle
+	sc.peerSeqNum++
+	return append([]byte{}, data...), nil
+}
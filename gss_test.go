@@ -0,0 +1,47 @@
+package ntlmssp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSecurityContext_Wrap(t *testing.T) {
+	key := decodeHex("55555555555555555555555555555555")
+	for _, flags := range []uint32{
+		NEGOTIATE_SIGN | NEGOTIATE_SEAL | NEGOTIATE_128BIT_SESSION_KEY,
+		NEGOTIATE_SIGN | NEGOTIATE_SEAL | NEGOTIATE_EXTENDED_SESSION_SECURITY | NEGOTIATE_128BIT_SESSION_KEY,
+		NEGOTIATE_SIGN | NEGOTIATE_SEAL | NEGOTIATE_EXTENDED_SESSION_SECURITY | NEGOTIATE_EXPLICIT_KEY_EXCHANGE | NEGOTIATE_128BIT_SESSION_KEY,
+	} {
+		client := NewSecurityContext(flags, key, "Client")
+		server := NewSecurityContext(flags, key, "Server")
+
+		for i, confidential := range []bool{true, false, false, true} {
+			msg := []byte{'m', 's', 'g', byte(i)}
+			token, err := client.Wrap(msg, confidential)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(token) != 16+len(msg) || bytes.Equal(token[16:], msg) != !confidential {
+				t.Errorf("flags %x: Wrap(%q, %v) = %x", flags, msg, confidential, token)
+			}
+			plain, err := server.Unwrap(token)
+			if err != nil || !bytes.Equal(plain, msg) {
+				t.Errorf("flags %x: Unwrap(Wrap(%q, %v)) = %q, %v", flags, msg, confidential, plain, err)
+			}
+		}
+
+		token, _ := client.Wrap([]byte("tampered"), false)
+		token[len(token)-1] ^= 1
+		if _, err := server.Unwrap(token); err == nil {
+			t.Errorf("flags %x: Unwrap accepted a tampered token", flags)
+		}
+	}
+
+	sc := NewSecurityContext(NEGOTIATE_SIGN|NEGOTIATE_EXTENDED_SESSION_SECURITY, key, "Client")
+	if _, err := sc.Wrap([]byte("msg"), true); err == nil {
+		t.Error("Wrap(confidential) without NEGOTIATE_SEAL: expected error")
+	}
+	if _, err := sc.Unwrap(make([]byte, 15)); err == nil {
+		t.Error("Unwrap(short token): expected error")
+	}
+}
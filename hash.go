@@ -2,6 +2,7 @@ package ntlmssp
 
 import (
 	"bytes"
+	"strings"
 
 	"golang.org/x/crypto/md4"
 )
@@ -10,20 +11,49 @@ const (
 	LmSalt = "KGS!@#$%"
 )
 
+// Deprecated: use LMHash
 func LmHash(pwd []byte) []byte {
 	pwd = bytes.ToUpper(pwd)
-	if len(pwd) < 14 {
+	if len(pwd) > 14 {
+		pwd = pwd[:14]
+	} else if len(pwd) < 14 {
 		length := len(pwd)
 		for i := 0; i < 14-length; i++ {
 			pwd = append(pwd, 0)
 		}
 	}
 
-	return append(desEnc(padding(pwd[:7]), []byte(LmSalt)), desEnc(padding(pwd[7:]), []byte(LmSalt))...)
+	return append(desEnc(expandDESKey(pwd[:7]), []byte(LmSalt)), desEnc(expandDESKey(pwd[7:]), []byte(LmSalt))...)
 }
 
+// Deprecated: use NTHash
 func NtHash(pwd []byte) []byte {
 	hsh := md4.New()
 	hsh.Write(encodeUTF16LE(pwd))
 	return hsh.Sum(nil)
 }
+
+// MS-NLMP 3.3.1 LMOWFv1, the password is uppercased and truncated to 14
+// characters
+func LMHash(password string) []byte {
+	return LmHash([]byte(password))
+}
+
+// MS-NLMP 3.3.1 NTOWFv1, MD4(UNICODE(password))
+func NTHash(password string) []byte {
+	return NtHash([]byte(password))
+}
+
+// MS-NLMP 3.3.2 NTOWFv2
+func NTOWFv2(password, user, domain string) []byte {
+	return ntowfv2(NTHash(password), user, domain)
+}
+
+// MS-NLMP 3.3.2 LMOWFv2, which is the same as NTOWFv2
+func LMOWFv2(password, user, domain string) []byte {
+	return NTOWFv2(password, user, domain)
+}
+
+func ntowfv2(nthash []byte, user, domain string) []byte {
+	return hmacMd5(nthash, encodeUTF16LE([]byte(strings.ToUpper(user)+domain)))
+}
@@ -0,0 +1,34 @@
+package ntlmssp
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// MS-NLMP 4.2.4.1.1
+func TestNTOWFv2(t *testing.T) {
+	want := "0c868a403bfd7a93a3001ef22ef02e3f"
+
+	if got := hex.EncodeToString(NTOWFv2("Password", "User", "Domain")); got != want {
+		t.Errorf("NTOWFv2 = %s, want %s", got, want)
+	}
+	if got := hex.EncodeToString(LMOWFv2("Password", "User", "Domain")); got != want {
+		t.Errorf("LMOWFv2 = %s, want %s", got, want)
+	}
+}
+
+// MS-NLMP 4.2.2.1.1
+func TestNTHash(t *testing.T) {
+	if got, want := hex.EncodeToString(NTHash("Password")), "a4f49c406510bdcab6824ee7c30fd852"; got != want {
+		t.Errorf("NTHash = %s, want %s", got, want)
+	}
+	if got, want := hex.EncodeToString(LMHash("Password")), "e52cac67419a9a224a3b108f3fa6cb6d"; got != want {
+		t.Errorf("LMHash = %s, want %s", got, want)
+	}
+	if got, want := hex.EncodeToString(LMHash("password")), "e52cac67419a9a224a3b108f3fa6cb6d"; got != want {
+		t.Errorf("LMHash is not case-insensitive: %s, want %s", got, want)
+	}
+	if got, want := LMHash("Password123456extra"), LMHash("Password123456"); hex.EncodeToString(got) != hex.EncodeToString(want) {
+		t.Errorf("LMHash does not truncate to 14 characters")
+	}
+}
@@ -0,0 +1,31 @@
+package ntlmssp
+
+import (
+	"encoding/hex"
+	"strings"
+)
+
+// Captured NTLMv2 handshake in the hashcat mode 5600 (NetNTLMv2) format,
+// user::domain:serverChallenge:NTProofStr:blob
+func NetNTLMv2String(user, domain string, serverChallenge, ntChallengeResponse []byte) string {
+	if len(ntChallengeResponse) < 16 {
+		return ""
+	}
+	return strings.Join([]string{
+		user, "", domain,
+		hex.EncodeToString(serverChallenge),
+		hex.EncodeToString(ntChallengeResponse[:16]),
+		hex.EncodeToString(ntChallengeResponse[16:]),
+	}, ":")
+}
+
+// Captured NTLMv1 handshake in the hashcat mode 5500 / John the Ripper
+// netntlm format, user::domain:lmResponse:ntResponse:serverChallenge
+func NetNTLMv1String(user, domain string, serverChallenge, lmResponse, ntResponse []byte) string {
+	return strings.Join([]string{
+		user, "", domain,
+		hex.EncodeToString(lmResponse),
+		hex.EncodeToString(ntResponse),
+		hex.EncodeToString(serverChallenge),
+	}, ":")
+}
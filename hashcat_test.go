@@ -0,0 +1,35 @@
+package ntlmssp
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestNetNTLMv2String(t *testing.T) {
+	// MS-NLMP 4.2.4
+	serverChallenge := decodeHex("0123456789abcdef")
+	targetInfo := decodeHex("02000c0044006f006d00610069006e0001000c0053006500720076006500720000000000")
+	ntresp, _ := ComputeNTLMv2Response(NTOWFv2("Password", "User", "Domain"), serverChallenge,
+		decodeHex("aaaaaaaaaaaaaaaa"), make([]byte, 8), targetInfo)
+
+	want := "User::Domain:0123456789abcdef:68cd0ab851e51c96aabc927bebef6a1c:" +
+		"0101000000000000" + "0000000000000000" + "aaaaaaaaaaaaaaaa" + "00000000" + hex.EncodeToString(targetInfo) + "00000000"
+	if got := NetNTLMv2String("User", "Domain", serverChallenge, ntresp); got != want {
+		t.Errorf("NetNTLMv2String =\n%s\nwant\n%s", got, want)
+	}
+	if got := NetNTLMv2String("User", "Domain", serverChallenge, ntresp[:8]); got != "" {
+		t.Errorf("NetNTLMv2String(short) = %q", got)
+	}
+}
+
+func TestNetNTLMv1String(t *testing.T) {
+	// MS-NLMP 4.2.2
+	serverChallenge := decodeHex("0123456789ABCDEF")
+	lmresp := ComputeLMv1Response(LMHash("Password"), serverChallenge)
+	ntresp := ComputeNTv1Response(NTHash("Password"), serverChallenge)
+
+	want := "User::Domain:98def7b87f88aa5dafe2df779688a172def11c7d5ccdef13:67c43011f30298a2ad35ece64f16331c44bdbed927841f94:0123456789abcdef"
+	if got := NetNTLMv1String("User", "Domain", serverChallenge, lmresp, ntresp); got != want {
+		t.Errorf("NetNTLMv1String =\n%s\nwant\n%s", got, want)
+	}
+}
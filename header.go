@@ -0,0 +1,83 @@
+package ntlmssp
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// Authorization / WWW-Authenticate header value for NTLM over HTTP
+func EncodeHeader(msg []byte) string {
+	return "NTLM " + base64.StdEncoding.EncodeToString(msg)
+}
+
+// Message carried by an "NTLM <base64>" or "Negotiate <base64>" header
+// value, the scheme is case-insensitive
+func DecodeHeader(header string) ([]byte, error) {
+	parts := strings.SplitN(strings.TrimSpace(header), " ", 2)
+	if len(parts) != 2 || (!strings.EqualFold(parts[0], "NTLM") && !strings.EqualFold(parts[0], "Negotiate")) {
+		return nil, fmt.Errorf("ntlmssp: malformed authentication header %q", header)
+	}
+
+	bs, err := base64.StdEncoding.DecodeString(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return nil, fmt.Errorf("ntlmssp: malformed authentication header: %v", err)
+	}
+	return bs, nil
+}
+
+// The NTLM challenge among WWW-Authenticate header values, which may list
+// several schemes each, e.g. `Basic realm="x", NTLM`. "Negotiate" is
+// preferred when both are offered, its token is SPNEGO. The token is nil
+// for a bare scheme, as in the server's first 401.
+func SelectChallenge(headers []string) (scheme string, token []byte, err error) {
+	var ntlm, negotiate string
+	for _, header := range headers {
+		for _, challenge := range splitChallenges(header) {
+			challenge = strings.TrimSpace(challenge)
+			name := strings.SplitN(challenge, " ", 2)[0]
+			if strings.EqualFold(name, "Negotiate") && negotiate == "" {
+				negotiate = challenge
+			} else if strings.EqualFold(name, "NTLM") && ntlm == "" {
+				ntlm = challenge
+			}
+		}
+	}
+	if ntlm == "" && negotiate == "" {
+		return "", nil, fmt.Errorf("ntlmssp: no NTLM or Negotiate challenge")
+	}
+
+	scheme, challenge := "Negotiate", negotiate
+	if negotiate == "" {
+		scheme, challenge = "NTLM", ntlm
+	}
+	if len(challenge) == len(scheme) {
+		return scheme, nil, nil
+	}
+	token, err = DecodeHeader(challenge)
+	if err != nil {
+		return "", nil, err
+	}
+	return scheme, token, nil
+}
+
+// Split a header value at the commas outside quoted strings, RFC 7235
+// auth-params may quote a comma as in `Digest realm="a,b"`
+func splitChallenges(header string) []string {
+	var parts []string
+	quoted, escaped, start := false, false, 0
+	for i := 0; i < len(header); i++ {
+		switch c := header[i]; {
+		case escaped:
+			escaped = false
+		case quoted && c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			parts = append(parts, header[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, header[start:])
+}
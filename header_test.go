@@ -0,0 +1,62 @@
+package ntlmssp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDecodeHeader(t *testing.T) {
+	msg := []byte("NTLMSSP\x00\x01\x00\x00\x00")
+	header := EncodeHeader(msg)
+	if header != "NTLM TlRMTVNTUAABAAAA" {
+		t.Errorf("EncodeHeader = %q", header)
+	}
+
+	for _, h := range []string{header, "ntlm TlRMTVNTUAABAAAA", "Negotiate TlRMTVNTUAABAAAA", "NEGOTIATE  TlRMTVNTUAABAAAA "} {
+		bs, err := DecodeHeader(h)
+		if err != nil {
+			t.Errorf("DecodeHeader(%q): %v", h, err)
+		} else if !bytes.Equal(bs, msg) {
+			t.Errorf("DecodeHeader(%q) = %x", h, bs)
+		}
+	}
+
+	for _, h := range []string{"", "TlRMTVNTUAABAAAA", "Basic dXNlcjpwYXNz", "NTLM", "NTLM not*base64"} {
+		if _, err := DecodeHeader(h); err == nil {
+			t.Errorf("DecodeHeader(%q): expected error", h)
+		}
+	}
+}
+
+func TestSelectChallenge(t *testing.T) {
+	for _, c := range []struct {
+		headers []string
+		scheme  string
+		token   string
+	}{
+		{[]string{"NTLM"}, "NTLM", ""},
+		{[]string{`Basic realm="intranet"`, "NTLM"}, "NTLM", ""},
+		{[]string{`Basic realm="intranet", NTLM`}, "NTLM", ""},
+		{[]string{"NTLM", "Negotiate"}, "Negotiate", ""},
+		{[]string{"ntlm, negotiate"}, "Negotiate", ""},
+		{[]string{"NTLM TlRMTVNTUAABAAAA"}, "NTLM", "NTLMSSP\x00\x01\x00\x00\x00"},
+		{[]string{`Basic realm="x"`, "Negotiate YQ==, NTLM TlRMTVNTUAABAAAA"}, "Negotiate", "a"},
+		{[]string{`Digest realm="x", qop="auth"`, "NTLM TlRMTVNTUAABAAAA", `Basic realm="y"`}, "NTLM", "NTLMSSP\x00\x01\x00\x00\x00"},
+		{[]string{`Digest realm="a, Negotiate b", NTLM`}, "NTLM", ""},
+		{[]string{`Basic realm="a \", Negotiate b", NTLM TlRMTVNTUAABAAAA`}, "NTLM", "NTLMSSP\x00\x01\x00\x00\x00"},
+	} {
+		scheme, token, err := SelectChallenge(c.headers)
+		if err != nil || scheme != c.scheme || string(token) != c.token {
+			t.Errorf("SelectChallenge(%q) = %q, %q, %v, want %q, %q", c.headers, scheme, token, err, c.scheme, c.token)
+		}
+		if c.token == "" && token != nil {
+			t.Errorf("SelectChallenge(%q): token %q for a bare scheme", c.headers, token)
+		}
+	}
+
+	for _, headers := range [][]string{nil, {`Basic realm="x"`}, {"NTLMv2"}, {"NTLM not*base64"}} {
+		if _, _, err := SelectChallenge(headers); err == nil {
+			t.Errorf("SelectChallenge(%q): expected error", headers)
+		}
+	}
+}
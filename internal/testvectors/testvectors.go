@@ -0,0 +1,83 @@
+// Package testvectors holds the worked examples of MS-NLMP 4.2, the
+// inputs common to all of them and the values computed by each.
+package testvectors
+
+import "encoding/hex"
+
+func mustHex(s string) []byte {
+	bs, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return bs
+}
+
+// MS-NLMP 4.2.1 common values
+var (
+	User        = "User"
+	UserDom     = "Domain"
+	Password    = "Password"
+	ServerName  = "Server"
+	Workstation = "COMPUTER"
+
+	RandomSessionKey = mustHex("55555555555555555555555555555555")
+	Time             = mustHex("0000000000000000")
+	ClientChallenge  = mustHex("aaaaaaaaaaaaaaaa")
+	ServerChallenge  = mustHex("0123456789abcdef")
+
+	// Plaintext of the sealing examples, "Plaintext" in UTF-16LE
+	Plaintext = mustHex("50006c00610069006e007400650078007400")
+)
+
+// MS-NLMP 4.2.2 NTLMv1 authentication
+var (
+	NTLMv1NTOWF = mustHex("a4f49c406510bdcab6824ee7c30fd852")
+	NTLMv1LMOWF = mustHex("e52cac67419a9a224a3b108f3fa6cb6d")
+
+	NTLMv1SessionBaseKey = mustHex("d87262b0cde4b1cb7499becccdf10784")
+	// KeyExchangeKey with NEGOTIATE_LM_SESSION_KEY
+	NTLMv1LMKeyExchangeKey = mustHex("b09e379f7fbecb1eaf0afdcb0383c8a0")
+	// KeyExchangeKey with NEGOTIATE_REQUEST_NON_NT_SESSION_KEY
+	NTLMv1NonNTKeyExchangeKey = mustHex("e52cac67419a9a220000000000000000")
+
+	NTLMv1NTChallengeResponse = mustHex("67c43011f30298a2ad35ece64f16331c44bdbed927841f94")
+	NTLMv1LMChallengeResponse = mustHex("98def7b87f88aa5dafe2df779688a172def11c7d5ccdef13")
+	// RandomSessionKey under the SessionBaseKey
+	NTLMv1EncryptedSessionKey = mustHex("518822b1b3f350c8958682ecbb3e3cb7")
+
+	NTLMv1SealedPlaintext = mustHex("56fe04d861f9319af0d7238a2e3b4d457fb8")
+	NTLMv1Signature       = mustHex("010000000000000009dcd1df2e459d36")
+)
+
+// MS-NLMP 4.2.3 NTLMv1 with extended session security
+var (
+	NTLM2SessionLMChallengeResponse = mustHex("aaaaaaaaaaaaaaaa00000000000000000000000000000000")
+	NTLM2SessionNTChallengeResponse = mustHex("7537f803ae367128ca458204bde7caf81e97ed2683267232")
+	NTLM2SessionKeyExchangeKey      = mustHex("eb93429a8bd952f8b89c55b87f475edc")
+
+	NTLM2SessionClientSignKey = mustHex("60e799be5c72fc92922ae8ebe961fb8d")
+	NTLM2SessionClientSealKey = mustHex("04dd7f014d8504d265a25cc86a3a7c06")
+
+	NTLM2SessionSealedPlaintext = mustHex("a02372f6530273f3aa1eb90190ce5200c99d")
+	NTLM2SessionSignature       = mustHex("01000000ff2aeb52f681793a00000000")
+)
+
+// MS-NLMP 4.2.4 NTLMv2 authentication
+var (
+	// MsvAvNbDomainName "Domain", MsvAvNbComputerName "Server", MsvAvEOL
+	NTLMv2TargetInfo = mustHex("02000c0044006f006d00610069006e0001000c0053006500720076006500720000000000")
+
+	NTLMv2NTOWF = mustHex("0c868a403bfd7a93a3001ef22ef02e3f")
+
+	NTLMv2SessionBaseKey      = mustHex("8de40ccadbc14a82f15cb0ad0de95ca3")
+	NTLMv2NTProofStr          = mustHex("68cd0ab851e51c96aabc927bebef6a1c")
+	NTLMv2LMChallengeResponse = mustHex("86c35097ac9cec102554764a57cccc19aaaaaaaaaaaaaaaa")
+	// RandomSessionKey under the SessionBaseKey, which is the KeyExchangeKey
+	NTLMv2EncryptedSessionKey = mustHex("c5dad2544fc9799094ce1ce90bc9d03e")
+
+	NTLMv2ClientSignKey = mustHex("4788dc861b4782f35d43fd98fe1a2d39")
+	NTLMv2ClientSealKey = mustHex("59f600973cc4960a25480a7c196e4c58")
+
+	NTLMv2SealedPlaintext = mustHex("54e50165bf1936dc996020c1811b0f06fb5f")
+	NTLMv2Signature       = mustHex("010000007fb38ec5c55d497600000000")
+)
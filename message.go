@@ -0,0 +1,204 @@
+package ntlmssp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+var ntlmsspSignature = []byte("NTLMSSP\x00")
+
+// MessageType field of an NTLMSSP message, after checking its signature
+func MessageType(bs []byte) (uint32, error) {
+	if len(bs) < 12 {
+		return 0, fmt.Errorf("%w: %d bytes", ErrMessageTooShort, len(bs))
+	}
+	if !bytes.Equal(bs[:8], ntlmsspSignature) {
+		return 0, fmt.Errorf("%w: invalid signature %q", ErrMalformedMessage, bs[:8])
+	}
+	return uint32(bytes2Uint(bs[8:12], '<')), nil
+}
+
+// Like MessageType, but only NEGOTIATE (1), CHALLENGE (2) and
+// AUTHENTICATE (3) messages are accepted. Use it to pick between
+// NewNegotiateMsg, NewChallengeMsg and NewAuthenticateMsg.
+func DetectMessageType(bs []byte) (uint32, error) {
+	msgType, err := MessageType(bs)
+	if err != nil {
+		return 0, err
+	}
+	if msgType < 1 || msgType > 3 {
+		return 0, fmt.Errorf("%w: unknown message type %d", ErrMalformedMessage, msgType)
+	}
+	return msgType, nil
+}
+
+func checkMessageType(bs []byte, want uint32) error {
+	msgType, err := MessageType(bs)
+	if err != nil {
+		return err
+	}
+	if msgType != want {
+		return fmt.Errorf("%w: message type %d, want %d", ErrMalformedMessage, msgType, want)
+	}
+	return nil
+}
+
+// Common interface of NegotiateMsg, ChallengeMsg and AuthenticateMsg
+type Message interface {
+	// The message on the wire
+	Bytes() []byte
+	// 1, 2 or 3, independent of the MessageType field
+	MessageTypeID() uint32
+	Validate() error
+}
+
+func (NegotiateMsg) MessageTypeID() uint32    { return 1 }
+func (ChallengeMsg) MessageTypeID() uint32    { return 2 }
+func (AuthenticateMsg) MessageTypeID() uint32 { return 3 }
+
+// *NegotiateMsg, *ChallengeMsg or *AuthenticateMsg by the message type of
+// bs. The message is parsed but not validated.
+func ParseMessage(bs []byte) (Message, error) {
+	msgType, err := DetectMessageType(bs)
+	if err != nil {
+		return nil, err
+	}
+	// no typed nil inside the interface on error
+	var msg Message
+	switch msgType {
+	case 1:
+		msg, err = NewNegotiateMsg(bs)
+	case 2:
+		msg, err = NewChallengeMsg(bs)
+	default:
+		msg, err = NewAuthenticateMsg(bs)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// Upper bound of the messages accepted by the parsers, by the end of
+// their last buffer, so a crafted offset or length can't make them
+// allocate much. Real messages are a few KB at most.
+var MaxMessageSize = 64 * 1024
+
+func checkMessageSize(end uint64) error {
+	if end > uint64(MaxMessageSize) {
+		return fmt.Errorf("%w: message of %d bytes exceeds MaxMessageSize %d", ErrMalformedMessage, end, MaxMessageSize)
+	}
+	return nil
+}
+
+// Read a message of type msgType with a header of headerLen bytes from r,
+// then as much payload as the buffers at fieldsAt (the positions of their
+// Len fields) and the Version field need.
+func readMessage(r io.Reader, msgType uint32, headerLen, flagsAt int, fieldsAt ...int) ([]byte, error) {
+	bs := make([]byte, headerLen)
+	if _, err := io.ReadFull(r, bs); err != nil {
+		return nil, err
+	}
+	if err := checkMessageType(bs, msgType); err != nil {
+		return nil, err
+	}
+
+	end := uint64(headerLen)
+	if uint32(bytes2Uint(bs[flagsAt:flagsAt+4], '<'))&NEGOTIATE_VERSION != 0 {
+		end += 8
+	}
+	for _, at := range fieldsAt {
+		length := bytes2Uint(bs[at:at+2], '<')
+		offset := bytes2Uint(bs[at+4:at+8], '<')
+		if length != 0 && offset+length > end {
+			end = offset + length
+		}
+	}
+	if err := checkMessageSize(end); err != nil {
+		return nil, err
+	}
+
+	bs = append(bs, make([]byte, end-uint64(headerLen))...)
+	if _, err := io.ReadFull(r, bs[headerLen:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return bs, nil
+}
+
+// Read a NEGOTIATE_MESSAGE embedded in a stream, exactly the bytes of the
+// message are consumed
+func ReadNegotiateMsg(r io.Reader) (*NegotiateMsg, error) {
+	bs, err := readMessage(r, 1, NegotiateMsgPayloadOffset, 12, 16, 24)
+	if err != nil {
+		return nil, err
+	}
+	return NewNegotiateMsg(bs)
+}
+
+// Read a CHALLENGE_MESSAGE embedded in a stream
+func ReadChallengeMsg(r io.Reader) (*ChallengeMsg, error) {
+	bs, err := readMessage(r, 2, ChallengeMsgPayloadOffset, 20, 12, 40)
+	if err != nil {
+		return nil, err
+	}
+	return NewChallengeMsg(bs)
+}
+
+// Read an AUTHENTICATE_MESSAGE embedded in a stream
+func ReadAuthenticateMsg(r io.Reader) (*AuthenticateMsg, error) {
+	bs, err := readMessage(r, 3, AuthenticateMsgPayloadOffset, 60, 12, 20, 28, 36, 44, 52)
+	if err != nil {
+		return nil, err
+	}
+	return NewAuthenticateMsg(bs)
+}
+
+// Len, MaxLen and BufferOffset of a payload field
+type securityBuffer struct {
+	name   string
+	length uint16
+	maxLen uint16
+	offset uint32
+}
+
+// Bytes of a payload field, nil unless it lies within the payload. The
+// offset is checked against the header length first, a message built by
+// hand may have any offset.
+func payloadField(payload []byte, headerLen, offset uint32, length uint16) []byte {
+	if offset < headerLen || uint64(offset-headerLen)+uint64(length) > uint64(len(payload)) {
+		return nil
+	}
+	start := offset - headerLen
+	return payload[start : start+uint32(length)]
+}
+
+// Check that the non-empty buffers lie in [start, end), start being the
+// end of the fixed fields, that MaxLen is not below Len and that no two
+// buffers overlap
+func validateBuffers(start, end uint64, buffers ...securityBuffer) error {
+	for i, b := range buffers {
+		if b.length == 0 {
+			continue
+		}
+		if b.maxLen < b.length {
+			return fmt.Errorf("%w: %s MaxLen %d below Len %d", ErrMalformedMessage, b.name, b.maxLen, b.length)
+		}
+		bStart, bEnd := uint64(b.offset), uint64(b.offset)+uint64(b.length)
+		if bStart < start {
+			return fmt.Errorf("%w: %s offset %d before the payload at %d", ErrMalformedMessage, b.name, b.offset, start)
+		}
+		if bEnd > end {
+			return fmt.Errorf("%w: %s (offset %d, len %d) exceeds message length %d", ErrMalformedMessage, b.name, b.offset, b.length, end)
+		}
+		for _, o := range buffers[:i] {
+			if o.length != 0 && bStart < uint64(o.offset)+uint64(o.length) && uint64(o.offset) < bEnd {
+				return fmt.Errorf("%w: %s overlaps %s", ErrMalformedMessage, b.name, o.name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package ntlmssp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestMessageType(t *testing.T) {
+	type1, _ := NewNegotiateMsg(nil)
+	type2, _ := NewChallengeMsg(nil)
+	type3, _ := NewAuthenticateMsg(nil)
+
+	for want, bs := range map[uint32][]byte{
+		1: type1.Marshal('<'),
+		2: type2.Marshal('<'),
+		3: type3.Marshal('<'),
+	} {
+		if got, err := MessageType(bs); err != nil || got != want {
+			t.Errorf("MessageType = %d, %v, want %d", got, err, want)
+		}
+	}
+
+	bad := type2.Marshal('<')
+	bad[0] = 'n'
+	if _, err := NewChallengeMsg(bad); err == nil {
+		t.Error("NewChallengeMsg accepted a wrong signature")
+	}
+	if _, err := NewChallengeMsg(append(type3.Marshal('<'), make([]byte, 16)...)); err == nil {
+		t.Error("NewChallengeMsg accepted a type 3 message")
+	}
+	if _, err := NewNegotiateMsg(append(type2.Marshal('<'), make([]byte, 16)...)); err == nil {
+		t.Error("NewNegotiateMsg accepted a type 2 message")
+	}
+	if _, err := NewAuthenticateMsg(append(type1.Marshal('<'), make([]byte, 64)...)); err == nil {
+		t.Error("NewAuthenticateMsg accepted a type 1 message")
+	}
+}
+
+func TestDetectMessageType(t *testing.T) {
+	type1, _ := NewNegotiateMsg(nil)
+	type2, _ := NewChallengeMsg(nil)
+	type3, _ := NewAuthenticateMsg(nil)
+
+	cases := []struct {
+		name string
+		bs   []byte
+		want uint32
+		err  bool
+	}{
+		{"negotiate", type1.Marshal('<'), 1, false},
+		{"challenge", type2.Marshal('<'), 2, false},
+		{"authenticate", type3.Marshal('<'), 3, false},
+		{"empty", nil, 0, true},
+		{"short", []byte("NTLMSSP\x00\x01\x00"), 0, true},
+		{"garbage", []byte("GET / HTTP/1.1\r\n"), 0, true},
+		{"type 4", []byte("NTLMSSP\x00\x04\x00\x00\x00"), 0, true},
+	}
+	for _, c := range cases {
+		got, err := DetectMessageType(c.bs)
+		if (err != nil) != c.err || got != c.want {
+			t.Errorf("%s: DetectMessageType = %d, %v, want %d", c.name, got, err, c.want)
+		}
+	}
+}
+
+func TestParseMessage(t *testing.T) {
+	type1, _ := NewNegotiateMsg(nil)
+	type2, _ := NewChallengeMsg(nil)
+	type3, _ := NewAuthenticateMsg(nil)
+
+	for _, want := range []Message{type1, type2, type3} {
+		msg, err := ParseMessage(want.Bytes())
+		if err != nil {
+			t.Fatalf("%T: %v", want, err)
+		}
+		if reflect.TypeOf(msg) != reflect.TypeOf(want) || msg.MessageTypeID() != want.MessageTypeID() {
+			t.Errorf("ParseMessage = %T (type %d), want %T", msg, msg.MessageTypeID(), want)
+		}
+		if err := msg.Validate(); err != nil {
+			t.Errorf("%T: Validate: %v", msg, err)
+		}
+	}
+
+	for _, bs := range [][]byte{nil, []byte("NTLMSSP\x00\x04\x00\x00\x00"), type2.Marshal('<')[:40]} {
+		if msg, err := ParseMessage(bs); err == nil || msg != nil {
+			t.Errorf("ParseMessage(%x) = %v, %v", bs, msg, err)
+		}
+	}
+}
+
+func TestReadMsg(t *testing.T) {
+	server := NewServer()
+	client := NewClient(Credentials{User: "User", Domain: "Domain", Password: "Password"})
+	type1 := client.Negotiate()
+	type2, err := server.Challenge(type1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type3, err := client.ProcessChallenge(type2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trailer := []byte("trailing frame")
+	var stream []byte
+	for _, bs := range [][]byte{type1, type2, type3} {
+		stream = append(stream, bs...)
+	}
+	r := bytes.NewReader(append(stream, trailer...))
+
+	nm, err := ReadNegotiateMsg(r)
+	if err != nil || !bytes.Equal(nm.Marshal('<'), type1) {
+		t.Errorf("ReadNegotiateMsg = %x, %v", nm.Marshal('<'), err)
+	}
+	cm, err := ReadChallengeMsg(r)
+	if err != nil || !bytes.Equal(cm.Marshal('<'), type2) {
+		t.Errorf("ReadChallengeMsg = %x, %v", cm.Marshal('<'), err)
+	}
+	am, err := ReadAuthenticateMsg(r)
+	if err != nil || !bytes.Equal(am.Marshal('<'), type3) {
+		t.Errorf("ReadAuthenticateMsg = %x, %v", am.Marshal('<'), err)
+	}
+	if rest, _ := ioutil.ReadAll(r); !bytes.Equal(rest, trailer) {
+		t.Errorf("left in the stream: %q, want %q", rest, trailer)
+	}
+
+	for _, n := range []int{1, ChallengeMsgPayloadOffset - 1, ChallengeMsgPayloadOffset, len(type2) - 1} {
+		if _, err := ReadChallengeMsg(bytes.NewReader(type2[:n])); err != io.ErrUnexpectedEOF {
+			t.Errorf("ReadChallengeMsg(%d of %d bytes): err = %v, want io.ErrUnexpectedEOF", n, len(type2), err)
+		}
+	}
+	for n := 1; n < len(type3); n++ {
+		if _, err := ReadAuthenticateMsg(bytes.NewReader(type3[:n])); err != io.ErrUnexpectedEOF {
+			t.Fatalf("ReadAuthenticateMsg(%d of %d bytes): err = %v, want io.ErrUnexpectedEOF", n, len(type3), err)
+		}
+	}
+	if _, err := ReadChallengeMsg(bytes.NewReader(type3)); err == nil {
+		t.Error("ReadChallengeMsg accepted a type 3 message")
+	}
+}
+
+func TestMarshalOrder(t *testing.T) {
+	nm, _ := NewNegotiateMsg(nil)
+	nm.NegotiateFlags = NEGOTIATE_UNICODE_CHARSET | NEGOTIATE_OEM_DOMAIN_SUPPLIED
+	nm.SetDomainName([]byte("Domain"))
+	cm, _ := NewChallengeMsg(nil)
+	cm.NegotiateFlags = NEGOTIATE_UNICODE_CHARSET
+	cm.SetTargetName([]byte("Domain"))
+	am, _ := NewAuthenticateMsg(nil)
+	am.NegotiateFlags = NEGOTIATE_UNICODE_CHARSET
+	am.SetUserName([]byte("User"))
+
+	for _, c := range []struct {
+		name        string
+		bytes       []byte
+		little, big []byte
+		msgType     string
+	}{
+		{"negotiate", nm.Bytes(), nm.MarshalOrder(binary.LittleEndian), nm.MarshalOrder(binary.BigEndian), "00000001"},
+		{"challenge", cm.Bytes(), cm.MarshalOrder(binary.LittleEndian), cm.MarshalOrder(binary.BigEndian), "00000002"},
+		{"authenticate", am.Bytes(), am.MarshalOrder(binary.LittleEndian), am.MarshalOrder(binary.BigEndian), "00000003"},
+	} {
+		if !bytes.Equal(c.bytes, c.little) {
+			t.Errorf("%s: Bytes = %x, want %x", c.name, c.bytes, c.little)
+		}
+		if got := binary.LittleEndian.Uint32(c.little[8:12]); got != binary.BigEndian.Uint32(c.big[8:12]) {
+			t.Errorf("%s: MessageType little endian %d", c.name, got)
+		}
+		if got := hex.EncodeToString(c.big[8:12]); got != c.msgType {
+			t.Errorf("%s: big endian MessageType = %s, want %s", c.name, got, c.msgType)
+		}
+		if len(c.big) != len(c.little) || !bytes.Equal(c.big[:8], c.little[:8]) {
+			t.Errorf("%s: MarshalOrder(BigEndian) = %x", c.name, c.big)
+		}
+	}
+
+	if !bytes.Equal(cm.Marshal('<'), cm.Bytes()) || !bytes.Equal(cm.Marshal('>'), cm.MarshalOrder(binary.BigEndian)) {
+		t.Error("Marshal differs from MarshalOrder")
+	}
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	// TargetInfo of 0xffff bytes at offset 0xffff0000, with no payload
+	// behind the header: rejected before reading or allocating it
+	cm, _ := NewChallengeMsg(nil)
+	cm.TargetInfoLen, cm.TargetInfoMaxLen, cm.TargetInfoBufferOffset = 0xffff, 0xffff, 0xffff0000
+	if _, err := ReadChallengeMsg(bytes.NewReader(cm.Bytes())); !errors.Is(err, ErrMalformedMessage) {
+		t.Errorf("ReadChallengeMsg of a 4 GiB TargetInfo: %v", err)
+	}
+
+	cm, _ = NewChallengeMsg(nil)
+	cm.NegotiateFlags = NEGOTIATE_UNICODE_CHARSET
+	cm.SetTargetName(bytes.Repeat([]byte("x"), 500))
+	bs := cm.Bytes()
+
+	defer func(n int) { MaxMessageSize = n }(MaxMessageSize)
+	MaxMessageSize = len(bs) - 1
+	if _, err := NewChallengeMsg(bs); !errors.Is(err, ErrMalformedMessage) {
+		t.Errorf("NewChallengeMsg of %d bytes, MaxMessageSize %d: %v", len(bs), MaxMessageSize, err)
+	}
+	if _, err := ReadChallengeMsg(bytes.NewReader(bs)); !errors.Is(err, ErrMalformedMessage) {
+		t.Errorf("ReadChallengeMsg of %d bytes, MaxMessageSize %d: %v", len(bs), MaxMessageSize, err)
+	}
+	MaxMessageSize = len(bs)
+	if _, err := NewChallengeMsg(bs); err != nil {
+		t.Errorf("NewChallengeMsg of MaxMessageSize bytes: %v", err)
+	}
+}
+
+func TestVersion_NoPayload(t *testing.T) {
+	// NEGOTIATE_VERSION set by hand, without SetVersion
+	nm := NegotiateMsg{NegotiateFlags: NEGOTIATE_VERSION}
+	cm := ChallengeMsg{NegotiateFlags: NEGOTIATE_VERSION, Payload: []byte{10, 0}}
+	am := AuthenticateMsg{NegotiateFlags: NEGOTIATE_VERSION}
+	for _, v := range [][]byte{nm.Version(), cm.Version(), am.Version()} {
+		if v != nil {
+			t.Errorf("Version = %x, want nil", v)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package ntlmssp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// Negotiator is an http.RoundTripper that answers NTLM challenges of the
+// server with the given credentials. The three legs of the handshake are
+// sent over the same keep-alive connection, as NTLM authenticates the
+// connection and not the request. Servers offering "Negotiate" get the
+// NTLM messages wrapped in SPNEGO, see SelectChallenge.
+type Negotiator struct {
+	// http.DefaultTransport if nil
+	http.RoundTripper
+
+	Credentials
+
+	// Authenticate to a forward proxy instead of the server: answer 407
+	// responses and their Proxy-Authenticate header with
+	// Proxy-Authorization. This covers plain HTTP requests sent through
+	// the proxy; CONNECT tunnels for HTTPS are set up by the Transport.
+	Proxy bool
+
+	// Called with the token of a final "Negotiate <token>" header on the
+	// response to the AUTHENTICATE_MESSAGE, the server's SPNEGO
+	// negTokenResp for mutual authentication. The RoundTrip does not
+	// check it.
+	MutualAuth func(token []byte)
+}
+
+// Status code and header names of the handshake
+func (n Negotiator) headers() (status int, challenge, authorization string) {
+	if n.Proxy {
+		return http.StatusProxyAuthRequired, "Proxy-Authenticate", "Proxy-Authorization"
+	}
+	return http.StatusUnauthorized, "WWW-Authenticate", "Authorization"
+}
+
+func (n Negotiator) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt := n.RoundTripper
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+
+	// the body is replayed for every leg
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	status, challenge, authHeader := n.headers()
+	resp, err := rt.RoundTrip(cloneRequest(req, body, authHeader, ""))
+	if err != nil || resp.StatusCode != status {
+		return resp, err
+	}
+	scheme, _, err := SelectChallenge(resp.Header[http.CanonicalHeaderKey(challenge)])
+	if err != nil {
+		return resp, nil
+	}
+	drainBody(resp)
+
+	client := NewClient(n.Credentials)
+	resp, err = rt.RoundTrip(cloneRequest(req, body, authHeader, authorization(scheme, client.Negotiate(), SPNEGOWrapInitial)))
+	if err != nil || resp.StatusCode != status {
+		return resp, err
+	}
+
+	bs, err := challengeHeader(resp, challenge, scheme)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	drainBody(resp)
+
+	type3, err := client.ProcessChallenge(bs)
+	if err != nil {
+		return nil, err
+	}
+	resp, err = rt.RoundTrip(cloneRequest(req, body, authHeader, authorization(scheme, type3, SPNEGOWrapResponse)))
+	if err == nil && scheme == "Negotiate" && n.MutualAuth != nil && resp.StatusCode != status {
+		if got, token, err := SelectChallenge(resp.Header[http.CanonicalHeaderKey(challenge)]); err == nil && got == scheme && token != nil {
+			n.MutualAuth(token)
+		}
+	}
+	return resp, err
+}
+
+func cloneRequest(req *http.Request, body []byte, header, authorization string) *http.Request {
+	r := req.Clone(req.Context())
+	if body != nil {
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+		r.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+	if authorization != "" {
+		r.Header.Set(header, authorization)
+		r.Header.Set("Connection", "keep-alive")
+	}
+	return r
+}
+
+// The connection is only reused when the body has been read to EOF
+func drainBody(resp *http.Response) {
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+func authorization(scheme string, msg []byte, wrap func([]byte) []byte) string {
+	if scheme == "NTLM" {
+		return EncodeHeader(msg)
+	}
+	return "Negotiate " + base64.StdEncoding.EncodeToString(wrap(msg))
+}
+
+func challengeHeader(resp *http.Response, header, scheme string) ([]byte, error) {
+	got, bs, err := SelectChallenge(resp.Header[http.CanonicalHeaderKey(header)])
+	if err != nil {
+		return nil, err
+	}
+	if got != scheme || bs == nil {
+		return nil, fmt.Errorf("ntlmssp: no %s challenge in the server response", scheme)
+	}
+	if scheme == "NTLM" {
+		return bs, nil
+	}
+
+	mech, inner, err := SPNEGOUnwrap(bs)
+	if err != nil {
+		return nil, err
+	}
+	if mech != nil && !mech.Equal(NTLMSSPOID) {
+		return nil, fmt.Errorf("ntlmssp: server chose mechanism %v instead of NTLM", mech)
+	}
+	return inner, nil
+}
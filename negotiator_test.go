@@ -0,0 +1,158 @@
+package ntlmssp
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// negTokenResp{negState accept-completed} of the final response
+var acceptCompleted = decodeHex("a1073005a0030a0100")
+
+// NTLMv2 only endpoint that pins the handshake to one connection, with
+// spnego it only offers "Negotiate". As a proxy it challenges with 407
+// and the Proxy-* headers.
+func ntlmHandler(t *testing.T, user, domain, password string, spnego, proxy bool) http.HandlerFunc {
+	scheme := "NTLM"
+	if spnego {
+		scheme = "Negotiate"
+	}
+	status, challengeHeader, authHeader := Negotiator{Proxy: proxy}.headers()
+	challenges := map[string][]byte{}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if body, _ := ioutil.ReadAll(r.Body); string(body) != "payload" {
+			t.Errorf("body = %q", body)
+		}
+
+		bs, err := DecodeHeader(r.Header.Get(authHeader))
+		if err == nil && spnego {
+			_, bs, err = SPNEGOUnwrap(bs)
+		}
+		if err != nil {
+			w.Header().Set(challengeHeader, scheme)
+			w.WriteHeader(status)
+			return
+		}
+
+		msgType, _ := DetectMessageType(bs)
+		switch msgType {
+		case 1:
+			type2, _ := NewChallengeMsg(nil)
+			type2.NegotiateFlags = NEGOTIATE_UNICODE_CHARSET | NEGOTIATE_NTLM | NEGOTIATE_EXTENDED_SESSION_SECURITY
+			type2.SetServerChallenge(nil)
+			challenges[r.RemoteAddr] = append([]byte{}, type2.ServerChallenge[:]...)
+
+			if spnego {
+				resp, _ := asn1.Marshal(negTokenResp{NegState: 1, SupportedMech: NTLMSSPOID, ResponseToken: type2.Marshal('<')})
+				token, _ := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: resp})
+				w.Header().Set(challengeHeader, "Negotiate "+base64.StdEncoding.EncodeToString(token))
+			} else {
+				w.Header().Set(challengeHeader, EncodeHeader(type2.Marshal('<')))
+			}
+			w.WriteHeader(status)
+		case 3:
+			challenge, ok := challenges[r.RemoteAddr]
+			if !ok {
+				t.Error("handshake not on the same connection")
+				w.WriteHeader(status)
+				return
+			}
+			type3, _ := NewAuthenticateMsg(bs)
+			resp := type3.NtChallengeResponseBytes()
+			expected, _ := ComputeNTLMv2Response(NTOWFv2(password, user, domain),
+				challenge, resp[32:40], resp[24:32], resp[44:len(resp)-4])
+			if type3.UserName() != user || !bytes.Equal(expected, resp) {
+				w.WriteHeader(status)
+				return
+			}
+			if spnego {
+				w.Header().Set(challengeHeader, "Negotiate "+base64.StdEncoding.EncodeToString(acceptCompleted))
+			}
+			w.Write([]byte("OK"))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}
+}
+
+func TestNegotiator(t *testing.T) {
+	for _, spnego := range []bool{false, true} {
+		testNegotiator(t, spnego)
+	}
+}
+
+func testNegotiator(t *testing.T, spnego bool) {
+	ts := httptest.NewServer(ntlmHandler(t, "User", "Domain", "Password", spnego, false))
+	defer ts.Close()
+
+	for _, c := range []struct {
+		password string
+		status   int
+	}{
+		{"Password", http.StatusOK},
+		{"wrong", http.StatusUnauthorized},
+	} {
+		client := http.Client{Transport: Negotiator{Credentials: Credentials{User: "User", Domain: "Domain", Password: c.password}}}
+		resp, err := client.Post(ts.URL, "text/plain", strings.NewReader("payload"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.status {
+			t.Errorf("spnego %v, password %q: status = %d, want %d", spnego, c.password, resp.StatusCode, c.status)
+		}
+	}
+}
+
+func TestNegotiator_Proxy(t *testing.T) {
+	proxy := httptest.NewServer(ntlmHandler(t, "User", "Domain", "Password", false, true))
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	for _, c := range []struct {
+		password string
+		status   int
+	}{
+		{"Password", http.StatusOK},
+		{"wrong", http.StatusProxyAuthRequired},
+	} {
+		client := http.Client{Transport: Negotiator{
+			RoundTripper: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
+			Credentials:  Credentials{User: "User", Domain: "Domain", Password: c.password},
+			Proxy:        true,
+		}}
+		resp, err := client.Post("http://server.invalid/", "text/plain", strings.NewReader("payload"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.status {
+			t.Errorf("password %q: status = %d, want %d", c.password, resp.StatusCode, c.status)
+		}
+	}
+}
+
+func TestNegotiator_MutualAuth(t *testing.T) {
+	ts := httptest.NewServer(ntlmHandler(t, "User", "Domain", "Password", true, false))
+	defer ts.Close()
+
+	var token []byte
+	client := http.Client{Transport: Negotiator{
+		Credentials: Credentials{User: "User", Domain: "Domain", Password: "Password"},
+		MutualAuth:  func(tok []byte) { token = tok },
+	}}
+	resp, err := client.Post(ts.URL, "text/plain", strings.NewReader("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(token, acceptCompleted) {
+		t.Errorf("status = %d, token = %x, want %x", resp.StatusCode, token, acceptCompleted)
+	}
+}
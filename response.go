@@ -1,6 +1,10 @@
 package ntlmssp
 
-import "unsafe"
+import (
+	"fmt"
+	"time"
+	"unsafe"
+)
 
 type LMResponse struct {
 	Response [24]byte
@@ -50,23 +54,64 @@ func (cc NTLMv2ClientChallenge) Marshal() []byte {
 		if avIdsRev[k] == 0 {
 			continue
 		}
-		output = append(output, avIdsRev[k], 0)
-
-		if avIdsRev[k] != 6 && avIdsRev[k] != 7 && avIdsRev[k] != 8 && avIdsRev[k] != 10 {
-			length := len(v.(string)) * 2
-			output = append(output, byte(length&0xff), byte((length&0xff00)>>8))
-			output = append(output, encodeUTF16LE([]byte(v.(string)))...)
-		} else {
-			length := len(v.([]byte))
-			output = append(output, byte(length&0xff), byte((length&0xff00)>>8))
-			output = append(output, v.([]byte)...)
+		var value []byte
+		switch v := v.(type) {
+		case string:
+			value = encodeUTF16LE([]byte(v))
+		case time.Time:
+			value = WindowsTimestamp(v)
+		case SingleHostData:
+			value = v.Encode()
+		case []byte:
+			value = v
 		}
+		output = append(output, avIdsRev[k], 0)
+		output = append(output, byte(len(value)&0xff), byte((len(value)&0xff00)>>8))
+		output = append(output, value...)
 	}
 	output = append(output, []byte{0, 0, 0, 0}...)
 	return output
 }
 
+// Fields of the temp blob of an NTLMv2 response, the NTLMv2_CLIENT_CHALLENGE
+// of MS-NLMP 2.2.2.7 that follows the NTProofStr
+type NTLMv2Blob struct {
+	// Responder version, 1 and 1
+	RespType        byte
+	HiRespType      byte
+	Timestamp       time.Time
+	ClientChallenge [8]byte
+	TargetInfo      AvPairs
+}
+
+// Split a captured NTLMv2 response into the NTProofStr and the fields of
+// the temp blob, the inverse of ComputeNTLMv2Response
+func ParseNTLMv2ResponseBlob(ntResponse []byte) (ntProofStr []byte, blob NTLMv2Blob, err error) {
+	if len(ntResponse) < 44 {
+		return nil, blob, fmt.Errorf("%w: NTLMv2 response of %d bytes", ErrMessageTooShort, len(ntResponse))
+	}
+	if ntResponse[16] != 1 || ntResponse[17] != 1 {
+		return nil, blob, fmt.Errorf("%w: NTLMv2 response version %d.%d", ErrMalformedMessage, ntResponse[16], ntResponse[17])
+	}
+
+	pairs, err := ParseAVPairsOrdered(ntResponse[44:])
+	if err != nil {
+		return nil, blob, err
+	}
+	blob.RespType = ntResponse[16]
+	blob.HiRespType = ntResponse[17]
+	blob.Timestamp = TimeFromWindowsTimestamp(ntResponse[24:32])
+	copy(blob.ClientChallenge[:], ntResponse[32:40])
+	blob.TargetInfo = *pairs
+	return ntResponse[:16], blob, nil
+}
+
+// nil if bs is shorter than the 44 bytes of NTProofStr and the fixed part
+// of NTLMv2_CLIENT_CHALLENGE
 func ParseNTLMv2Response(bs []byte) *NTLMv2Response {
+	if len(bs) < 44 {
+		return nil
+	}
 	ntv2r := NTLMv2Response{}
 	copy(ntv2r.Response[:], bs[:16])
 
@@ -80,3 +125,21 @@ func ParseNTLMv2Response(bs []byte) *NTLMv2Response {
 
 	return &ntv2r
 }
+
+// Kind of an NT challenge response by its length and structure: "NTLMv2",
+// "NTLMv1", "Anonymous" for an empty one, "" if unknown. An NTLM2 session
+// response has the 24 bytes of NTLMv1, AuthenticateMsg.ResponseType tells
+// them apart with the LM response.
+func ResponseType(ntResponse []byte) string {
+	switch {
+	case len(ntResponse) == 0:
+		return "Anonymous"
+	case len(ntResponse) == 24:
+		return "NTLMv1"
+	case len(ntResponse) >= 44 && ntResponse[16] == 1 && ntResponse[17] == 1:
+		// NTProofStr followed by RespType and HiRespType of
+		// NTLMv2_CLIENT_CHALLENGE
+		return "NTLMv2"
+	}
+	return ""
+}
@@ -0,0 +1,61 @@
+package ntlmssp
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// SASL "NTLM" mechanism for LDAP, IMAP and SMTP binds, with the
+// Start/Next shape of go-ldap and net/smtp style SASL clients
+type SaslClient struct {
+	client *Client
+	step   int
+}
+
+func NewSaslClient(cred Credentials) *SaslClient {
+	return &SaslClient{client: NewClient(cred)}
+}
+
+// NEGOTIATE_MESSAGE as the initial response
+func (sc *SaslClient) Start() (mech string, ir []byte, err error) {
+	sc.step = 1
+	return "NTLM", sc.client.Negotiate(), nil
+}
+
+// AUTHENTICATE_MESSAGE for the server's CHALLENGE_MESSAGE
+func (sc *SaslClient) Next(challenge []byte) (response []byte, err error) {
+	if sc.step != 1 {
+		return nil, fmt.Errorf("ntlmssp: unexpected SASL challenge")
+	}
+	sc.step++
+	return sc.client.ProcessChallenge(challenge)
+}
+
+// Signing and sealing context once the exchange is done
+func (sc *SaslClient) SecurityContext() *SecurityContext {
+	return sc.client.SecurityContext()
+}
+
+// LDAP PDU after an NTLM SASL bind, the 4-byte big-endian length of the
+// Wrap token followed by the token, sealed when NEGOTIATE_SEAL is
+// negotiated. nil if neither signing nor sealing is negotiated.
+func LDAPWrap(sc *SecurityContext, ldapMessage []byte) []byte {
+	token, err := sc.Wrap(ldapMessage, sc.flags&NEGOTIATE_SEAL != 0)
+	if err != nil {
+		return nil
+	}
+	wrapped := make([]byte, 4, 4+len(token))
+	binary.BigEndian.PutUint32(wrapped, uint32(len(token)))
+	return append(wrapped, token...)
+}
+
+// LDAP message of one PDU framed by LDAPWrap on the peer
+func LDAPUnwrap(sc *SecurityContext, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 4 {
+		return nil, fmt.Errorf("%w: SASL buffer of %d bytes", ErrMessageTooShort, len(wrapped))
+	}
+	if length := binary.BigEndian.Uint32(wrapped); int64(length) != int64(len(wrapped)-4) {
+		return nil, fmt.Errorf("%w: SASL buffer length %d, got %d bytes", ErrMalformedMessage, length, len(wrapped)-4)
+	}
+	return sc.Unwrap(wrapped[4:])
+}
@@ -0,0 +1,72 @@
+package ntlmssp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSaslClient(t *testing.T) {
+	server := NewServer()
+	server.SetCredentials("User", "Domain", NtHash([]byte("Password")))
+
+	sc := NewSaslClient(Credentials{User: "User", Domain: "Domain", Password: "Password"})
+	mech, ir, err := sc.Start()
+	if err != nil || mech != "NTLM" {
+		t.Fatalf("Start = %q, %v", mech, err)
+	}
+	challenge, err := server.Challenge(ir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := sc.Next(challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := server.Authenticate(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(session.SessionKey) != string(sc.client.SessionKey()) || sc.SecurityContext() == nil {
+		t.Error("session keys differ")
+	}
+
+	if _, err := sc.Next(challenge); err == nil {
+		t.Error("Next after the exchange: expected error")
+	}
+}
+
+func TestLDAPWrap(t *testing.T) {
+	key := decodeHex("55555555555555555555555555555555")
+	for _, flags := range []uint32{
+		NEGOTIATE_EXTENDED_SESSION_SECURITY | NEGOTIATE_128BIT_SESSION_KEY | NEGOTIATE_SIGN | NEGOTIATE_SEAL | NEGOTIATE_EXPLICIT_KEY_EXCHANGE,
+		NEGOTIATE_EXTENDED_SESSION_SECURITY | NEGOTIATE_128BIT_SESSION_KEY | NEGOTIATE_SIGN,
+	} {
+		client := NewSecurityContext(flags, key, "Client")
+		server := NewSecurityContext(flags, key, "Server")
+
+		for _, msg := range []string{"bind request", "search request"} {
+			wrapped := LDAPWrap(client, []byte(msg))
+			if len(wrapped) != 4+16+len(msg) || int(wrapped[3]) != 16+len(msg) {
+				t.Fatalf("%#x: LDAPWrap = %x", flags, wrapped)
+			}
+			if sealed := !bytes.Contains(wrapped, []byte(msg)); sealed != (flags&NEGOTIATE_SEAL != 0) {
+				t.Errorf("%#x: LDAPWrap sealed = %v", flags, sealed)
+			}
+			plain, err := LDAPUnwrap(server, wrapped)
+			if err != nil || string(plain) != msg {
+				t.Errorf("%#x: LDAPUnwrap = %q, %v", flags, plain, err)
+			}
+		}
+
+		wrapped := LDAPWrap(client, []byte("modify request"))
+		for _, bad := range [][]byte{wrapped[:3], wrapped[:len(wrapped)-1], append(append([]byte{}, wrapped...), 0)} {
+			if _, err := LDAPUnwrap(server, bad); err == nil {
+				t.Errorf("%#x: LDAPUnwrap(%x): expected error", flags, bad)
+			}
+		}
+	}
+
+	if LDAPWrap(NewSecurityContext(0, key, "Client"), []byte("bind request")) != nil {
+		t.Error("LDAPWrap without signing")
+	}
+}
@@ -0,0 +1,305 @@
+package ntlmssp
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rc4"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"sync"
+)
+
+// SecurityContext signs and seals messages after the handshake, as
+// described in MS-NLMP 3.4. The outgoing direction uses the keys of mode,
+// the incoming direction the keys of the peer.
+//
+// In connection-oriented mode each direction is one RC4 keystream over the
+// whole session, it is never re-initialized and the sequence number simply
+// wraps. In datagram mode (NEGOTIATE_DATAGRAM_CONNECTIONLESS) every message
+// gets a new RC4 handle from its own sequence number, see SignDatagram.
+//
+// It is safe for concurrent use. Concurrent Sign and Seal calls take the
+// next sequence numbers in the order they get the lock, each message stays
+// consistent with its own signature but the order between goroutines is
+// unspecified.
+type SecurityContext struct {
+	mu sync.Mutex
+
+	flags      uint32
+	sessionKey []byte
+	mode       string
+
+	signKey   []byte
+	verifyKey []byte
+
+	// RC4 keys, kept for per-message handles in datagram mode
+	sealKey   []byte
+	unsealKey []byte
+
+	sealHandle   *rc4.Cipher
+	unsealHandle *rc4.Cipher
+
+	seqNum     uint32
+	peerSeqNum uint32
+}
+
+// mode is "Client" or "Server", sessionKey is the exported session key
+func NewSecurityContext(flags uint32, sessionKey []byte, mode string) *SecurityContext {
+	sc := SecurityContext{flags: flags, sessionKey: sessionKey, mode: mode}
+	sc.Reset()
+	return &sc
+}
+
+// Derive the keys from the session key again and restart both RC4
+// keystreams and sequence numbers at 0, for a renegotiated session. The
+// peer must reset at the same point.
+func (sc *SecurityContext) Reset() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	peer := "Server"
+	if sc.mode == "Server" {
+		peer = "Client"
+	}
+
+	sc.signKey = SignKey(sc.flags, sc.sessionKey, sc.mode)
+	sc.verifyKey = SignKey(sc.flags, sc.sessionKey, peer)
+	sc.sealKey = SealKey(sc.flags, sc.sessionKey, sc.mode)
+	sc.unsealKey = SealKey(sc.flags, sc.sessionKey, peer)
+	sc.sealHandle, _ = rc4.NewCipher(sc.sealKey)
+	sc.unsealHandle, _ = rc4.NewCipher(sc.unsealKey)
+	sc.seqNum = 0
+	sc.peerSeqNum = 0
+}
+
+// Overwrite the session key, the signing and sealing keys and the RC4
+// state with zeros once the session is over, e.g. with defer. The context
+// must not be used afterwards.
+func (sc *SecurityContext) Wipe() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	for _, key := range [][]byte{sc.sessionKey, sc.signKey, sc.verifyKey, sc.sealKey, sc.unsealKey} {
+		zero(key)
+	}
+	if sc.sealHandle != nil {
+		sc.sealHandle.Reset()
+	}
+	if sc.unsealHandle != nil {
+		sc.unsealHandle.Reset()
+	}
+}
+
+func (sc *SecurityContext) checkMode(datagram bool) error {
+	if (sc.flags&NEGOTIATE_DATAGRAM_CONNECTIONLESS != 0) != datagram {
+		if datagram {
+			return fmt.Errorf("ntlmssp: datagram mode not negotiated")
+		}
+		return fmt.Errorf("ntlmssp: datagram mode needs an explicit sequence number")
+	}
+	return nil
+}
+
+// In datagram mode there is no RC4 state between messages, the handle is
+// initialized from MD5(key || seqNum) for every message
+func datagramHandle(key []byte, seqNum uint32) *rc4.Cipher {
+	hsh := md5.New()
+	hsh.Write(key)
+	binary.Write(hsh, binary.LittleEndian, seqNum)
+	handle, _ := rc4.NewCipher(hsh.Sum(nil))
+	return handle
+}
+
+// NTLMSSP_MESSAGE_SIGNATURE of message with the next sequence number
+func (sc *SecurityContext) Sign(message []byte) ([]byte, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.flags&(NEGOTIATE_SIGN|NEGOTIATE_SEAL) == 0 {
+		return nil, fmt.Errorf("ntlmssp: signing not negotiated")
+	}
+	if err := sc.checkMode(false); err != nil {
+		return nil, err
+	}
+
+	signature := sc.mac(sc.sealHandle, sc.signKey, sc.seqNum, message)
+	sc.seqNum++
+	return signature, nil
+}
+
+// Check the signature of a message received from the peer
+func (sc *SecurityContext) Verify(message, signature []byte) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.flags&(NEGOTIATE_SIGN|NEGOTIATE_SEAL) == 0 {
+		return fmt.Errorf("ntlmssp: signing not negotiated")
+	}
+	if err := sc.checkMode(false); err != nil {
+		return err
+	}
+
+	expected := sc.mac(sc.unsealHandle, sc.verifyKey, sc.peerSeqNum, message)
+	sc.peerSeqNum++
+	return sc.checkSignature(expected, signature)
+}
+
+// Encrypt message and sign the plaintext with the next sequence number
+func (sc *SecurityContext) Seal(message []byte) (sealed, signature []byte, err error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.flags&NEGOTIATE_SEAL == 0 {
+		return nil, nil, fmt.Errorf("ntlmssp: sealing not negotiated")
+	}
+	if err := sc.checkMode(false); err != nil {
+		return nil, nil, err
+	}
+
+	sealed = make([]byte, len(message))
+	sc.sealHandle.XORKeyStream(sealed, message)
+	signature = sc.mac(sc.sealHandle, sc.signKey, sc.seqNum, message)
+	sc.seqNum++
+	return sealed, signature, nil
+}
+
+// Decrypt a message received from the peer and check its signature
+func (sc *SecurityContext) Unseal(sealed, signature []byte) ([]byte, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.flags&NEGOTIATE_SEAL == 0 {
+		return nil, fmt.Errorf("ntlmssp: sealing not negotiated")
+	}
+	if err := sc.checkMode(false); err != nil {
+		return nil, err
+	}
+
+	message := make([]byte, len(sealed))
+	sc.unsealHandle.XORKeyStream(message, sealed)
+	expected := sc.mac(sc.unsealHandle, sc.verifyKey, sc.peerSeqNum, message)
+	sc.peerSeqNum++
+	if err := sc.checkSignature(expected, signature); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// Sign in datagram mode (NEGOTIATE_DATAGRAM_CONNECTIONLESS), the sequence
+// number is chosen by the application protocol, e.g. connectionless RPC
+func (sc *SecurityContext) SignDatagram(seqNum uint32, message []byte) ([]byte, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.flags&(NEGOTIATE_SIGN|NEGOTIATE_SEAL) == 0 {
+		return nil, fmt.Errorf("ntlmssp: signing not negotiated")
+	}
+	if err := sc.checkMode(true); err != nil {
+		return nil, err
+	}
+	return sc.mac(datagramHandle(sc.sealKey, seqNum), sc.signKey, seqNum, message), nil
+}
+
+// Check the signature of a datagram received from the peer
+func (sc *SecurityContext) VerifyDatagram(seqNum uint32, message, signature []byte) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.flags&(NEGOTIATE_SIGN|NEGOTIATE_SEAL) == 0 {
+		return fmt.Errorf("ntlmssp: signing not negotiated")
+	}
+	if err := sc.checkMode(true); err != nil {
+		return err
+	}
+
+	expected := sc.mac(datagramHandle(sc.unsealKey, seqNum), sc.verifyKey, seqNum, message)
+	return sc.checkSignature(expected, signature)
+}
+
+// Encrypt and sign a datagram with an explicit sequence number
+func (sc *SecurityContext) SealDatagram(seqNum uint32, message []byte) (sealed, signature []byte, err error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.flags&NEGOTIATE_SEAL == 0 {
+		return nil, nil, fmt.Errorf("ntlmssp: sealing not negotiated")
+	}
+	if err := sc.checkMode(true); err != nil {
+		return nil, nil, err
+	}
+
+	handle := datagramHandle(sc.sealKey, seqNum)
+	sealed = make([]byte, len(message))
+	handle.XORKeyStream(sealed, message)
+	signature = sc.mac(handle, sc.signKey, seqNum, message)
+	return sealed, signature, nil
+}
+
+// Decrypt a datagram received from the peer and check its signature
+func (sc *SecurityContext) UnsealDatagram(seqNum uint32, sealed, signature []byte) ([]byte, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.flags&NEGOTIATE_SEAL == 0 {
+		return nil, fmt.Errorf("ntlmssp: sealing not negotiated")
+	}
+	if err := sc.checkMode(true); err != nil {
+		return nil, err
+	}
+
+	handle := datagramHandle(sc.unsealKey, seqNum)
+	message := make([]byte, len(sealed))
+	handle.XORKeyStream(message, sealed)
+	expected := sc.mac(handle, sc.verifyKey, seqNum, message)
+	if err := sc.checkSignature(expected, signature); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// Compare the peer's signature with the expected one in constant time.
+// RandomPad of an NTLMv1 signature is not covered by the checksum and
+// some peers leave junk in it, so it is zeroed first.
+func (sc *SecurityContext) checkSignature(expected, signature []byte) error {
+	if sc.flags&NEGOTIATE_EXTENDED_SESSION_SECURITY == 0 && len(signature) == 16 {
+		signature = append(append(signature[:4:4], 0, 0, 0, 0), signature[8:]...)
+	}
+	if !hmac.Equal(expected, signature) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// MS-NLMP 3.4.4 MAC(). With extended session security the signature is
+// Version (1), Checksum (the first 8 bytes of HMAC_MD5 over SeqNum and
+// the message, RC4 encrypted with key exchange) and SeqNum. Without it
+// it is Version, RandomPad (always 0), Checksum (CRC32 of the message)
+// and SeqNum, the last two RC4 encrypted. Version and RandomPad are not
+// covered.
+func (sc *SecurityContext) mac(handle *rc4.Cipher, signKey []byte, seqNum uint32, message []byte) []byte {
+	signature := make([]byte, 16)
+	binary.LittleEndian.PutUint32(signature[:4], 1)
+
+	if sc.flags&NEGOTIATE_EXTENDED_SESSION_SECURITY != 0 {
+		binary.LittleEndian.PutUint32(signature[12:], seqNum)
+
+		hsh := hmac.New(md5.New, signKey)
+		hsh.Write(signature[12:])
+		hsh.Write(message)
+		copy(signature[4:12], hsh.Sum(nil))
+
+		if sc.flags&NEGOTIATE_EXPLICIT_KEY_EXCHANGE != 0 {
+			handle.XORKeyStream(signature[4:12], signature[4:12])
+		}
+		return signature
+	}
+
+	// RandomPad, Checksum and SeqNum are encrypted in order, then
+	// RandomPad is set to zero
+	binary.LittleEndian.PutUint32(signature[8:12], crc32.ChecksumIEEE(message))
+	handle.XORKeyStream(signature[4:16], signature[4:16])
+	binary.LittleEndian.PutUint32(signature[12:], binary.LittleEndian.Uint32(signature[12:])^seqNum)
+	copy(signature[4:8], []byte{0, 0, 0, 0})
+	return signature
+}
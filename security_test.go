@@ -0,0 +1,316 @@
+package ntlmssp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"sync"
+	"testing"
+)
+
+func TestSecurityContext_Seal(t *testing.T) {
+	plaintext := encodeUTF16LE([]byte("Plaintext"))
+	cases := []struct {
+		name       string
+		flags      uint32
+		sessionKey string
+		sealed     string
+		signature  string
+	}{
+		// MS-NLMP 4.2.2.4
+		{"NTLMv1", NEGOTIATE_SIGN | NEGOTIATE_SEAL | NEGOTIATE_128BIT_SESSION_KEY | NEGOTIATE_56BIT_ENCRYPTION,
+			"55555555555555555555555555555555", "56fe04d861f9319af0d7238a2e3b4d457fb8", "010000000000000009dcd1df2e459d36"},
+		// MS-NLMP 4.2.3.4
+		{"NTLM2 session", NEGOTIATE_SIGN | NEGOTIATE_SEAL | NEGOTIATE_EXTENDED_SESSION_SECURITY | NEGOTIATE_56BIT_ENCRYPTION,
+			"eb93429a8bd952f8b89c55b87f475edc", "a02372f6530273f3aa1eb90190ce5200c99d", "01000000ff2aeb52f681793a00000000"},
+		// MS-NLMP 4.2.4.4
+		{"NTLMv2", NEGOTIATE_SIGN | NEGOTIATE_SEAL | NEGOTIATE_EXTENDED_SESSION_SECURITY | NEGOTIATE_EXPLICIT_KEY_EXCHANGE | NEGOTIATE_128BIT_SESSION_KEY | NEGOTIATE_56BIT_ENCRYPTION,
+			"55555555555555555555555555555555", "54e50165bf1936dc996020c1811b0f06fb5f", "010000007fb38ec5c55d497600000000"},
+	}
+
+	for _, c := range cases {
+		sc := NewSecurityContext(c.flags, decodeHex(c.sessionKey), "Client")
+
+		sealed, signature, err := sc.Seal(plaintext)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if got := hex.EncodeToString(sealed); got != c.sealed {
+			t.Errorf("%s: sealed = %s, want %s", c.name, got, c.sealed)
+		}
+		if got := hex.EncodeToString(signature); got != c.signature {
+			t.Errorf("%s: signature = %s, want %s", c.name, got, c.signature)
+		}
+	}
+}
+
+func TestSecurityContext_Verify(t *testing.T) {
+	flags := uint32(NEGOTIATE_SIGN | NEGOTIATE_EXTENDED_SESSION_SECURITY | NEGOTIATE_EXPLICIT_KEY_EXCHANGE | NEGOTIATE_128BIT_SESSION_KEY)
+	key := decodeHex("55555555555555555555555555555555")
+	client := NewSecurityContext(flags, key, "Client")
+	server := NewSecurityContext(flags, key, "Server")
+
+	for i, msg := range []string{"first", "second", "third"} {
+		signature, err := client.Sign([]byte(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seq := bytes2Uint(signature[12:], '<'); seq != uint64(i) {
+			t.Errorf("SeqNum = %d, want %d", seq, i)
+		}
+		if err := server.Verify([]byte(msg), signature); err != nil {
+			t.Errorf("Verify(%q): %v", msg, err)
+		}
+	}
+
+	signature, _ := client.Sign([]byte("tampered"))
+	if err := server.Verify([]byte("Tampered"), signature); err == nil {
+		t.Error("Verify accepted a tampered message")
+	}
+}
+
+func TestSecurityContext_Sign(t *testing.T) {
+	flags := uint32(NEGOTIATE_SIGN | NEGOTIATE_EXTENDED_SESSION_SECURITY)
+	sc := NewSecurityContext(flags, decodeHex("55555555555555555555555555555555"), "Client")
+	signature, err := sc.Sign([]byte("Plaintext"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signature) != 16 || bytes2Uint(signature[:4], '<') != 1 {
+		t.Errorf("signature = %x", signature)
+	}
+}
+
+func TestSecurityContext_Unseal(t *testing.T) {
+	for _, flags := range []uint32{
+		NEGOTIATE_SEAL | NEGOTIATE_128BIT_SESSION_KEY,
+		NEGOTIATE_SEAL | NEGOTIATE_EXTENDED_SESSION_SECURITY | NEGOTIATE_EXPLICIT_KEY_EXCHANGE | NEGOTIATE_128BIT_SESSION_KEY,
+	} {
+		key := decodeHex("55555555555555555555555555555555")
+		client := NewSecurityContext(flags, key, "Client")
+		server := NewSecurityContext(flags, key, "Server")
+
+		for _, msg := range []string{"first", "second"} {
+			sealed, signature, err := client.Seal([]byte(msg))
+			if err != nil {
+				t.Fatal(err)
+			}
+			plain, err := server.Unseal(sealed, signature)
+			if err != nil {
+				t.Fatalf("Unseal(%q): %v", msg, err)
+			}
+			if string(plain) != msg {
+				t.Errorf("Unseal = %q, want %q", plain, msg)
+			}
+		}
+
+		sealed, signature, _ := client.Seal([]byte("tampered"))
+		sealed[0] ^= 1
+		if _, err := server.Unseal(sealed, signature); err == nil {
+			t.Errorf("flags %x: Unseal accepted a tampered message", flags)
+		}
+	}
+}
+
+func TestSecurityContext_RandomPad(t *testing.T) {
+	key := decodeHex("55555555555555555555555555555555")
+	flags := uint32(NEGOTIATE_SIGN | NEGOTIATE_SEAL | NEGOTIATE_128BIT_SESSION_KEY)
+	client := NewSecurityContext(flags, key, "Client")
+	server := NewSecurityContext(flags, key, "Server")
+
+	signature, _ := client.Sign([]byte("signed"))
+	if !bytes.Equal(signature[4:8], []byte{0, 0, 0, 0}) {
+		t.Errorf("RandomPad = %x, want 0", signature[4:8])
+	}
+	copy(signature[4:8], []byte{0xde, 0xad, 0xbe, 0xef})
+	if err := server.Verify([]byte("signed"), signature); err != nil {
+		t.Errorf("Verify with junk in RandomPad: %v", err)
+	}
+
+	sealed, signature, _ := client.Seal([]byte("sealed"))
+	copy(signature[4:8], []byte{0xde, 0xad, 0xbe, 0xef})
+	if plain, err := server.Unseal(sealed, signature); err != nil || string(plain) != "sealed" {
+		t.Errorf("Unseal with junk in RandomPad = %q, %v", plain, err)
+	}
+
+	// the checksum itself is still covered
+	signature, _ = client.Sign([]byte("signed"))
+	signature[8] ^= 1
+	if err := server.Verify([]byte("signed"), signature); err == nil {
+		t.Error("Verify accepted a tampered checksum")
+	}
+
+	// with extended session security the same bytes are the checksum
+	flags |= NEGOTIATE_EXTENDED_SESSION_SECURITY
+	client = NewSecurityContext(flags, key, "Client")
+	server = NewSecurityContext(flags, key, "Server")
+	signature, _ = client.Sign([]byte("signed"))
+	copy(signature[4:8], []byte{0xde, 0xad, 0xbe, 0xef})
+	if err := server.Verify([]byte("signed"), signature); err == nil {
+		t.Error("Verify accepted a tampered ESS checksum")
+	}
+}
+
+func TestSecurityContext_Datagram(t *testing.T) {
+	key := decodeHex("55555555555555555555555555555555")
+	flags := uint32(NEGOTIATE_SIGN | NEGOTIATE_SEAL | NEGOTIATE_EXTENDED_SESSION_SECURITY | NEGOTIATE_EXPLICIT_KEY_EXCHANGE | NEGOTIATE_128BIT_SESSION_KEY)
+	dgFlags := flags | NEGOTIATE_DATAGRAM_CONNECTIONLESS
+
+	conn := NewSecurityContext(flags, key, "Client")
+	client := NewSecurityContext(dgFlags, key, "Client")
+	server := NewSecurityContext(dgFlags, key, "Server")
+
+	if _, err := client.Sign([]byte("msg")); err == nil {
+		t.Error("Sign in datagram mode: expected error")
+	}
+	if _, err := conn.SignDatagram(0, []byte("msg")); err == nil {
+		t.Error("SignDatagram without NEGOTIATE_DATAGRAM_CONNECTIONLESS: expected error")
+	}
+
+	// the same layout, SeqNum is the explicit one, only the RC4 state differs
+	connSig, _ := conn.Sign([]byte("msg"))
+	dgSig, err := client.SignDatagram(7, []byte("msg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(connSig[:4], dgSig[:4]) || bytes2Uint(dgSig[12:], '<') != 7 || bytes2Uint(connSig[12:], '<') != 0 {
+		t.Errorf("signatures: connection %x, datagram %x", connSig, dgSig)
+	}
+
+	// the same sequence number gives the same signature, the handle is not kept
+	again, _ := client.SignDatagram(7, []byte("msg"))
+	if !bytes.Equal(dgSig, again) {
+		t.Errorf("SignDatagram is not stateless: %x, %x", dgSig, again)
+	}
+
+	// datagrams may arrive out of order
+	for _, seq := range []uint32{3, 1, 2} {
+		msg := []byte{byte(seq), 'x'}
+		signature, _ := client.SignDatagram(seq, msg)
+		if err := server.VerifyDatagram(seq, msg, signature); err != nil {
+			t.Errorf("VerifyDatagram(%d): %v", seq, err)
+		}
+		if err := server.VerifyDatagram(seq+1, msg, signature); err == nil {
+			t.Errorf("VerifyDatagram(%d) accepted the wrong sequence number", seq+1)
+		}
+
+		sealed, signature, err := client.SealDatagram(seq, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain, err := server.UnsealDatagram(seq, sealed, signature)
+		if err != nil || !bytes.Equal(plain, msg) {
+			t.Errorf("UnsealDatagram(%d) = %x, %v", seq, plain, err)
+		}
+	}
+}
+
+func TestSecurityContext_Continuity(t *testing.T) {
+	flags := uint32(NEGOTIATE_EXTENDED_SESSION_SECURITY | NEGOTIATE_128BIT_SESSION_KEY | NEGOTIATE_SIGN | NEGOTIATE_SEAL | NEGOTIATE_EXPLICIT_KEY_EXCHANGE)
+	key := decodeHex("55555555555555555555555555555555")
+	client := NewSecurityContext(flags, key, "Client")
+	server := NewSecurityContext(flags, key, "Server")
+
+	first, _ := client.Sign([]byte("message"))
+	if err := server.Verify([]byte("message"), first); err != nil {
+		t.Fatal(err)
+	}
+	// the keystream goes on across signed and sealed messages
+	for i := 0; i < 100000; i++ {
+		msg := []byte{byte(i), byte(i >> 8), byte(i >> 16)}
+		if i%2 == 0 {
+			signature, err := client.Sign(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := server.Verify(msg, signature); err != nil {
+				t.Fatalf("%d: Verify: %v", i, err)
+			}
+		} else {
+			sealed, signature, err := server.Seal(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if plain, err := client.Unseal(sealed, signature); err != nil || !bytes.Equal(plain, msg) {
+				t.Fatalf("%d: Unseal = %x, %v", i, plain, err)
+			}
+		}
+	}
+	if client.seqNum != 50001 || server.peerSeqNum != 50001 || server.seqNum != 50000 {
+		t.Errorf("seqNum = %d, peerSeqNum = %d", client.seqNum, server.peerSeqNum)
+	}
+
+	client.Reset()
+	if signature, _ := client.Sign([]byte("message")); !bytes.Equal(signature, first) {
+		t.Errorf("Sign after Reset = %x, want %x", signature, first)
+	}
+	server.Reset()
+	if err := server.Verify([]byte("message"), first); err != nil {
+		t.Errorf("Verify after Reset: %v", err)
+	}
+}
+
+func TestSecurityContext_Concurrent(t *testing.T) {
+	flags := uint32(NEGOTIATE_EXTENDED_SESSION_SECURITY | NEGOTIATE_128BIT_SESSION_KEY | NEGOTIATE_SIGN | NEGOTIATE_SEAL | NEGOTIATE_EXPLICIT_KEY_EXCHANGE)
+	sc := NewSecurityContext(flags, decodeHex("55555555555555555555555555555555"), "Server")
+
+	const goroutines, messages = 32, 200
+	signatures := make(chan []byte, goroutines*messages)
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < messages; i++ {
+				var signature []byte
+				var err error
+				if i%2 == 0 {
+					signature, err = sc.Sign([]byte("response"))
+				} else {
+					_, signature, err = sc.Seal([]byte("response"))
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				signatures <- signature
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(signatures)
+
+	seen := map[uint32]bool{}
+	for signature := range signatures {
+		seqNum := binary.LittleEndian.Uint32(signature[12:])
+		if seen[seqNum] {
+			t.Errorf("duplicate sequence number %d", seqNum)
+		}
+		seen[seqNum] = true
+	}
+	for i := uint32(0); i < goroutines*messages; i++ {
+		if !seen[i] {
+			t.Errorf("sequence number %d not used", i)
+		}
+	}
+}
+
+func TestSecurityContext_Wipe(t *testing.T) {
+	sessionKey := decodeHex("55555555555555555555555555555555")
+	sc := NewSecurityContext(NEGOTIATE_EXTENDED_SESSION_SECURITY|NEGOTIATE_SEAL|NEGOTIATE_128BIT_SESSION_KEY, sessionKey, "Client")
+	sc.Seal([]byte("Plaintext"))
+	sc.Wipe()
+
+	zero := make([]byte, 16)
+	for name, key := range map[string][]byte{
+		"sessionKey": sc.sessionKey, "signKey": sc.signKey, "verifyKey": sc.verifyKey,
+		"sealKey": sc.sealKey, "unsealKey": sc.unsealKey,
+	} {
+		if !bytes.Equal(key, zero) {
+			t.Errorf("%s = %x after Wipe", name, key)
+		}
+	}
+	if !bytes.Equal(sessionKey, zero) {
+		t.Errorf("session key passed in = %x after Wipe", sessionKey)
+	}
+}
@@ -0,0 +1,357 @@
+package ntlmssp
+
+import (
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const defaultServerFlags = NEGOTIATE_UNICODE_CHARSET | NEGOTIATE_OEM_CHARSET | NEGOTIATE_REQUEST_TARGET_NAME |
+	NEGOTIATE_SIGN | NEGOTIATE_SEAL | NEGOTIATE_NTLM | NEGOTIATE_ALWAYS_SIGN |
+	NEGOTIATE_EXTENDED_SESSION_SECURITY | NEGOTIATE_TARGET_INFO |
+	NEGOTIATE_128BIT_SESSION_KEY | NEGOTIATE_EXPLICIT_KEY_EXCHANGE | NEGOTIATE_56BIT_ENCRYPTION
+
+// Response types in increasing order of strength, see ResponseType
+type AuthLevel int
+
+const (
+	AuthLevelLMv1 AuthLevel = iota + 1
+	AuthLevelNTLMv1
+	AuthLevelNTLM2Session
+	AuthLevelNTLMv2
+)
+
+var authLevels = map[string]AuthLevel{
+	"LMv1":         AuthLevelLMv1,
+	"NTLMv1":       AuthLevelNTLMv1,
+	"NTLM2Session": AuthLevelNTLM2Session,
+	"NTLMv2":       AuthLevelNTLMv2,
+}
+
+// Server runs the acceptor side of the handshake: answer the client's
+// NEGOTIATE_MESSAGE with Challenge(), then check its AUTHENTICATE_MESSAGE
+// with Authenticate(). Only NTLMv2 responses are accepted by default.
+type Server struct {
+	// NetBIOS name of the server, the hostname by default
+	ComputerName string
+	// Accept anonymous AUTHENTICATE_MESSAGEs, the Session has an empty
+	// User and Domain and Anonymous set
+	AllowAnonymous bool
+	// Reject NTLMv2 responses whose timestamp differs from the
+	// MsvAvTimestamp of the challenge by more than this, 0 disables the
+	// check
+	MaxClockSkew time.Duration
+	// Clock for MsvAvTimestamp, time.Now if nil
+	Now func() time.Time
+	// Weakest response type accepted, AuthLevelNTLMv2 if 0. LMv1 responses
+	// are always rejected since only the NT hash is known.
+	MinAuthLevel AuthLevel
+	// Single_Host_Data of this machine. A client asking for
+	// NEGOTIATE_LOCAL_CALL gets it with a random context handle, and its
+	// Session has LocalCall set if it sends the same MachineID. The
+	// response is verified all the same.
+	SingleHost *SingleHostData
+	// Sent with NEGOTIATE_VERSION in the CHALLENGE_MESSAGE, no Version
+	// block if nil
+	Version *Version
+
+	user   string
+	domain string
+	ntHash []byte
+
+	// names advertised in the challenge, see SetIdentity
+	identity TargetInfo
+
+	negotiateMsg []byte
+	challengeMsg []byte
+	challenge    []byte
+	timestamp    time.Time
+	flags        uint32
+	sessionKey   []byte
+}
+
+// Established session of a successful Authenticate
+type Session struct {
+	User        string
+	Domain      string
+	Workstation string
+	Flags       uint32
+	SessionKey  []byte
+	Anonymous   bool
+	// The client runs on this machine, see Server.SingleHost
+	LocalCall bool
+
+	ctx *SecurityContext
+}
+
+func NewServer() *Server {
+	return &Server{ComputerName: localComputerName(), Now: time.Now}
+}
+
+func (s *Server) now() time.Time {
+	if s.Now == nil {
+		return time.Now()
+	}
+	return s.Now()
+}
+
+func (s *Server) minAuthLevel() AuthLevel {
+	if s.MinAuthLevel == 0 {
+		return AuthLevelNTLMv2
+	}
+	return s.MinAuthLevel
+}
+
+// The account accepted by Authenticate, ntHash is NtHash of the password
+func (s *Server) SetCredentials(user, domain string, ntHash []byte) {
+	s.user = user
+	s.domain = domain
+	s.ntHash = ntHash
+}
+
+// Names the challenge advertises in TargetName and the target info.
+// Empty names default to the domain of SetCredentials and ComputerName,
+// an empty dnsTree is left out. Clients may check dnsComputer against the
+// host of the SPN they authenticate to.
+func (s *Server) SetIdentity(netbiosDomain, netbiosComputer, dnsDomain, dnsComputer, dnsTree string) {
+	s.identity = TargetInfo{
+		NetBIOSDomainName:   netbiosDomain,
+		NetBIOSComputerName: netbiosComputer,
+		DNSDomainName:       dnsDomain,
+		DNSComputerName:     dnsComputer,
+		DNSTreeName:         dnsTree,
+	}
+}
+
+// CHALLENGE_MESSAGE for the client's NEGOTIATE_MESSAGE, with a random
+// server challenge
+func (s *Server) Challenge(type1 []byte) ([]byte, error) {
+	nm, err := NewNegotiateMsg(type1)
+	if err != nil {
+		return nil, err
+	}
+	if err := nm.Validate(); err != nil {
+		return nil, err
+	}
+
+	flags := Negotiated(nm.NegotiateFlags, defaultServerFlags)
+	if flags&NEGOTIATE_NTLM == 0 {
+		return nil, fmt.Errorf("%w: client did not negotiate NTLM", ErrFlagDowngrade)
+	}
+	if nm.NegotiateFlags&NEGOTIATE_LOCAL_CALL != 0 && s.SingleHost != nil {
+		flags |= NEGOTIATE_LOCAL_CALL
+	}
+
+	ti := s.identity
+	if ti.NetBIOSComputerName == "" {
+		ti.NetBIOSComputerName = s.ComputerName
+	}
+	if ti.DNSComputerName == "" {
+		ti.DNSComputerName = ti.NetBIOSComputerName
+	}
+	if ti.NetBIOSDomainName == "" {
+		ti.NetBIOSDomainName = s.domain
+	}
+
+	// the target is the domain, or the server itself for a local account
+	if ti.NetBIOSDomainName == "" {
+		ti.NetBIOSDomainName = ti.NetBIOSComputerName
+		flags |= NEGOTIATE_TARGET_TYPE_SERVER
+	} else {
+		flags |= NEGOTIATE_TARGET_TYPE_DOMAIN
+	}
+	if ti.DNSDomainName == "" {
+		ti.DNSDomainName = ti.NetBIOSDomainName
+	}
+	ti.Timestamp = s.now()
+
+	cm, _ := NewChallengeMsg(nil)
+	cm.NegotiateFlags = flags
+	cm.SetServerChallenge(nil)
+	if flags&NEGOTIATE_LOCAL_CALL != 0 {
+		io.ReadFull(Rand, cm.Reserved[:])
+	}
+	if s.Version != nil {
+		cm.SetVersion(*s.Version)
+	}
+	if flags&NEGOTIATE_REQUEST_TARGET_NAME != 0 {
+		cm.SetTargetName([]byte(ti.NetBIOSDomainName))
+	}
+	if err := cm.SetTargetInfoStruct(ti); err != nil {
+		return nil, err
+	}
+
+	s.negotiateMsg = type1
+	s.challengeMsg = cm.Bytes()
+	s.challenge = append([]byte{}, cm.ServerChallenge[:]...)
+	s.timestamp = ti.Timestamp
+	s.flags = flags
+	s.sessionKey = nil
+	return s.challengeMsg, nil
+}
+
+// Check the client's AUTHENTICATE_MESSAGE against the credentials. The
+// NTProofStr is compared in constant time and the MIC, if any, verified.
+// The challenge is used up whatever the outcome, a client that fails must
+// start over with Challenge.
+func (s *Server) Authenticate(type3 []byte) (*Session, error) {
+	if s.challengeMsg == nil {
+		return nil, fmt.Errorf("ntlmssp: Authenticate called before Challenge")
+	}
+	// no replay of a captured message or password guessing on one challenge
+	defer s.endChallenge()
+
+	am, err := NewAuthenticateMsg(type3)
+	if err != nil {
+		return nil, err
+	}
+	if err := am.Validate(); err != nil {
+		return nil, err
+	}
+
+	if am.IsAnonymous() {
+		if !s.AllowAnonymous {
+			return nil, fmt.Errorf("ntlmssp: anonymous authentication not allowed")
+		}
+		return s.newSession(am, make([]byte, 16), true)
+	}
+	if am.NegotiateFlags&NEGOTIATE_ANONYMOUS != 0 {
+		return nil, fmt.Errorf("%w: NEGOTIATE_ANONYMOUS set with credentials", ErrMalformedMessage)
+	}
+
+	if !strings.EqualFold(am.UserName(), s.user) {
+		return nil, fmt.Errorf("ntlmssp: unknown user %q", am.UserName())
+	}
+	if !s.knownDomain(am.DomainName()) {
+		return nil, fmt.Errorf("ntlmssp: unknown domain %q", am.DomainName())
+	}
+
+	level, ok := authLevels[am.ResponseType()]
+	if !ok {
+		return nil, fmt.Errorf("ntlmssp: unknown response type")
+	}
+	if level < s.minAuthLevel() {
+		return nil, ErrAuthLevel
+	}
+
+	resp := am.NtChallengeResponseBytes()
+	var keyExchangeKey []byte
+	switch level {
+	case AuthLevelNTLMv2:
+		// NTProofStr(16) | 0x0101 Z(6) | Timestamp(8) | ClientChallenge(8) | Z(4) | TargetInfo | Z(4)
+		ntlmv2Hash := ntowfv2(s.ntHash, am.UserName(), am.DomainName())
+		if !VerifyNTLMv2Response(ntlmv2Hash, s.challenge, resp) {
+			return nil, fmt.Errorf("%w for %q", ErrNTProofMismatch, am.UserName())
+		}
+		// NTLMv2 KXKEY is the session base key
+		keyExchangeKey = hmacMd5(ntlmv2Hash, resp[:16])
+
+		if s.MaxClockSkew > 0 {
+			skew := TimeFromWindowsTimestamp(resp[24:32]).Sub(s.timestamp)
+			if skew > s.MaxClockSkew || skew < -s.MaxClockSkew {
+				return nil, fmt.Errorf("%w: response timestamp off by %v", ErrClockSkew, skew)
+			}
+		}
+	case AuthLevelNTLMv1, AuthLevelNTLM2Session:
+		lmresp := am.LmChallengeResponse()
+		expected := ComputeNTv1Response(s.ntHash, s.challenge)
+		if level == AuthLevelNTLM2Session {
+			_, expected = ComputeNTLM2SessionResponse(s.ntHash, s.challenge, lmresp)
+		}
+		if !hmac.Equal(expected, resp) {
+			return nil, fmt.Errorf("%w for %q", ErrNTProofMismatch, am.UserName())
+		}
+		keyExchangeKey = KXKey(am.NegotiateFlags&s.flags, md4Hash(s.ntHash), lmresp, s.challenge, nil)
+	default:
+		return nil, fmt.Errorf("ntlmssp: cannot verify an LMv1 response")
+	}
+
+	return s.newSession(am, keyExchangeKey, false)
+}
+
+func (s *Server) endChallenge() {
+	s.negotiateMsg = nil
+	s.challengeMsg = nil
+	s.challenge = nil
+}
+
+// The domain of SetCredentials. For a local account, an empty domain or
+// the ComputerName the challenge names as target.
+func (s *Server) knownDomain(domain string) bool {
+	if s.domain == "" {
+		return domain == "" || strings.EqualFold(domain, s.ComputerName)
+	}
+	return strings.EqualFold(domain, s.domain)
+}
+
+func (s *Server) newSession(am *AuthenticateMsg, keyExchangeKey []byte, anonymous bool) (*Session, error) {
+	flags := am.NegotiateFlags & s.flags
+	sessionKey := keyExchangeKey
+	if flags&NEGOTIATE_EXPLICIT_KEY_EXCHANGE != 0 {
+		if len(am.EncryptedRandomSessionKey()) != 16 {
+			return nil, fmt.Errorf("ntlmssp: missing encrypted random session key")
+		}
+		sessionKey = DecryptSessionKey(keyExchangeKey, am.EncryptedRandomSessionKey())
+	}
+
+	ok, err := VerifyMIC(am, sessionKey, s.negotiateMsg, s.challengeMsg)
+	if errors.Is(err, ErrMICMissing) || errors.Is(err, ErrMalformedMessage) {
+		return nil, err
+	} else if err == nil && !ok {
+		return nil, ErrMICMismatch
+	}
+
+	s.sessionKey = sessionKey
+	session := &Session{
+		Workstation: am.Workstation(),
+		Flags:       flags,
+		SessionKey:  append([]byte{}, sessionKey...),
+		Anonymous:   anonymous,
+		LocalCall:   flags&NEGOTIATE_LOCAL_CALL != 0 && s.sameHost(am),
+	}
+	if !anonymous {
+		session.User = am.UserName()
+		session.Domain = am.DomainName()
+	}
+	return session, nil
+}
+
+// The MsvAvSingleHost of the NTLMv2 response has the MachineID of
+// s.SingleHost
+func (s *Server) sameHost(am *AuthenticateMsg) bool {
+	if s.SingleHost == nil || !am.IsNTLMv2() {
+		return false
+	}
+	_, blob, err := ParseNTLMv2ResponseBlob(am.NtChallengeResponseBytes())
+	if err != nil {
+		return false
+	}
+	sh, err := ParseSingleHostData(blob.TargetInfo.Get(MsAvRestrictions))
+	return err == nil && sh.MachineID == s.SingleHost.MachineID
+}
+
+// ExportedSessionKey of the last successful Authenticate, the same as the
+// client's Client.SessionKey and the input of application key derivation
+// such as the SMB 3 KDF. nil before.
+func (s *Server) SessionKey() []byte {
+	return s.sessionKey
+}
+
+// Overwrite the NT hash of SetCredentials and the last session key with
+// zeros, e.g. with defer once the server is done. Sessions keep their own
+// copy of the key, wipe their SecurityContext with SecurityContext.Wipe.
+func (s *Server) Wipe() {
+	zero(s.ntHash)
+	zero(s.sessionKey)
+}
+
+// Signing and sealing context of the session
+func (s *Session) SecurityContext() *SecurityContext {
+	if s.ctx == nil {
+		s.ctx = NewSecurityContext(s.Flags, s.SessionKey, "Server")
+	}
+	return s.ctx
+}
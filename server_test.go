@@ -0,0 +1,611 @@
+package ntlmssp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func handshake(client *Client, server *Server) (*Session, error) {
+	type2, err := server.Challenge(client.Negotiate())
+	if err != nil {
+		return nil, err
+	}
+	type3, err := client.ProcessChallenge(type2)
+	if err != nil {
+		return nil, err
+	}
+	return server.Authenticate(type3)
+}
+
+func TestServer(t *testing.T) {
+	server := NewServer()
+	server.SetCredentials("User", "Domain", NtHash([]byte("Password")))
+
+	client := NewClient(Credentials{User: "user", Domain: "Domain", Password: "Password"})
+	client.Workstation = "WS"
+	session, err := handshake(client, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.User != "user" || session.Domain != "Domain" || session.Workstation != "WS" {
+		t.Errorf("session = %+v", session)
+	}
+	if string(session.SessionKey) != string(client.SessionKey()) {
+		t.Errorf("SessionKey = %x, want %x", session.SessionKey, client.SessionKey())
+	}
+
+	for i, msg := range []string{"request", "another request"} {
+		sealed, signature, err := client.SecurityContext().Seal([]byte(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain, err := session.SecurityContext().Unseal(sealed, signature)
+		if err != nil || string(plain) != msg {
+			t.Errorf("%d: Unseal = %q, %v", i, plain, err)
+		}
+
+		signature, _ = session.SecurityContext().Sign([]byte(msg))
+		if err := client.SecurityContext().Verify([]byte(msg), signature); err != nil {
+			t.Errorf("%d: Verify: %v", i, err)
+		}
+	}
+}
+
+func TestServer_WrongPassword(t *testing.T) {
+	server := NewServer()
+	server.SetCredentials("User", "Domain", NtHash([]byte("Password")))
+
+	if _, err := handshake(NewClient(Credentials{User: "User", Domain: "Domain", Password: "wrong"}), server); err == nil {
+		t.Error("Authenticate accepted a wrong password")
+	}
+	if _, err := handshake(NewClient(Credentials{User: "Other", Domain: "Domain", Password: "Password"}), server); err == nil {
+		t.Error("Authenticate accepted an unknown user")
+	}
+	for _, domain := range []string{"Other", ""} {
+		if _, err := handshake(NewClient(Credentials{User: "User", Domain: domain, Password: "Password"}), server); err == nil {
+			t.Errorf("Authenticate accepted domain %q", domain)
+		}
+	}
+	if _, err := handshake(NewClient(Credentials{User: "User", Domain: "DOMAIN", Password: "Password"}), server); err != nil {
+		t.Errorf("domain is case-insensitive: %v", err)
+	}
+}
+
+func TestServer_LocalAccount(t *testing.T) {
+	server := NewServer()
+	server.ComputerName = "SERVER"
+	server.SetCredentials("User", "", NtHash([]byte("Password")))
+
+	for _, domain := range []string{"", "server"} {
+		session, err := handshake(NewClient(Credentials{User: "User", Domain: domain, Password: "Password"}), server)
+		if err != nil {
+			t.Errorf("domain %q: %v", domain, err)
+		} else if session.Domain != domain {
+			t.Errorf("domain %q: session.Domain = %q", domain, session.Domain)
+		}
+	}
+	if _, err := handshake(NewClient(Credentials{User: "User", Domain: "Other", Password: "Password"}), server); err == nil {
+		t.Error("local account accepted a domain")
+	}
+}
+
+func TestServer_Anonymous(t *testing.T) {
+	server := NewServer()
+	server.SetCredentials("User", "Domain", NtHash([]byte("Password")))
+
+	if _, err := handshake(NewClient(Credentials{}), server); err == nil {
+		t.Error("anonymous accepted without AllowAnonymous")
+	}
+	server.AllowAnonymous = true
+
+	client := NewClient(Credentials{})
+	type2, err := server.Challenge(client.Negotiate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	type3, err := client.ProcessChallenge(type2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	am, _ := NewAuthenticateMsg(type3)
+	if am.NegotiateFlags&NEGOTIATE_ANONYMOUS == 0 || !am.IsAnonymous() {
+		t.Error("NEGOTIATE_ANONYMOUS not set")
+	}
+	if lm := am.LmChallengeResponse(); len(lm) != 1 || lm[0] != 0 || am.NtChallengeResponseLen != 0 {
+		t.Errorf("LmChallengeResponse = %x, NtChallengeResponseLen = %d", lm, am.NtChallengeResponseLen)
+	}
+
+	session, err := server.Authenticate(type3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !session.Anonymous || session.User != "" {
+		t.Errorf("session = %+v", session)
+	}
+	if string(session.SessionKey) != string(client.SessionKey()) {
+		t.Errorf("SessionKey = %x, want %x", session.SessionKey, client.SessionKey())
+	}
+
+	// NEGOTIATE_ANONYMOUS with a user name or responses is not anonymous
+	for _, c := range []struct {
+		name   string
+		user   string
+		ntresp []byte
+	}{
+		{"user", "Administrator", nil},
+		{"NT response", "", make([]byte, 30)},
+	} {
+		if _, err := server.Challenge(client.Negotiate()); err != nil {
+			t.Fatal(err)
+		}
+		forged, _ := NewAuthenticateMsg(nil)
+		forged.NegotiateFlags = NEGOTIATE_UNICODE_CHARSET | NEGOTIATE_NTLM | NEGOTIATE_ANONYMOUS
+		forged.ReserveMIC()
+		forged.SetLmChallengeResponse([]byte{0})
+		forged.SetNtChallengeResponse(c.ntresp)
+		forged.SetUserName([]byte(c.user))
+		if session, err := server.Authenticate(forged.Bytes()); err == nil {
+			t.Errorf("%s: forged anonymous message accepted, session = %+v", c.name, session)
+		}
+	}
+}
+
+func TestServer_Timestamp(t *testing.T) {
+	server := NewServer()
+	server.SetCredentials("User", "Domain", NtHash([]byte("Password")))
+	client := NewClient(Credentials{User: "User", Domain: "Domain", Password: "Password"})
+	client.Workstation = "WS"
+
+	type2, err := server.Challenge(client.Negotiate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm, _ := NewChallengeMsg(type2)
+	serverTime, ok := ReadAvPairs(cm.TargetInfo()).Timestamp()
+	if !ok || time.Since(serverTime) > time.Minute {
+		t.Fatalf("MsvAvTimestamp = %v, %v", serverTime, ok)
+	}
+
+	type3, err := client.ProcessChallenge(type2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	am, _ := NewAuthenticateMsg(type3)
+	if resp := am.NtChallengeResponseBytes(); !TimeFromWindowsTimestamp(resp[24:32]).Equal(serverTime) {
+		t.Errorf("client timestamp = %v, want %v", TimeFromWindowsTimestamp(resp[24:32]), serverTime)
+	}
+	if am.MIC() == nil {
+		t.Fatal("no MIC with MsvAvTimestamp")
+	}
+
+	// the MIC covers the whole message
+	tampered := append([]byte{}, type3...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := server.Authenticate(tampered); !errors.Is(err, ErrMICMismatch) {
+		t.Errorf("tampered message: err = %v, want ErrMICMismatch", err)
+	}
+}
+
+func TestServer_Replay(t *testing.T) {
+	server := NewServer()
+	server.SetCredentials("User", "Domain", NtHash([]byte("Password")))
+	client := NewClient(Credentials{User: "User", Domain: "Domain", Password: "Password"})
+	type2, err := server.Challenge(client.Negotiate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	type3, err := client.ProcessChallenge(type2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := server.Authenticate(type3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Authenticate(type3); err == nil {
+		t.Error("Authenticate accepted a replayed message")
+	}
+
+	// a failed attempt uses up the challenge too
+	wrong := NewClient(Credentials{User: "User", Domain: "Domain", Password: "wrong"})
+	type2, err = server.Challenge(wrong.Negotiate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Authenticate(mustProcess(t, wrong, type2)); err == nil {
+		t.Fatal("Authenticate accepted a wrong password")
+	}
+	client.Negotiate()
+	if _, err := server.Authenticate(mustProcess(t, client, type2)); err == nil {
+		t.Error("Authenticate accepted a message after a failed attempt")
+	}
+}
+
+func mustProcess(t *testing.T, client *Client, type2 []byte) []byte {
+	type3, err := client.ProcessChallenge(type2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return type3
+}
+
+func TestServer_MaxClockSkew(t *testing.T) {
+	server := NewServer()
+	server.SetCredentials("User", "Domain", NtHash([]byte("Password")))
+	server.MaxClockSkew = 5 * time.Minute
+	serverTime := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	server.Now = func() time.Time { return serverTime }
+
+	client := NewClient(Credentials{User: "User", Domain: "Domain", Password: "Password"})
+	for _, c := range []struct {
+		skew time.Duration
+		ok   bool
+	}{
+		{0, true},
+		{5 * time.Minute, true},
+		{-5 * time.Minute, true},
+		{5*time.Minute + time.Microsecond, false},
+		{-5*time.Minute - time.Microsecond, false},
+	} {
+		type2, err := server.Challenge(client.Negotiate())
+		if err != nil {
+			t.Fatal(err)
+		}
+		cm, _ := NewChallengeMsg(type2)
+		resp, _ := ComputeNTLMv2Response(NTOWFv2("Password", "User", "Domain"), cm.ServerChallenge[:],
+			decodeHex("aaaaaaaaaaaaaaaa"), WindowsTimestamp(serverTime.Add(c.skew)), cm.TargetInfo())
+		am, _ := NewAuthenticateMsg(nil)
+		am.NegotiateFlags = NEGOTIATE_UNICODE_CHARSET | NEGOTIATE_NTLM
+		am.SetLmChallengeResponse(make([]byte, 24))
+		am.SetNtChallengeResponse(resp)
+		am.SetDomainName([]byte("Domain"))
+		am.SetUserName([]byte("User"))
+
+		if _, err := server.Authenticate(am.Marshal('<')); (err == nil) != c.ok {
+			t.Errorf("skew %v: err = %v, want ok = %v", c.skew, err, c.ok)
+		}
+	}
+
+	// the skew is measured against the challenge, not the clock at
+	// Authenticate: an answer an hour later with the then current time fails
+	for _, c := range []struct {
+		timestamp time.Time
+		ok        bool
+	}{
+		{serverTime, true},
+		{serverTime.Add(time.Hour), false},
+	} {
+		server.Now = func() time.Time { return serverTime }
+		type2, err := server.Challenge(client.Negotiate())
+		if err != nil {
+			t.Fatal(err)
+		}
+		server.Now = func() time.Time { return serverTime.Add(time.Hour) }
+		cm, _ := NewChallengeMsg(type2)
+		resp, _ := ComputeNTLMv2Response(NTOWFv2("Password", "User", "Domain"), cm.ServerChallenge[:],
+			decodeHex("aaaaaaaaaaaaaaaa"), WindowsTimestamp(c.timestamp), cm.TargetInfo())
+		am, _ := NewAuthenticateMsg(nil)
+		am.NegotiateFlags = NEGOTIATE_UNICODE_CHARSET | NEGOTIATE_NTLM
+		am.SetLmChallengeResponse(make([]byte, 24))
+		am.SetNtChallengeResponse(resp)
+		am.SetDomainName([]byte("Domain"))
+		am.SetUserName([]byte("User"))
+
+		if _, err := server.Authenticate(am.Bytes()); (err == nil) != c.ok {
+			t.Errorf("response at %v: err = %v, want ok = %v", c.timestamp, err, c.ok)
+		}
+	}
+}
+
+func TestServer_Rand(t *testing.T) {
+	defer func(r io.Reader) { Rand = r }(Rand)
+	Rand = bytes.NewReader(decodeHex("0123456789abcdef"))
+
+	client := NewClient(Credentials{User: "User", Domain: "Domain", Password: "Password"})
+	type2, err := NewServer().Challenge(client.Negotiate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm, _ := NewChallengeMsg(type2)
+	if got := hex.EncodeToString(cm.ServerChallenge[:]); got != "0123456789abcdef" {
+		t.Errorf("ServerChallenge = %s, want 0123456789abcdef", got)
+	}
+}
+
+func TestServer_Now(t *testing.T) {
+	server := NewServer()
+	// 2000-01-01 00:00:00 UTC is FILETIME 0x01bf53eb256d4000
+	server.Now = func() time.Time { return time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC) }
+
+	client := NewClient(Credentials{User: "User", Domain: "Domain", Password: "Password"})
+	type2, err := server.Challenge(client.Negotiate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm, _ := NewChallengeMsg(type2)
+	if got := hex.EncodeToString(ReadAvPairs(cm.TargetInfo()).Get(MsvAvTimestamp)); got != "00406d25eb53bf01" {
+		t.Errorf("MsvAvTimestamp = %s, want 00406d25eb53bf01", got)
+	}
+}
+
+func TestServer_MinAuthLevel(t *testing.T) {
+	ntHash := NtHash([]byte("Password"))
+	clientChallenge := decodeHex("aaaaaaaaaaaaaaaa")
+
+	for _, c := range []struct {
+		name     string
+		level    AuthLevel
+		ntlm2    bool
+		password string
+		ok       bool
+	}{
+		{"NTLMv1 by default", 0, false, "Password", false},
+		{"NTLM2 session by default", 0, true, "Password", false},
+		{"NTLMv1 with NTLMv2", AuthLevelNTLMv2, false, "Password", false},
+		{"NTLMv1 with NTLM2 session", AuthLevelNTLM2Session, false, "Password", false},
+		{"NTLM2 session with NTLM2 session", AuthLevelNTLM2Session, true, "Password", true},
+		{"NTLMv1 with NTLMv1", AuthLevelNTLMv1, false, "Password", true},
+		{"NTLM2 session with NTLMv1", AuthLevelNTLMv1, true, "Password", true},
+		{"NTLMv1 with LMv1", AuthLevelLMv1, false, "Password", true},
+		{"NTLMv1 wrong password", AuthLevelNTLMv1, false, "wrong", false},
+	} {
+		server := NewServer()
+		server.SetCredentials("User", "Domain", ntHash)
+		server.MinAuthLevel = c.level
+		client := NewClient(Credentials{User: "User", Domain: "Domain", Password: c.password})
+		type2, err := server.Challenge(client.Negotiate())
+		if err != nil {
+			t.Fatal(err)
+		}
+		cm, _ := NewChallengeMsg(type2)
+
+		am, _ := NewAuthenticateMsg(nil)
+		am.NegotiateFlags = NEGOTIATE_UNICODE_CHARSET | NEGOTIATE_NTLM
+		lm, nt := ComputeLMv1Response(LMHash(c.password), cm.ServerChallenge[:]), ComputeNTv1Response(NTHash(c.password), cm.ServerChallenge[:])
+		if c.ntlm2 {
+			am.NegotiateFlags |= NEGOTIATE_EXTENDED_SESSION_SECURITY
+			lm, nt = ComputeNTLM2SessionResponse(NTHash(c.password), cm.ServerChallenge[:], clientChallenge)
+		}
+		am.SetLmChallengeResponse(lm)
+		am.SetNtChallengeResponse(nt)
+		am.SetDomainName([]byte("Domain"))
+		am.SetUserName([]byte("User"))
+
+		session, err := server.Authenticate(am.Marshal('<'))
+		if (err == nil) != c.ok {
+			t.Errorf("%s: err = %v, want ok = %v", c.name, err, c.ok)
+			continue
+		}
+		if !c.ok && c.password == "Password" && err != ErrAuthLevel {
+			t.Errorf("%s: err = %v, want ErrAuthLevel", c.name, err)
+		}
+		if c.ok {
+			want := KXKey(am.NegotiateFlags, md4Hash(ntHash), lm, cm.ServerChallenge[:], nil)
+			if !bytes.Equal(session.SessionKey, want) {
+				t.Errorf("%s: SessionKey = %x, want %x", c.name, session.SessionKey, want)
+			}
+		}
+	}
+}
+
+func TestServer_TargetType(t *testing.T) {
+	cases := []struct {
+		domain string
+		flag   uint32
+		want   string
+	}{
+		{"Domain", NEGOTIATE_TARGET_TYPE_DOMAIN, "Domain"},
+		{"", NEGOTIATE_TARGET_TYPE_SERVER, "Server"},
+	}
+	for _, c := range cases {
+		server := NewServer()
+		server.ComputerName = "SERVER"
+		server.SetCredentials("User", c.domain, NtHash([]byte("Password")))
+
+		client := NewClient(Credentials{User: "User", Domain: c.domain, Password: "Password"})
+		type2, err := server.Challenge(client.Negotiate())
+		if err != nil {
+			t.Fatal(err)
+		}
+		cm, _ := NewChallengeMsg(type2)
+		if cm.NegotiateFlags&(NEGOTIATE_TARGET_TYPE_DOMAIN|NEGOTIATE_TARGET_TYPE_SERVER) != c.flag {
+			t.Errorf("%q: flags = %s", c.domain, NegotiateFlagsString(cm.NegotiateFlags))
+		}
+		if got := cm.TargetType(); got != c.want {
+			t.Errorf("%q: TargetType = %q, want %q", c.domain, got, c.want)
+		}
+	}
+
+	cm, _ := NewChallengeMsg(nil)
+	cm.NegotiateFlags = NEGOTIATE_TARGET_TYPE_SHARE
+	if got := cm.TargetType(); got != "Share" {
+		t.Errorf("TargetType = %q, want Share", got)
+	}
+	cm.NegotiateFlags = 0
+	if got := cm.TargetType(); got != "" {
+		t.Errorf("TargetType = %q, want empty", got)
+	}
+}
+
+func TestServer_LocalCall(t *testing.T) {
+	host := SingleHostData{CustomData: [8]byte{1}, MachineID: [32]byte{0xaa, 0xbb, 0xcc}}
+	other := host
+	other.MachineID[0] = 0xff
+
+	cases := []struct {
+		name      string
+		server    *SingleHostData
+		client    *SingleHostData
+		flag      bool
+		localCall bool
+	}{
+		{"same host", &host, &host, true, true},
+		{"other host", &host, &other, true, false},
+		{"not requested", &host, nil, false, false},
+		{"server without SingleHost", nil, &host, false, false},
+	}
+	for _, c := range cases {
+		server := NewServer()
+		server.SingleHost = c.server
+		server.SetCredentials("User", "Domain", NtHash([]byte("Password")))
+		client := NewClient(Credentials{User: "User", Domain: "Domain", Password: "Password"})
+		client.SingleHost = c.client
+
+		type2, err := server.Challenge(client.Negotiate())
+		if err != nil {
+			t.Fatal(err)
+		}
+		cm, _ := NewChallengeMsg(type2)
+		if got := cm.NegotiateFlags&NEGOTIATE_LOCAL_CALL != 0; got != c.flag {
+			t.Errorf("%s: NEGOTIATE_LOCAL_CALL = %v, want %v", c.name, got, c.flag)
+		}
+
+		type3, err := client.ProcessChallenge(type2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.flag {
+			if cm.Reserved == [8]byte{} || !bytes.Equal(client.LocalContext(), cm.Reserved[:]) {
+				t.Errorf("%s: LocalContext = %x, challenge context %x", c.name, client.LocalContext(), cm.Reserved)
+			}
+		} else if client.LocalContext() != nil || cm.Reserved != [8]byte{} {
+			t.Errorf("%s: LocalContext = %x, challenge context %x", c.name, client.LocalContext(), cm.Reserved)
+		}
+
+		session, err := server.Authenticate(type3)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if session.LocalCall != c.localCall {
+			t.Errorf("%s: LocalCall = %v, want %v", c.name, session.LocalCall, c.localCall)
+		}
+	}
+}
+
+func TestServer_SessionKey(t *testing.T) {
+	cred := Credentials{User: "User", Domain: "Domain", Password: "Password"}
+	for _, keyExchange := range []bool{true, false} {
+		client := NewClient(cred)
+		nm, _ := NewNegotiateMsg(client.Negotiate())
+		if !keyExchange {
+			nm.NegotiateFlags &^= NEGOTIATE_EXPLICIT_KEY_EXCHANGE
+		}
+		type1 := nm.Bytes()
+
+		server := NewServer()
+		server.SetCredentials("User", "Domain", NtHash([]byte("Password")))
+		if server.SessionKey() != nil {
+			t.Error("SessionKey before Authenticate")
+		}
+		type2, err := server.Challenge(type1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		type3, clientKey, err := BuildType3(type2, cred, "WS", NegotiateMessage(type1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		am, _ := NewAuthenticateMsg(type3)
+		if got := am.EncryptedRandomSessionKeyLen != 0; got != keyExchange {
+			t.Errorf("key exchange %v: EncryptedRandomSessionKey sent = %v", keyExchange, got)
+		}
+
+		session, err := server.Authenticate(type3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(clientKey) != 16 || !bytes.Equal(server.SessionKey(), clientKey) || !bytes.Equal(session.SessionKey, clientKey) {
+			t.Errorf("key exchange %v: client %x, server %x, session %x", keyExchange, clientKey, server.SessionKey(), session.SessionKey)
+		}
+	}
+}
+
+func TestServer_SetIdentity(t *testing.T) {
+	server := NewServer()
+	server.ComputerName = "HOST"
+	server.SetCredentials("User", "Domain", NtHash([]byte("Password")))
+	server.SetIdentity("CORP", "WEB01", "corp.example.com", "web01.corp.example.com", "example.com")
+
+	client := NewClient(Credentials{User: "User", Domain: "Domain", Password: "Password"})
+	type2, err := server.Challenge(client.Negotiate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm, _ := NewChallengeMsg(type2)
+	if cm.TargetName() != "CORP" || cm.TargetType() != "Domain" {
+		t.Errorf("TargetName = %q, TargetType = %q", cm.TargetName(), cm.TargetType())
+	}
+	ti, err := ParseTargetInfo(cm.TargetInfo())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ti.NetBIOSDomainName != "CORP" || ti.NetBIOSComputerName != "WEB01" || ti.DNSDomainName != "corp.example.com" ||
+		ti.DNSComputerName != "web01.corp.example.com" || ti.DNSTreeName != "example.com" || ti.Timestamp.IsZero() {
+		t.Errorf("target info = %+v", ti)
+	}
+	type3, err := client.ProcessChallenge(type2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Authenticate(type3); err != nil {
+		t.Error(err)
+	}
+
+	// defaults without SetIdentity
+	server = NewServer()
+	server.ComputerName = "HOST"
+	server.SetCredentials("User", "", NtHash([]byte("Password")))
+	client = NewClient(Credentials{User: "User", Password: "Password"})
+	if type2, err = server.Challenge(client.Negotiate()); err != nil {
+		t.Fatal(err)
+	}
+	cm, _ = NewChallengeMsg(type2)
+	ti, _ = ParseTargetInfo(cm.TargetInfo())
+	if cm.TargetName() != "HOST" || ti.NetBIOSDomainName != "HOST" || ti.NetBIOSComputerName != "HOST" ||
+		ti.DNSDomainName != "HOST" || ti.DNSComputerName != "HOST" || ti.DNSTreeName != "" {
+		t.Errorf("TargetName = %q, target info = %+v", cm.TargetName(), ti)
+	}
+}
+
+func TestServer_Wipe(t *testing.T) {
+	ntHash := NTHash("Password")
+	server := NewServer()
+	server.SetCredentials("User", "Domain", ntHash)
+	client := NewClient(Credentials{User: "User", Domain: "Domain", Password: "Password"})
+	session, err := handshake(client, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCtx := client.SecurityContext()
+	serverKey, clientKey := server.SessionKey(), client.SessionKey()
+	sessionKey := append([]byte{}, session.SessionKey...)
+
+	server.Wipe()
+	// the session outlives the server
+	if !bytes.Equal(session.SessionKey, sessionKey) {
+		t.Errorf("Session.SessionKey = %x after Server.Wipe, want %x", session.SessionKey, sessionKey)
+	}
+	sealed, signature, err := clientCtx.Seal([]byte("request"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain, err := session.SecurityContext().Unseal(sealed, signature); err != nil || string(plain) != "request" {
+		t.Errorf("Unseal after Server.Wipe = %q, %v", plain, err)
+	}
+
+	client.Wipe()
+	zero := make([]byte, 16)
+	for name, key := range map[string][]byte{
+		"NT hash": ntHash, "server session key": serverKey,
+		"client session key": clientKey, "client sign key": clientCtx.signKey, "client seal key": clientCtx.sealKey,
+	} {
+		if !bytes.Equal(key, zero) {
+			t.Errorf("%s = %x after Wipe", name, key)
+		}
+	}
+}
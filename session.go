@@ -0,0 +1,145 @@
+package ntlmssp
+
+import (
+	"crypto/rc4"
+	"io"
+)
+
+const (
+	clientSigningMagic = "session key to client-to-server signing key magic constant\x00"
+	serverSigningMagic = "session key to server-to-client signing key magic constant\x00"
+	clientSealingMagic = "session key to client-to-server sealing key magic constant\x00"
+	serverSealingMagic = "session key to server-to-client sealing key magic constant\x00"
+)
+
+// MS-NLMP 3.4.5.2 SIGNKEY, mode is "Client" or "Server". Without
+// NEGOTIATE_EXTENDED_SESSION_SECURITY there is no signing key and nil is
+// returned.
+func SignKey(flags uint32, sessionKey []byte, mode string) []byte {
+	if flags&NEGOTIATE_EXTENDED_SESSION_SECURITY == 0 {
+		return nil
+	}
+
+	magic := serverSigningMagic
+	if mode == "Client" {
+		magic = clientSigningMagic
+	}
+	return md5Hash(append(append([]byte{}, sessionKey...), magic...))
+}
+
+// MS-NLMP 3.4.5.1 KXKEY, the key that encrypts the ExportedSessionKey.
+// With NEGOTIATE_EXTENDED_SESSION_SECURITY an NTLMv1 handshake is told from
+// NTLMv2 by its LmChallengeResponse, ClientChallenge followed by Z(16).
+// lmHash is only used for NEGOTIATE_LM_SESSION_KEY and
+// NEGOTIATE_REQUEST_NON_NT_SESSION_KEY.
+func KXKey(flags uint32, sessionBaseKey, lmChallengeResponse, serverChallenge, lmHash []byte) []byte {
+	if flags&NEGOTIATE_EXTENDED_SESSION_SECURITY != 0 {
+		if !isNTLM2SessionLmResponse(lmChallengeResponse) {
+			return append([]byte{}, sessionBaseKey...)
+		}
+		return hmacMd5(sessionBaseKey, append(append([]byte{}, serverChallenge...), lmChallengeResponse[:8]...))
+	}
+
+	if flags&NEGOTIATE_LM_SESSION_KEY != 0 && len(lmHash) == 16 && len(lmChallengeResponse) >= 8 {
+		key := append([]byte{}, lmHash[7])
+		key = append(key, 0xbd, 0xbd, 0xbd, 0xbd, 0xbd, 0xbd)
+		return append(desEnc(expandDESKey(lmHash[:7]), lmChallengeResponse[:8]), desEnc(expandDESKey(key), lmChallengeResponse[:8])...)
+	}
+	if flags&NEGOTIATE_REQUEST_NON_NT_SESSION_KEY != 0 && len(lmHash) == 16 {
+		return LMSessionBaseKey(lmHash)
+	}
+	return append([]byte{}, sessionBaseKey...)
+}
+
+// Session key of LM-only authentication, the first 8 bytes of LMOWFv1
+// followed by Z(8), as sent with NEGOTIATE_REQUEST_NON_NT_SESSION_KEY.
+// With NEGOTIATE_LM_SESSION_KEY the key is DES-derived from the LM
+// response instead, see KXKey. nil unless lmHash is 16 bytes.
+func LMSessionBaseKey(lmHash []byte) []byte {
+	if len(lmHash) != 16 {
+		return nil
+	}
+	return append(append([]byte{}, lmHash[:8]...), make([]byte, 8)...)
+}
+
+func isNTLM2SessionLmResponse(lm []byte) bool {
+	if len(lm) != 24 {
+		return false
+	}
+	for _, b := range lm[8:] {
+		if b != 0 {
+			return false
+		}
+	}
+	for _, b := range lm[:8] {
+		if b != 0 {
+			return true
+		}
+	}
+	// Z(24) is the LmChallengeResponse of NTLMv2 with a MIC
+	return false
+}
+
+// Bytes of the session key used for sealing: 16 with NEGOTIATE_128, 7
+// with NEGOTIATE_56, else 5 (40-bit). NEGOTIATE_128 has no effect on the
+// LM session key, which is 7 or 5 bytes, and NTLMv1 without either seals
+// with all 16 bytes.
+func KeyStrength(flags uint32) int {
+	ess := flags&NEGOTIATE_EXTENDED_SESSION_SECURITY != 0
+	lm := flags&NEGOTIATE_LM_SESSION_KEY != 0
+	if !ess && !lm || ess && flags&NEGOTIATE_128BIT_SESSION_KEY != 0 {
+		return 16
+	}
+	if flags&NEGOTIATE_56BIT_ENCRYPTION != 0 {
+		return 7
+	}
+	return 5
+}
+
+// MS-NLMP 3.4.5.3 SEALKEY, mode is "Client" or "Server"
+func SealKey(flags uint32, sessionKey []byte, mode string) []byte {
+	if flags&NEGOTIATE_EXTENDED_SESSION_SECURITY != 0 {
+		key := append([]byte{}, sessionKey[:KeyStrength(flags)]...)
+
+		magic := serverSealingMagic
+		if mode == "Client" {
+			magic = clientSealingMagic
+		}
+		return md5Hash(append(key, magic...))
+	}
+
+	if flags&NEGOTIATE_LM_SESSION_KEY != 0 {
+		if KeyStrength(flags) == 7 {
+			return append(append([]byte{}, sessionKey[:7]...), 0xa0)
+		}
+		return append(append([]byte{}, sessionKey[:5]...), 0xe5, 0x38, 0xb0)
+	}
+	return append([]byte{}, sessionKey...)
+}
+
+// Random 16-byte ExportedSessionKey for NEGOTIATE_KEY_EXCH
+func NewExportedSessionKey() []byte {
+	key := make([]byte, 16)
+	io.ReadFull(Rand, key)
+	return key
+}
+
+// EncryptedRandomSessionKey of type3, RC4K(KeyExchangeKey, ExportedSessionKey)
+func EncryptSessionKey(keyExchangeKey, exportedSessionKey []byte) []byte {
+	return rc4k(keyExchangeKey, exportedSessionKey)
+}
+
+// ExportedSessionKey from the EncryptedRandomSessionKey of type3
+func DecryptSessionKey(keyExchangeKey, encrypted []byte) []byte {
+	return rc4k(keyExchangeKey, encrypted)
+}
+
+func rc4k(key, data []byte) []byte {
+	cipher, err := rc4.NewCipher(key)
+	if err != nil {
+		return nil
+	}
+	out := make([]byte, len(data))
+	cipher.XORKeyStream(out, data)
+	return out
+}
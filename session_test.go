@@ -0,0 +1,154 @@
+package ntlmssp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestSignSealKey(t *testing.T) {
+	cases := []struct {
+		name       string
+		flags      uint32
+		sessionKey string
+		signKey    string
+		sealKey    string
+	}{
+		// MS-NLMP 4.2.2.1.3, NTLMv1 with the random session key
+		{"NTLMv1", NEGOTIATE_128BIT_SESSION_KEY | NEGOTIATE_56BIT_ENCRYPTION,
+			"55555555555555555555555555555555", "", "55555555555555555555555555555555"},
+		{"NTLMv1 56-bit LM key", NEGOTIATE_LM_SESSION_KEY | NEGOTIATE_56BIT_ENCRYPTION,
+			"55555555555555555555555555555555", "", "55555555555555a0"},
+		{"NTLMv1 40-bit LM key", NEGOTIATE_LM_SESSION_KEY,
+			"55555555555555555555555555555555", "", "5555555555e538b0"},
+		// MS-NLMP 4.2.3.4, NTLMv1 with extended session security
+		{"NTLM2 session", NEGOTIATE_EXTENDED_SESSION_SECURITY | NEGOTIATE_56BIT_ENCRYPTION,
+			"eb93429a8bd952f8b89c55b87f475edc", "60e799be5c72fc92922ae8ebe961fb8d", "04dd7f014d8504d265a25cc86a3a7c06"},
+		// MS-NLMP 4.2.4.4, NTLMv2
+		{"NTLMv2", NEGOTIATE_EXTENDED_SESSION_SECURITY | NEGOTIATE_128BIT_SESSION_KEY | NEGOTIATE_56BIT_ENCRYPTION,
+			"55555555555555555555555555555555", "4788dc861b4782f35d43fd98fe1a2d39", "59f600973cc4960a25480a7c196e4c58"},
+	}
+
+	for _, c := range cases {
+		key := decodeHex(c.sessionKey)
+		if got := hex.EncodeToString(SignKey(c.flags, key, "Client")); got != c.signKey {
+			t.Errorf("%s: SignKey = %s, want %s", c.name, got, c.signKey)
+		}
+		if got := hex.EncodeToString(SealKey(c.flags, key, "Client")); got != c.sealKey {
+			t.Errorf("%s: SealKey = %s, want %s", c.name, got, c.sealKey)
+		}
+	}
+
+	key := decodeHex("55555555555555555555555555555555")
+	flags := uint32(NEGOTIATE_EXTENDED_SESSION_SECURITY | NEGOTIATE_128BIT_SESSION_KEY)
+	if hex.EncodeToString(SignKey(flags, key, "Server")) == hex.EncodeToString(SignKey(flags, key, "Client")) {
+		t.Errorf("client and server signing keys are equal")
+	}
+	if hex.EncodeToString(SealKey(flags, key, "Server")) == hex.EncodeToString(SealKey(flags, key, "Client")) {
+		t.Errorf("client and server sealing keys are equal")
+	}
+}
+
+func TestEncryptSessionKey(t *testing.T) {
+	// MS-NLMP 4.2.4.2.3
+	kxkey := decodeHex("8de40ccadbc14a82f15cb0ad0de95ca3")
+	exported := decodeHex("55555555555555555555555555555555")
+	encrypted := EncryptSessionKey(kxkey, exported)
+	if got, want := hex.EncodeToString(encrypted), "c5dad2544fc9799094ce1ce90bc9d03e"; got != want {
+		t.Errorf("EncryptSessionKey = %s, want %s", got, want)
+	}
+	if got := DecryptSessionKey(kxkey, encrypted); !bytes.Equal(got, exported) {
+		t.Errorf("DecryptSessionKey = %x, want %x", got, exported)
+	}
+
+	key := NewExportedSessionKey()
+	if len(key) != 16 {
+		t.Fatalf("len(NewExportedSessionKey()) = %d", len(key))
+	}
+	if got := DecryptSessionKey(kxkey, EncryptSessionKey(kxkey, key)); !bytes.Equal(got, key) {
+		t.Errorf("round trip = %x, want %x", got, key)
+	}
+}
+
+func TestKeyStrength(t *testing.T) {
+	sessionKey := decodeHex("55555555555555555555555555555555")
+	for _, c := range []struct {
+		flags    uint32
+		strength int
+		sealKey  int
+	}{
+		{NEGOTIATE_EXTENDED_SESSION_SECURITY | NEGOTIATE_128BIT_SESSION_KEY | NEGOTIATE_56BIT_ENCRYPTION, 16, 16},
+		{NEGOTIATE_EXTENDED_SESSION_SECURITY | NEGOTIATE_56BIT_ENCRYPTION, 7, 16},
+		{NEGOTIATE_EXTENDED_SESSION_SECURITY, 5, 16},
+		// LM session key, 8 bytes with the 0xa0 or 0xe538b0 suffix
+		{NEGOTIATE_LM_SESSION_KEY | NEGOTIATE_128BIT_SESSION_KEY | NEGOTIATE_56BIT_ENCRYPTION, 7, 8},
+		{NEGOTIATE_LM_SESSION_KEY | NEGOTIATE_128BIT_SESSION_KEY, 5, 8},
+		// NTLMv1 seals with the whole session key
+		{NEGOTIATE_128BIT_SESSION_KEY, 16, 16},
+		{NEGOTIATE_56BIT_ENCRYPTION, 16, 16},
+	} {
+		if got := KeyStrength(c.flags); got != c.strength {
+			t.Errorf("KeyStrength(%x) = %d, want %d", c.flags, got, c.strength)
+		}
+		if got := len(SealKey(c.flags, sessionKey, "Client")); got != c.sealKey {
+			t.Errorf("SealKey(%x) is %d bytes, want %d", c.flags, got, c.sealKey)
+		}
+	}
+
+	// the ESS seal keys of the three tiers differ
+	k128 := SealKey(NEGOTIATE_EXTENDED_SESSION_SECURITY|NEGOTIATE_128BIT_SESSION_KEY, sessionKey, "Client")
+	k56 := SealKey(NEGOTIATE_EXTENDED_SESSION_SECURITY|NEGOTIATE_56BIT_ENCRYPTION, sessionKey, "Client")
+	k40 := SealKey(NEGOTIATE_EXTENDED_SESSION_SECURITY, sessionKey, "Client")
+	if bytes.Equal(k128, k56) || bytes.Equal(k56, k40) || bytes.Equal(k128, k40) {
+		t.Errorf("seal keys %x, %x, %x", k128, k56, k40)
+	}
+}
+
+func TestKXKey(t *testing.T) {
+	// MS-NLMP 4.2.2 LMOWFv1 and ServerChallenge
+	lmHash := decodeHex("e52cac67419a9a224a3b108f3fa6cb6d")
+	serverChallenge := decodeHex("0123456789abcdef")
+	v1BaseKey := "d87262b0cde4b1cb7499becccdf10784"
+	v1LmResponse := "98def7b87f88aa5dafe2df779688a172def11c7d5ccdef13"
+
+	cases := []struct {
+		name           string
+		flags          uint32
+		sessionBaseKey string
+		lmResponse     string
+		kxkey          string
+	}{
+		// MS-NLMP 4.2.2.1.4
+		{"NTLMv1", NEGOTIATE_NTLM, v1BaseKey, v1LmResponse, v1BaseKey},
+		{"NTLMv1 LM key", NEGOTIATE_NTLM | NEGOTIATE_LM_SESSION_KEY, v1BaseKey, v1LmResponse,
+			"b09e379f7fbecb1eaf0afdcb0383c8a0"},
+		{"NTLMv1 non-NT key", NEGOTIATE_NTLM | NEGOTIATE_REQUEST_NON_NT_SESSION_KEY, v1BaseKey, v1LmResponse,
+			"e52cac67419a9a220000000000000000"},
+		// MS-NLMP 4.2.3.1.2
+		{"NTLM2 session", NEGOTIATE_NTLM | NEGOTIATE_EXTENDED_SESSION_SECURITY, v1BaseKey,
+			"aaaaaaaaaaaaaaaa00000000000000000000000000000000", "eb93429a8bd952f8b89c55b87f475edc"},
+		// MS-NLMP 4.2.4.1.3
+		{"NTLMv2", NEGOTIATE_NTLM | NEGOTIATE_EXTENDED_SESSION_SECURITY, "8de40ccadbc14a82f15cb0ad0de95ca3",
+			"86c35097ac9cec102554764a57cccc19aaaaaaaaaaaaaaaa", "8de40ccadbc14a82f15cb0ad0de95ca3"},
+		{"NTLMv2 with MIC", NEGOTIATE_NTLM | NEGOTIATE_EXTENDED_SESSION_SECURITY, "8de40ccadbc14a82f15cb0ad0de95ca3",
+			"000000000000000000000000000000000000000000000000", "8de40ccadbc14a82f15cb0ad0de95ca3"},
+	}
+
+	for _, c := range cases {
+		got := KXKey(c.flags, decodeHex(c.sessionBaseKey), decodeHex(c.lmResponse), serverChallenge, lmHash)
+		if hex.EncodeToString(got) != c.kxkey {
+			t.Errorf("%s: KXKey = %x, want %s", c.name, got, c.kxkey)
+		}
+	}
+}
+
+func TestLMSessionBaseKey(t *testing.T) {
+	// MS-NLMP 4.2.2.1.2 LMOWFv1 of "Password"
+	lmHash := LMHash("Password")
+	if got, want := hex.EncodeToString(LMSessionBaseKey(lmHash)), "e52cac67419a9a220000000000000000"; got != want {
+		t.Errorf("LMSessionBaseKey = %s, want %s", got, want)
+	}
+	if got := LMSessionBaseKey(lmHash[:8]); got != nil {
+		t.Errorf("LMSessionBaseKey of 8 bytes = %x", got)
+	}
+}
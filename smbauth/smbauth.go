@@ -0,0 +1,126 @@
+// Package smbauth drives NTLM authentication over SMB2 SESSION_SETUP, as
+// described in MS-SMB2 3.2.5.3. It only builds and parses the
+// SESSION_SETUP bodies, the SMB2 header and transport are left to the
+// caller.
+package smbauth
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/JKme/go-ntlmssp"
+)
+
+const (
+	// Size of the SMB2 header, security buffer offsets are from its start
+	headerSize = 64
+
+	requestStructureSize  = 25
+	responseStructureSize = 9
+
+	// Fixed parts of SMB2 SESSION_SETUP Request and Response
+	requestSize  = 24
+	responseSize = 8
+)
+
+// SecurityMode of the SESSION_SETUP Request
+const (
+	SigningEnabled  = 0x01
+	SigningRequired = 0x02
+)
+
+// SessionFlags of the SESSION_SETUP Response
+const (
+	SessionFlagIsGuest     = 0x0001
+	SessionFlagIsNull      = 0x0002
+	SessionFlagEncryptData = 0x0004
+)
+
+// MS-SMB2 2.2.5 SESSION_SETUP Request without the SMB2 header, around the
+// SPNEGO token
+func SessionSetupRequest(securityMode byte, capabilities uint32, securityBuffer []byte) []byte {
+	bs := make([]byte, requestSize, requestSize+len(securityBuffer))
+	binary.LittleEndian.PutUint16(bs[0:], requestStructureSize)
+	bs[3] = securityMode
+	binary.LittleEndian.PutUint32(bs[4:], capabilities)
+	binary.LittleEndian.PutUint16(bs[12:], headerSize+requestSize)
+	binary.LittleEndian.PutUint16(bs[14:], uint16(len(securityBuffer)))
+	return append(bs, securityBuffer...)
+}
+
+// MS-SMB2 2.2.6 SESSION_SETUP Response without the SMB2 header, the
+// SessionFlags and the SPNEGO token of the server
+func ParseSessionSetupResponse(bs []byte) (sessionFlags uint16, securityBuffer []byte, err error) {
+	if len(bs) < responseSize {
+		return 0, nil, fmt.Errorf("smbauth: SESSION_SETUP response too short")
+	}
+	if size := binary.LittleEndian.Uint16(bs[0:]); size != responseStructureSize {
+		return 0, nil, fmt.Errorf("smbauth: invalid SESSION_SETUP response StructureSize %d", size)
+	}
+	sessionFlags = binary.LittleEndian.Uint16(bs[2:])
+
+	offset := int(binary.LittleEndian.Uint16(bs[4:]))
+	length := int(binary.LittleEndian.Uint16(bs[6:]))
+	if length == 0 {
+		return sessionFlags, nil, nil
+	}
+	if offset < headerSize+responseSize || offset-headerSize+length > len(bs) {
+		return 0, nil, fmt.Errorf("smbauth: security buffer out of range")
+	}
+	return sessionFlags, bs[offset-headerSize : offset-headerSize+length], nil
+}
+
+// Initiator sequences the two SESSION_SETUP round trips: the first request
+// carries the NEGOTIATE_MESSAGE in a NegTokenInit, the response with
+// STATUS_MORE_PROCESSING_REQUIRED the CHALLENGE_MESSAGE, answered by the
+// AUTHENTICATE_MESSAGE in a NegTokenResp.
+type Initiator struct {
+	Client *ntlmssp.Client
+	// SecurityMode and Capabilities of the SESSION_SETUP Requests
+	SecurityMode byte
+	Capabilities uint32
+
+	done bool
+}
+
+func NewInitiator(cred ntlmssp.Credentials) *Initiator {
+	return &Initiator{Client: ntlmssp.NewClient(cred), SecurityMode: SigningEnabled}
+}
+
+// First SESSION_SETUP Request
+func (i *Initiator) Negotiate() []byte {
+	i.done = false
+	return SessionSetupRequest(i.SecurityMode, i.Capabilities, ntlmssp.SPNEGOWrapInitial(i.Client.Negotiate()))
+}
+
+// Second SESSION_SETUP Request for the server's first SESSION_SETUP
+// Response
+func (i *Initiator) Authenticate(response []byte) ([]byte, error) {
+	_, token, err := ParseSessionSetupResponse(response)
+	if err != nil {
+		return nil, err
+	}
+	mech, type2, err := ntlmssp.SPNEGOUnwrap(token)
+	if err != nil {
+		return nil, err
+	}
+	if mech != nil && !mech.Equal(ntlmssp.NTLMSSPOID) {
+		return nil, fmt.Errorf("smbauth: server chose mechanism %v", mech)
+	}
+
+	type3, err := i.Client.ProcessChallenge(type2)
+	if err != nil {
+		return nil, err
+	}
+	i.done = true
+	return SessionSetupRequest(i.SecurityMode, i.Capabilities, ntlmssp.SPNEGOWrapResponse(type3)), nil
+}
+
+// Session key for SMB2 signing and the SMB 3.x key derivation, nil before
+// Authenticate
+func (i *Initiator) SessionKey() []byte {
+	if !i.done {
+		return nil
+	}
+	return i.Client.SessionKey()
+}
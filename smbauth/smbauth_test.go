@@ -0,0 +1,91 @@
+package smbauth
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/JKme/go-ntlmssp"
+)
+
+func decodeHex(s string) []byte {
+	bs, _ := hex.DecodeString(s)
+	return bs
+}
+
+func TestParseSessionSetupResponse(t *testing.T) {
+	type2 := "4e544c4d53535000020000000c000c003800000033828ae20123456789abcdef00000000000000002400240044000000060070170000000f53006500720076006500720002000c0044006f006d00610069006e0001000c0053006500720076006500720000000000"
+	// SESSION_SETUP Response of STATUS_MORE_PROCESSING_REQUIRED, the
+	// NegTokenResp carries the MS-NLMP 4.2.4.3 challenge
+	token := "a18181307fa0030a0101a10c060a2b06010401823702020aa26a0468" + type2
+	response := decodeHex("0900000048008400" + token)
+
+	flags, buffer, err := ParseSessionSetupResponse(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags != 0 || hex.EncodeToString(buffer) != token {
+		t.Errorf("SessionFlags = %#x, SecurityBuffer = %x", flags, buffer)
+	}
+
+	ini := NewInitiator(ntlmssp.Credentials{User: "User", Domain: "Domain", Password: "Password"})
+	ini.Negotiate()
+	request, err := ini.Authenticate(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// StructureSize 25, SecurityMode signing enabled, SecurityBufferOffset 0x58
+	if hex.EncodeToString(request[:14]) != "1900000100000000000000005800" || int(request[14])|int(request[15])<<8 != len(request)-24 {
+		t.Errorf("SESSION_SETUP Request = %x", request[:24])
+	}
+	_, type3, err := ntlmssp.SPNEGOUnwrap(request[24:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if am, err := ntlmssp.NewAuthenticateMsg(type3); err != nil || am.UserName() != "User" {
+		t.Errorf("AUTHENTICATE_MESSAGE = %x, %v", type3, err)
+	}
+
+	for _, bad := range []string{"", "090000004800", "0800000048008400" + token, "0900000048008500" + token, "0900000040000400" + token} {
+		if _, _, err := ParseSessionSetupResponse(decodeHex(bad)); err == nil {
+			t.Errorf("ParseSessionSetupResponse(%s): expected error", bad)
+		}
+	}
+}
+
+func TestInitiator(t *testing.T) {
+	server := ntlmssp.NewServer()
+	server.SetCredentials("User", "Domain", ntlmssp.NTHash("Password"))
+	ini := NewInitiator(ntlmssp.Credentials{User: "User", Domain: "Domain", Password: "Password"})
+
+	request := ini.Negotiate()
+	if _, _, err := ParseSessionSetupResponse(request); err == nil {
+		t.Error("request parsed as a response")
+	}
+	mech, type1, err := ntlmssp.SPNEGOUnwrap(request[24:])
+	if err != nil || !mech.Equal(ntlmssp.NTLMSSPOID) {
+		t.Fatalf("NegTokenInit = %v, %v", mech, err)
+	}
+	type2, err := server.Challenge(type1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := append(decodeHex("0900000048000000"), ntlmssp.SPNEGOWrapResponse(type2)...)
+	response[6] = byte(len(response) - 8)
+
+	if ini.SessionKey() != nil {
+		t.Error("SessionKey before Authenticate")
+	}
+	request, err = ini.Authenticate(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, type3, _ := ntlmssp.SPNEGOUnwrap(request[24:])
+	session, err := server.Authenticate(type3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(session.SessionKey, ini.SessionKey()) {
+		t.Errorf("SessionKey = %x, want %x", ini.SessionKey(), session.SessionKey)
+	}
+}
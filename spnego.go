@@ -0,0 +1,97 @@
+package ntlmssp
+
+import (
+	"encoding/asn1"
+	"fmt"
+)
+
+var (
+	// SPNEGO, RFC 4178
+	spnegoOID = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 2}
+	// NTLM Security Support Provider
+	NTLMSSPOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 2, 10}
+)
+
+// RFC 4178 4.2.1
+type negTokenInit struct {
+	MechTypes   []asn1.ObjectIdentifier `asn1:"explicit,tag:0"`
+	ReqFlags    asn1.BitString          `asn1:"explicit,optional,tag:1"`
+	MechToken   []byte                  `asn1:"explicit,optional,tag:2"`
+	MechListMIC []byte                  `asn1:"explicit,optional,tag:3"`
+}
+
+// RFC 4178 4.2.2
+type negTokenResp struct {
+	NegState      asn1.Enumerated       `asn1:"explicit,optional,tag:0"`
+	SupportedMech asn1.ObjectIdentifier `asn1:"explicit,optional,tag:1"`
+	ResponseToken []byte                `asn1:"explicit,optional,tag:2"`
+	MechListMIC   []byte                `asn1:"explicit,optional,tag:3"`
+}
+
+// InitialContextToken with a NegTokenInit offering only NTLM and carrying
+// the NEGOTIATE_MESSAGE, the first token for a "Negotiate" HTTP server
+func SPNEGOWrapInitial(ntlmType1 []byte) []byte {
+	init, _ := asn1.Marshal(negTokenInit{
+		MechTypes: []asn1.ObjectIdentifier{NTLMSSPOID},
+		MechToken: ntlmType1,
+	})
+	token, _ := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: init})
+	oid, _ := asn1.Marshal(spnegoOID)
+	bs, _ := asn1.Marshal(asn1.RawValue{Class: asn1.ClassApplication, Tag: 0, IsCompound: true, Bytes: append(oid, token...)})
+	return bs
+}
+
+// NegTokenResp carrying the AUTHENTICATE_MESSAGE
+func SPNEGOWrapResponse(ntlmType3 []byte) []byte {
+	resp, _ := asn1.Marshal(negTokenResp{ResponseToken: ntlmType3})
+	bs, _ := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: resp})
+	return bs
+}
+
+// The mechanism and its token in a SPNEGO InitialContextToken or
+// NegTokenResp. For a NegTokenInit the mechanism is the preferred one, for
+// a NegTokenResp the one chosen by the server, nil if it is left out.
+func SPNEGOUnwrap(token []byte) (mechType asn1.ObjectIdentifier, inner []byte, err error) {
+	var raw asn1.RawValue
+	if rest, err := asn1.Unmarshal(token, &raw); err != nil {
+		return nil, nil, fmt.Errorf("ntlmssp: malformed SPNEGO token: %v", err)
+	} else if len(rest) != 0 {
+		return nil, nil, fmt.Errorf("ntlmssp: trailing data after SPNEGO token")
+	}
+
+	if raw.Class == asn1.ClassApplication && raw.Tag == 0 {
+		var oid asn1.ObjectIdentifier
+		rest, err := asn1.Unmarshal(raw.Bytes, &oid)
+		if err != nil {
+			return nil, nil, fmt.Errorf("ntlmssp: malformed SPNEGO token: %v", err)
+		}
+		if !oid.Equal(spnegoOID) {
+			return nil, nil, fmt.Errorf("ntlmssp: not a SPNEGO token, mechanism %v", oid)
+		}
+		if _, err := asn1.Unmarshal(rest, &raw); err != nil {
+			return nil, nil, fmt.Errorf("ntlmssp: malformed SPNEGO token: %v", err)
+		}
+	}
+
+	if raw.Class != asn1.ClassContextSpecific {
+		return nil, nil, fmt.Errorf("ntlmssp: unknown SPNEGO token class %d", raw.Class)
+	}
+	switch raw.Tag {
+	case 0:
+		var init negTokenInit
+		if _, err := asn1.Unmarshal(raw.Bytes, &init); err != nil {
+			return nil, nil, fmt.Errorf("ntlmssp: malformed NegTokenInit: %v", err)
+		}
+		if len(init.MechTypes) == 0 {
+			return nil, nil, fmt.Errorf("ntlmssp: NegTokenInit without mechanisms")
+		}
+		return init.MechTypes[0], init.MechToken, nil
+	case 1:
+		var resp negTokenResp
+		if _, err := asn1.Unmarshal(raw.Bytes, &resp); err != nil {
+			return nil, nil, fmt.Errorf("ntlmssp: malformed NegTokenResp: %v", err)
+		}
+		return resp.SupportedMech, resp.ResponseToken, nil
+	}
+	return nil, nil, fmt.Errorf("ntlmssp: unknown SPNEGO token [%d]", raw.Tag)
+}
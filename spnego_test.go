@@ -0,0 +1,58 @@
+package ntlmssp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestSPNEGOUnwrap(t *testing.T) {
+	type2 := "4e544c4d53535000020000000c000c003800000033828ae20123456789abcdef00000000000000002400240044000000060070170000000f53006500720076006500720002000c0044006f006d00610069006e0001000c0053006500720076006500720000000000"
+	// NegTokenResp of a "Negotiate" server, negState accept-incomplete,
+	// supportedMech NTLMSSP and the MS-NLMP 4.2.4.3 challenge
+	token := decodeHex("a18181307fa0030a0101a10c060a2b06010401823702020aa26a0468" + type2)
+
+	mech, inner, err := SPNEGOUnwrap(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mech.Equal(NTLMSSPOID) {
+		t.Errorf("mechType = %v, want %v", mech, NTLMSSPOID)
+	}
+	if hex.EncodeToString(inner) != type2 {
+		t.Errorf("inner = %x, want %s", inner, type2)
+	}
+	if _, err := NewChallengeMsg(inner); err != nil {
+		t.Error(err)
+	}
+
+	for _, bad := range [][]byte{nil, token[:len(token)-1], append(token, 0), decodeHex("3000")} {
+		if _, _, err := SPNEGOUnwrap(bad); err == nil {
+			t.Errorf("SPNEGOUnwrap(%x): expected error", bad)
+		}
+	}
+}
+
+func TestSPNEGOWrap(t *testing.T) {
+	type1 := NewClient(Credentials{}).Negotiate()
+	init := SPNEGOWrapInitial(type1)
+	// [APPLICATION 0] SPNEGO, NegTokenInit with mechTypes NTLMSSP
+	if init[0] != 0x60 || !bytes.Equal(init[2:10], decodeHex("06062b0601050502")) ||
+		!bytes.Contains(init, decodeHex("a00e300c060a2b06010401823702020a")) {
+		t.Errorf("SPNEGOWrapInitial = %x", init)
+	}
+	mech, inner, err := SPNEGOUnwrap(init)
+	if err != nil || !mech.Equal(NTLMSSPOID) || !bytes.Equal(inner, type1) {
+		t.Errorf("SPNEGOUnwrap(SPNEGOWrapInitial) = %v, %x, %v", mech, inner, err)
+	}
+
+	type3 := []byte("NTLMSSP\x00\x03")
+	resp := SPNEGOWrapResponse(type3)
+	if got, want := hex.EncodeToString(resp), "a10f300da20b04094e544c4d5353500003"; got != want {
+		t.Errorf("SPNEGOWrapResponse = %s, want %s", got, want)
+	}
+	mech, inner, err = SPNEGOUnwrap(resp)
+	if err != nil || mech != nil || !bytes.Equal(inner, type3) {
+		t.Errorf("SPNEGOUnwrap(SPNEGOWrapResponse) = %v, %x, %v", mech, inner, err)
+	}
+}
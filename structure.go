@@ -2,6 +2,8 @@ package ntlmssp
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"unsafe"
 )
 
@@ -39,28 +41,51 @@ var avIdsRev = map[string]byte{
 	"MsvAvChannelBindings": 10,
 }
 
+// Like ParseAVPairSafe, but errors are ignored and the pairs read so far
+// returned
 func ParseAVPair(bs []byte) map[string]interface{} {
+	output, _ := ParseAVPairSafe(bs)
+	return output
+}
+
+// Names are decoded to strings, MsvAvTimestamp to time.Time, MsvAvSingleHost
+// to SingleHostData and the other values kept as []byte. Every pair must fit
+// in bs and the list end with MsvAvEOL, trailing bytes are ignored.
+func ParseAVPairSafe(bs []byte) (map[string]interface{}, error) {
 	output := map[string]interface{}{}
-	var ptr uint16 = 0
+	ptr := 0
 	for {
+		if len(bs)-ptr < 4 {
+			return output, fmt.Errorf("%w: AV pair list without MsvAvEOL", ErrMalformedMessage)
+		}
 		avId := uint16(bs[ptr]) + (uint16(bs[ptr+1]) << 8)
+		// anything after MsvAvEOL is padding, see ValidateAVPairs
 		if avId == 0 {
-			break
+			return output, nil
 		}
 
-		length := uint16(bs[ptr+2]) + (uint16(bs[ptr+3]) << 8)
+		length := int(bs[ptr+2]) + (int(bs[ptr+3]) << 8)
+		if len(bs)-ptr-4 < length {
+			return output, fmt.Errorf("%w: AV pair %d (len %d) exceeds the list", ErrMalformedMessage, avId, length)
+		}
 		value := bs[ptr+4 : ptr+4+length]
 		ptr += 4 + length
 
-		// Only parse unicode string
-		if avId != 6 && avId != 7 && avId != 8 && avId != 10 {
+		// Only parse unicode string, FILETIME and Single_Host_Data
+		if avId == 8 {
+			if sh, err := ParseSingleHostData(value); err == nil {
+				output[avIds[avId]] = sh
+			} else {
+				output[avIds[avId]] = value
+			}
+		} else if avId == 7 && length == 8 {
+			output[avIds[avId]] = TimeFromWindowsTimestamp(value)
+		} else if avId != 6 && avId != 7 && avId != 10 {
 			output[avIds[avId]] = bytes2StringUTF16(value)
 		} else {
 			output[avIds[avId]] = value
 		}
 	}
-
-	return output
 }
 
 const (
@@ -96,6 +121,13 @@ const (
 	NEGOTIATE_REQUEST_TARGET_NAME        = 0x4
 	NEGOTIATE_OEM_CHARSET                = 0x2
 	NEGOTIATE_UNICODE_CHARSET            = 0x1
+
+	// NTLMSSP_TARGET_TYPE_SHARE of older implementations, r6 in MS-NLMP
+	NEGOTIATE_TARGET_TYPE_SHARE = NEGOTIATE_R6_UNUSED
+	// NTLMSSP_NEGOTIATE_LOCAL_CALL of older implementations, r7 in MS-NLMP.
+	// The CHALLENGE_MESSAGE then carries the server context handle in
+	// Reserved.
+	NEGOTIATE_LOCAL_CALL = NEGOTIATE_R7_UNUSED
 )
 
 func ParseNegotiateFlags(ui uint32) *[32][2]string {
@@ -148,6 +180,10 @@ func ParseNegotiateFlags(ui uint32) *[32][2]string {
 }
 
 func DisplayNegotiateFlags(ui uint32) {
+	WriteNegotiateFlags(os.Stdout, ui)
+}
+
+func WriteNegotiateFlags(w io.Writer, ui uint32) {
 	flags := ParseNegotiateFlags(ui)
 
 	for i := 0; i < 4; i++ {
@@ -157,7 +193,7 @@ func DisplayNegotiateFlags(ui uint32) {
 				set = "Set"
 			}
 
-			fmt.Printf("%s  %s: %s\n",
+			fmt.Fprintf(w, "%s  %s: %s\n",
 				displayBits(i*8+j, flags[i*8+j][1] == "1"),
 				flags[i*8+j][0], set)
 		}
@@ -0,0 +1,77 @@
+package ntlmssp
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// Typed alternative to the map of SetTargetInfo and ParseAVPair. Empty
+// fields are left out of Marshal.
+type TargetInfo struct {
+	NetBIOSDomainName   string
+	NetBIOSComputerName string
+	DNSDomainName       string
+	DNSComputerName     string
+	DNSTreeName         string
+	Flags               uint32
+	Timestamp           time.Time
+	TargetName          string
+	ChannelBindings     []byte
+}
+
+// AV pairs in MS-NLMP order, terminated by MsvAvEOL
+func (ti TargetInfo) Marshal() []byte {
+	pairs := new(AvPairs)
+	for _, p := range []struct {
+		id    AvPairType
+		value string
+	}{
+		{MsvAvNbDomainName, ti.NetBIOSDomainName},
+		{MsvAvNbComputerName, ti.NetBIOSComputerName},
+		{MsvAvDnsDomainName, ti.DNSDomainName},
+		{MsvAvDnsComputerName, ti.DNSComputerName},
+		{MsvAvDnsTreeName, ti.DNSTreeName},
+	} {
+		if p.value != "" {
+			pairs.Set(p.id, encodeUTF16LE([]byte(p.value)))
+		}
+	}
+	if ti.Flags != 0 {
+		flags := make([]byte, 4)
+		binary.LittleEndian.PutUint32(flags, ti.Flags)
+		pairs.Set(MsvAvFlags, flags)
+	}
+	if !ti.Timestamp.IsZero() {
+		pairs.Set(MsvAvTimestamp, WindowsTimestamp(ti.Timestamp))
+	}
+	if ti.TargetName != "" {
+		pairs.SetTargetName(ti.TargetName)
+	}
+	if ti.ChannelBindings != nil {
+		pairs.Set(MsvChannelBindings, ti.ChannelBindings)
+	}
+	return pairs.Marshal()
+}
+
+// Decode target info, unknown AV pairs are ignored
+func ParseTargetInfo(bs []byte) (TargetInfo, error) {
+	pairs, err := ParseAVPairsOrdered(bs)
+	if err != nil {
+		return TargetInfo{}, err
+	}
+
+	ti := TargetInfo{
+		NetBIOSDomainName:   pairs.StringValue(MsvAvNbDomainName),
+		NetBIOSComputerName: pairs.StringValue(MsvAvNbComputerName),
+		DNSDomainName:       pairs.StringValue(MsvAvDnsDomainName),
+		DNSComputerName:     pairs.StringValue(MsvAvDnsComputerName),
+		DNSTreeName:         pairs.StringValue(MsvAvDnsTreeName),
+		Flags:               pairs.Flags(),
+		TargetName:          pairs.StringValue(MsvAvTargetName),
+		ChannelBindings:     pairs.Get(MsvChannelBindings),
+	}
+	if t, ok := pairs.Timestamp(); ok {
+		ti.Timestamp = t
+	}
+	return ti, nil
+}
@@ -0,0 +1,95 @@
+package ntlmssp
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTargetInfo(t *testing.T) {
+	// MS-NLMP 4.2.4
+	bs := decodeHex("02000c0044006f006d00610069006e0001000c0053006500720076006500720000000000")
+	ti, err := ParseTargetInfo(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ti.NetBIOSDomainName != "Domain" || ti.NetBIOSComputerName != "Server" {
+		t.Errorf("ParseTargetInfo = %+v", ti)
+	}
+	if got := ti.Marshal(); !bytes.Equal(got, bs) {
+		t.Errorf("Marshal = %x, want %x", got, bs)
+	}
+
+	full := TargetInfo{
+		NetBIOSDomainName:   "DOMAIN",
+		NetBIOSComputerName: "SERVER",
+		DNSDomainName:       "domain.local",
+		DNSComputerName:     "server.domain.local",
+		DNSTreeName:         "domain.local",
+		Flags:               MsvAvFlagMICProvided,
+		Timestamp:           time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC),
+		TargetName:          "HTTP/server.domain.local",
+		ChannelBindings:     make([]byte, 16),
+	}
+	parsed, err := ParseTargetInfo(full.Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, full) {
+		t.Errorf("round trip = %+v, want %+v", parsed, full)
+	}
+
+	pairs, _ := ParseAVPairsOrdered(full.Marshal())
+	want := []AvPairType{MsvAvNbDomainName, MsvAvNbComputerName, MsvAvDnsDomainName, MsvAvDnsComputerName,
+		MsvAvDnsTreeName, MsvAvFlags, MsvAvTimestamp, MsvAvTargetName, MsvChannelBindings, MsvAvEOL}
+	for i := range want {
+		if pairs.List[i].AvId != want[i] {
+			t.Errorf("pair %d = %d, want %d", i, pairs.List[i].AvId, want[i])
+		}
+	}
+
+	if _, err := ParseTargetInfo(bs[:10]); err == nil {
+		t.Error("ParseTargetInfo(truncated): expected error")
+	}
+}
+
+func TestTargetInfo_DNSNames(t *testing.T) {
+	want := []struct {
+		id  AvPairType
+		hex string
+	}{
+		{MsvAvDnsDomainName, "0400140063006f00720070002e006c006f00630061006c00"},
+		{MsvAvDnsComputerName, "03001c007300720076002e0063006f00720070002e006c006f00630061006c00"},
+		{MsvAvDnsTreeName, "0500180066006f0072006500730074002e006c006f00630061006c00"},
+	}
+
+	fromMap, _ := NewChallengeMsg(nil)
+	if err := fromMap.SetTargetInfo(map[string]interface{}{
+		"MsvAvDnsDomainName":   "corp.local",
+		"MsvAvDnsComputerName": "srv.corp.local",
+		"MsvAvDnsTreeName":     "forest.local",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	fromStruct := TargetInfo{
+		DNSDomainName:   "corp.local",
+		DNSComputerName: "srv.corp.local",
+		DNSTreeName:     "forest.local",
+	}.Marshal()
+
+	for name, bs := range map[string][]byte{"map": fromMap.TargetInfo(), "struct": fromStruct} {
+		pairs, err := ParseAVPairsOrdered(bs)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(pairs.List) != len(want)+1 || pairs.List[len(want)].AvId != MsvAvEOL {
+			t.Fatalf("%s: %d pairs in %x", name, len(pairs.List), bs)
+		}
+		for i, w := range want {
+			if got := pairs.List[i]; got.AvId != w.id || !bytes.Equal(got.Bytes(), decodeHex(w.hex)) {
+				t.Errorf("%s: pair %d = %x, want %s", name, i, got.Bytes(), w.hex)
+			}
+		}
+	}
+}
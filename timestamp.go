@@ -0,0 +1,22 @@
+package ntlmssp
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// 8-byte little-endian FILETIME of t, the MsvAvTimestamp and NTLMv2
+// response timestamp format
+func WindowsTimestamp(t time.Time) []byte {
+	bs := make([]byte, 8)
+	binary.LittleEndian.PutUint64(bs, timeToFileTime(t))
+	return bs
+}
+
+// Inverse of WindowsTimestamp, the zero time.Time if b is not 8 bytes
+func TimeFromWindowsTimestamp(b []byte) time.Time {
+	if len(b) != 8 {
+		return time.Time{}
+	}
+	return fileTimeToTime(binary.LittleEndian.Uint64(b))
+}
@@ -0,0 +1,25 @@
+package ntlmssp
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func TestWindowsTimestamp(t *testing.T) {
+	// 1970-01-01 is 11644473600 seconds after 1601-01-01
+	if got, want := hex.EncodeToString(WindowsTimestamp(time.Unix(0, 0))), "00803ed5deb19d01"; got != want {
+		t.Errorf("WindowsTimestamp(epoch) = %s, want %s", got, want)
+	}
+	if got := TimeFromWindowsTimestamp(make([]byte, 8)); !got.Equal(time.Date(1601, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("TimeFromWindowsTimestamp(0) = %v", got)
+	}
+
+	now := time.Date(2021, 6, 1, 12, 30, 45, 123456700, time.UTC)
+	if got := TimeFromWindowsTimestamp(WindowsTimestamp(now)); !got.Equal(now) {
+		t.Errorf("round trip = %v, want %v", got, now)
+	}
+	if got := TimeFromWindowsTimestamp([]byte{1, 2, 3}); !got.IsZero() {
+		t.Errorf("TimeFromWindowsTimestamp(short) = %v", got)
+	}
+}
@@ -0,0 +1,136 @@
+package ntlmssp
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"fmt"
+)
+
+// Outcome of the checks of ValidateTranscript
+type TranscriptReport struct {
+	// Response type of the AUTHENTICATE_MESSAGE, 0 if anonymous
+	Level     AuthLevel
+	Anonymous bool
+	// Flags of the CHALLENGE_MESSAGE are a subset of the client's and the
+	// AUTHENTICATE_MESSAGE's of the server's, ignoring the flags only one
+	// side sets such as NEGOTIATE_VERSION and the target types
+	FlagsConsistent bool
+	// The NTLMv2 response carries the AV pairs of the challenge's target
+	// info unchanged, MsvAvTimestamp included. False for NTLMv1 responses.
+	TargetInfoEchoed bool
+	// The response was computed from the password and server challenge
+	NTProofValid bool
+	MICPresent   bool
+	MICValid     bool
+	// ExportedSessionKey derived from the password, nil if the proof
+	// does not match
+	SessionKey []byte
+}
+
+// Flags one side of the handshake sets without the other asking for them
+const unilateralFlags = serverOnlyFlags | NEGOTIATE_VERSION | NEGOTIATE_ANONYMOUS
+
+// Check a captured handshake offline: the flags of the three messages, the
+// echoed target info, the NT response against password and the MIC. Empty
+// user and domain default to the names of the AUTHENTICATE_MESSAGE. Only
+// messages that fail to parse are an error, failed checks are reported.
+func ValidateTranscript(negotiate, challenge, authenticate []byte, password, user, domain string) (*TranscriptReport, error) {
+	nm, err := NewNegotiateMsg(negotiate)
+	if err != nil {
+		return nil, err
+	}
+	cm, err := NewChallengeMsg(challenge)
+	if err != nil {
+		return nil, err
+	}
+	am, err := NewAuthenticateMsg(authenticate)
+	if err != nil {
+		return nil, err
+	}
+	if err := am.Validate(); err != nil {
+		return nil, err
+	}
+	if user == "" {
+		user = am.UserName()
+	}
+	if domain == "" {
+		domain = am.DomainName()
+	}
+
+	report := &TranscriptReport{
+		FlagsConsistent: cm.NegotiateFlags&^unilateralFlags&^nm.NegotiateFlags == 0 &&
+			am.NegotiateFlags&^unilateralFlags&^cm.NegotiateFlags == 0,
+	}
+	mic := am.MIC()
+	report.MICPresent = mic != nil && !bytes.Equal(mic, make([]byte, 16))
+
+	serverChallenge := cm.ServerChallenge[:]
+	resp := am.NtChallengeResponseBytes()
+	ntHash := NTHash(password)
+	var keyExchangeKey []byte
+	if am.IsAnonymous() {
+		report.Anonymous = true
+		report.NTProofValid = true
+		keyExchangeKey = make([]byte, 16)
+	} else {
+		level, ok := authLevels[am.ResponseType()]
+		if !ok {
+			return nil, fmt.Errorf("ntlmssp: unknown response type")
+		}
+		report.Level = level
+
+		lmresp := am.LmChallengeResponse()
+		switch level {
+		case AuthLevelNTLMv2:
+			ntlmv2Hash := ntowfv2(ntHash, user, domain)
+			report.NTProofValid = VerifyNTLMv2Response(ntlmv2Hash, serverChallenge, resp)
+			keyExchangeKey = hmacMd5(ntlmv2Hash, resp[:16])
+			report.TargetInfoEchoed = echoesTargetInfo(cm.TargetInfo(), resp)
+		case AuthLevelNTLMv1, AuthLevelNTLM2Session:
+			expected := ComputeNTv1Response(ntHash, serverChallenge)
+			if level == AuthLevelNTLM2Session {
+				_, expected = ComputeNTLM2SessionResponse(ntHash, serverChallenge, lmresp)
+			}
+			report.NTProofValid = hmac.Equal(expected, resp)
+			keyExchangeKey = KXKey(am.NegotiateFlags, md4Hash(ntHash), lmresp, serverChallenge, LMHash(password))
+		case AuthLevelLMv1:
+			report.NTProofValid = hmac.Equal(ComputeLMv1Response(LMHash(password), serverChallenge), lmresp)
+			keyExchangeKey = KXKey(am.NegotiateFlags, md4Hash(ntHash), lmresp, serverChallenge, LMHash(password))
+		}
+	}
+	if !report.NTProofValid {
+		return report, nil
+	}
+
+	sessionKey := keyExchangeKey
+	if am.NegotiateFlags&NEGOTIATE_EXPLICIT_KEY_EXCHANGE != 0 && len(am.EncryptedRandomSessionKey()) == 16 {
+		sessionKey = DecryptSessionKey(keyExchangeKey, am.EncryptedRandomSessionKey())
+	}
+	report.SessionKey = sessionKey
+	if report.MICPresent {
+		report.MICValid, _ = VerifyMIC(am, sessionKey, negotiate, challenge)
+	}
+	return report, nil
+}
+
+// Every AV pair of the challenge's target info is in the NTLMv2 response
+// with the same value. MsvAvFlags is left out, the client adds its own.
+func echoesTargetInfo(targetInfo, ntResponse []byte) bool {
+	_, blob, err := ParseNTLMv2ResponseBlob(ntResponse)
+	if err != nil {
+		return false
+	}
+	pairs, err := ParseAVPairsOrdered(targetInfo)
+	if err != nil {
+		return false
+	}
+	for _, pair := range pairs.List {
+		if pair.AvId == MsvAvEOL || pair.AvId == MsvAvFlags {
+			continue
+		}
+		if !bytes.Equal(blob.TargetInfo.Get(pair.AvId), pair.Value) {
+			return false
+		}
+	}
+	return true
+}
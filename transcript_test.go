@@ -0,0 +1,65 @@
+package ntlmssp
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestValidateTranscript(t *testing.T) {
+	server := NewServer()
+	server.SetCredentials("User", "Domain", NTHash("Password"))
+	client := NewClient(Credentials{User: "User", Domain: "Domain", Password: "Password"})
+	client.Workstation = "WS"
+
+	type1 := client.Negotiate()
+	type2, err := server.Challenge(type1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type3, err := client.ProcessChallenge(type2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	am, _ := NewAuthenticateMsg(type3)
+
+	tamper := func(msg []byte, f func([]byte)) []byte {
+		bs := append([]byte{}, msg...)
+		f(bs)
+		return bs
+	}
+	ntProofAt := int(am.NtChallengeResponseBufferOffset)
+	workstationAt := int(am.WorkstationBufferOffset)
+
+	cases := []struct {
+		name                       string
+		negotiate, challenge, auth []byte
+		password                   string
+		flags, echoed, proof, mic  bool
+	}{
+		{"good", type1, type2, type3, "Password", true, true, true, true},
+		{"wrong password", type1, type2, type3, "wrong", true, true, false, false},
+		{"NTProofStr", type1, type2, tamper(type3, func(bs []byte) { bs[ntProofAt] ^= 1 }), "Password", true, true, false, false},
+		{"workstation", type1, type2, tamper(type3, func(bs []byte) { bs[workstationAt] ^= 1 }), "Password", true, true, true, false},
+		{"challenge flags", type1, tamper(type2, func(bs []byte) {
+			binary.LittleEndian.PutUint32(bs[20:], binary.LittleEndian.Uint32(bs[20:])|NEGOTIATE_LM_SESSION_KEY)
+		}), type3, "Password", false, true, true, false},
+	}
+	for _, c := range cases {
+		report, err := ValidateTranscript(c.negotiate, c.challenge, c.auth, c.password, "", "")
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if report.Level != AuthLevelNTLMv2 || !report.MICPresent {
+			t.Errorf("%s: Level = %v, MICPresent = %v", c.name, report.Level, report.MICPresent)
+		}
+		if report.FlagsConsistent != c.flags || report.TargetInfoEchoed != c.echoed ||
+			report.NTProofValid != c.proof || report.MICValid != c.mic {
+			t.Errorf("%s: report %+v", c.name, report)
+		}
+	}
+
+	if _, err := ValidateTranscript(type1, type2, type3[:20], "Password", "", ""); err == nil {
+		t.Error("truncated authenticate message accepted")
+	}
+}
@@ -1,9 +1,10 @@
 package ntlmssp
 
 import (
+	"encoding/binary"
 	"fmt"
-	"math/bits"
-	"unsafe"
+	"io"
+	"os"
 )
 
 const NegotiateMsgPayloadOffset = 32
@@ -30,53 +31,69 @@ type NegotiateMsg struct {
 }
 
 func (nm NegotiateMsg) Display() {
-	fmt.Println("Negotiate Message (type1)")
-	fmt.Printf("Signature: %v (%s)\n", nm.Signature[:], nm.Signature[:])
-	fmt.Printf("MessageType: %x\n", nm.MessageType)
-	fmt.Printf("NegotiateFlags: %x\n", nm.NegotiateFlags)
-	fmt.Println("NegotiateFlags Details:")
-	DisplayNegotiateFlags(nm.NegotiateFlags)
-	fmt.Printf("DomainName: %s\n", nm.DomainName())
-	fmt.Printf("    (Len: %d  Offset: %d)\n", nm.DomainNameLen, nm.DomainNameBufferOffset)
-	fmt.Printf("Workstation: %s\n", nm.Workstation())
-	fmt.Printf("    (Len: %d  Offset: %d)\n\n", nm.WorkstationLen, nm.WorkstationBufferOffset)
+	nm.Dump(os.Stdout)
 }
 
+func (nm NegotiateMsg) Dump(w io.Writer) {
+	fmt.Fprintln(w, "Negotiate Message (type1)")
+	fmt.Fprintf(w, "Signature: %v (%s)\n", nm.Signature[:], nm.Signature[:])
+	fmt.Fprintf(w, "MessageType: %x\n", nm.MessageType)
+	fmt.Fprintf(w, "NegotiateFlags: %x\n", nm.NegotiateFlags)
+	fmt.Fprintln(w, "NegotiateFlags Details:")
+	WriteNegotiateFlags(w, nm.NegotiateFlags)
+	fmt.Fprintf(w, "DomainName: %s\n", nm.DomainName())
+	fmt.Fprintf(w, "    (Len: %d  Offset: %d)\n", nm.DomainNameLen, nm.DomainNameBufferOffset)
+	fmt.Fprintf(w, "Workstation: %s\n", nm.Workstation())
+	fmt.Fprintf(w, "    (Len: %d  Offset: %d)\n\n", nm.WorkstationLen, nm.WorkstationBufferOffset)
+}
+
+// Deprecated: use Bytes, or MarshalOrder for '>'
 func (nm NegotiateMsg) Marshal(endian byte) []byte {
-	bs := []byte{}
+	return nm.MarshalOrder(byteOrder(endian))
+}
 
-	// NTLMSSP is little endian
-	if endian == '>' {
-		nm.MessageType = bits.ReverseBytes32(nm.MessageType)
-		nm.NegotiateFlags = bits.ReverseBytes32(nm.NegotiateFlags)
+// The message on the wire, which is always little endian
+func (nm NegotiateMsg) Bytes() []byte {
+	return nm.MarshalOrder(binary.LittleEndian)
+}
 
-		nm.DomainNameLen = bits.ReverseBytes16(nm.DomainNameLen)
-		nm.DomainNameMaxLen = bits.ReverseBytes16(nm.DomainNameMaxLen)
-		nm.DomainNameBufferOffset = bits.ReverseBytes32(nm.DomainNameBufferOffset)
+// The message with its integer fields in order
+func (nm NegotiateMsg) MarshalOrder(order binary.ByteOrder) []byte {
+	return nm.appendTo(make([]byte, 0, NegotiateMsgPayloadOffset+len(nm.Payload)), order)
+}
 
-		nm.WorkstationLen = bits.ReverseBytes16(nm.WorkstationLen)
-		nm.WorkstationMaxLen = bits.ReverseBytes16(nm.WorkstationMaxLen)
-		nm.WorkstationBufferOffset = bits.ReverseBytes32(nm.WorkstationBufferOffset)
-	}
+// Append the wire form of the message to dst, see ChallengeMsg.MarshalTo
+func (nm NegotiateMsg) MarshalTo(dst []byte) []byte {
+	return nm.appendTo(dst, binary.LittleEndian)
+}
 
+func (nm NegotiateMsg) appendTo(bs []byte, order binary.ByteOrder) []byte {
 	bs = append(bs, nm.Signature[:]...)
 
-	bs = append(bs, (*(*[4]byte)(unsafe.Pointer(&nm.MessageType)))[:]...)
-	bs = append(bs, (*(*[4]byte)(unsafe.Pointer(&nm.NegotiateFlags)))[:]...)
+	bs = appendUint32(bs, order, nm.MessageType)
+	bs = appendUint32(bs, order, nm.NegotiateFlags)
 
-	bs = append(bs, (*(*[2]byte)(unsafe.Pointer(&nm.DomainNameLen)))[:]...)
-	bs = append(bs, (*(*[2]byte)(unsafe.Pointer(&nm.DomainNameMaxLen)))[:]...)
-	bs = append(bs, (*(*[4]byte)(unsafe.Pointer(&nm.DomainNameBufferOffset)))[:]...)
+	bs = appendUint16(bs, order, nm.DomainNameLen)
+	bs = appendUint16(bs, order, nm.DomainNameMaxLen)
+	bs = appendUint32(bs, order, nm.DomainNameBufferOffset)
 
-	bs = append(bs, (*(*[2]byte)(unsafe.Pointer(&nm.WorkstationLen)))[:]...)
-	bs = append(bs, (*(*[2]byte)(unsafe.Pointer(&nm.WorkstationMaxLen)))[:]...)
-	bs = append(bs, (*(*[4]byte)(unsafe.Pointer(&nm.WorkstationBufferOffset)))[:]...)
+	bs = appendUint16(bs, order, nm.WorkstationLen)
+	bs = appendUint16(bs, order, nm.WorkstationMaxLen)
+	bs = appendUint32(bs, order, nm.WorkstationBufferOffset)
 	bs = append(bs, nm.Payload...)
 
 	return bs
 }
 
-func (nm *NegotiateMsg) UnMarshal(bs []byte) {
+func (nm *NegotiateMsg) UnMarshal(bs []byte) error {
+	if len(bs) < NegotiateMsgPayloadOffset {
+		return fmt.Errorf("%w: negotiate message of %d bytes", ErrMessageTooShort, len(bs))
+	}
+
+	if err := checkMessageType(bs, 1); err != nil {
+		return err
+	}
+
 	copy(nm.Signature[:], bs[:8])
 	nm.MessageType = uint32(bytes2Uint(bs[8:12], '<'))
 	nm.NegotiateFlags = uint32(bytes2Uint(bs[12:16], '<'))
@@ -89,50 +106,95 @@ func (nm *NegotiateMsg) UnMarshal(bs []byte) {
 	nm.WorkstationMaxLen = uint16(bytes2Uint(bs[26:28], '<'))
 	nm.WorkstationBufferOffset = uint32(bytes2Uint(bs[28:32], '<'))
 
-	nm.offset = NegotiateMsgPayloadOffset
-
-	plen := 0
-	if nm.DomainNameBufferOffset != 0 && nm.DomainNameLen != 0 {
-		plen += int(nm.DomainNameLen)
+	if uint64(nm.DomainNameBufferOffset)+uint64(nm.DomainNameLen) > uint64(len(bs)) {
+		return fmt.Errorf("%w: DomainName (offset %d, len %d) exceeds message length %d", ErrMalformedMessage,
+			nm.DomainNameBufferOffset, nm.DomainNameLen, len(bs))
 	}
-	if nm.WorkstationBufferOffset != 0 && nm.WorkstationLen != 0 {
-		plen += int(nm.WorkstationLen)
+	if uint64(nm.WorkstationBufferOffset)+uint64(nm.WorkstationLen) > uint64(len(bs)) {
+		return fmt.Errorf("%w: Workstation (offset %d, len %d) exceeds message length %d", ErrMalformedMessage,
+			nm.WorkstationBufferOffset, nm.WorkstationLen, len(bs))
 	}
 
+	// keep the payload verbatim, up to the end of the last field
+	end := uint64(NegotiateMsgPayloadOffset)
 	if nm.NegotiateFlags&NEGOTIATE_VERSION != 0 {
-		plen += 8
+		end += 8
+	}
+	for _, f := range [][2]uint64{
+		{uint64(nm.DomainNameBufferOffset), uint64(nm.DomainNameLen)},
+		{uint64(nm.WorkstationBufferOffset), uint64(nm.WorkstationLen)},
+	} {
+		if f[1] == 0 {
+			continue
+		}
+		if f[0] < NegotiateMsgPayloadOffset {
+			return fmt.Errorf("%w: buffer offset %d inside the negotiate header", ErrMalformedMessage, f[0])
+		}
+		if f[0]+f[1] > end {
+			end = f[0] + f[1]
+		}
+	}
+
+	if err := checkMessageSize(end); err != nil {
+		return err
+	}
+	if end > uint64(len(bs)) {
+		return fmt.Errorf("%w: negotiate payload (%d bytes) exceeds message length %d", ErrMalformedMessage, end-NegotiateMsgPayloadOffset, len(bs))
 	}
 
-	nm.Payload = make([]byte, plen)
-	copy(nm.Payload, bs[NegotiateMsgPayloadOffset:NegotiateMsgPayloadOffset+uint32(plen)])
+	nm.Payload = make([]byte, end-NegotiateMsgPayloadOffset)
+	copy(nm.Payload, bs[NegotiateMsgPayloadOffset:end])
+	nm.offset = uint32(end)
+	return nil
 }
 
-func NewNegotiateMsg(bs []byte) *NegotiateMsg {
+// Check the payload fields lie after the header and Version, within the
+// message and don't overlap
+func (nm NegotiateMsg) Validate() error {
+	start := uint64(NegotiateMsgPayloadOffset)
+	if nm.NegotiateFlags&NEGOTIATE_VERSION != 0 {
+		start += 8
+	}
+	return validateBuffers(start, NegotiateMsgPayloadOffset+uint64(len(nm.Payload)),
+		securityBuffer{"DomainName", nm.DomainNameLen, nm.DomainNameMaxLen, nm.DomainNameBufferOffset},
+		securityBuffer{"Workstation", nm.WorkstationLen, nm.WorkstationMaxLen, nm.WorkstationBufferOffset},
+	)
+}
+
+func NewNegotiateMsg(bs []byte) (*NegotiateMsg, error) {
 	nm := NegotiateMsg{}
 	if bs == nil {
 		nm.Signature = [8]byte{'N', 'T', 'L', 'M', 'S', 'S', 'P', 0}
 		nm.MessageType = 0x01
 		nm.offset = NegotiateMsgPayloadOffset
-	} else {
-		nm.UnMarshal(bs)
+	} else if err := nm.UnMarshal(bs); err != nil {
+		return nil, err
 	}
-	return &nm
+	return &nm, nil
 }
 
-// Must be OEM charset
+// Must be OEM charset, the NEGOTIATE_UNICODE_CHARSET flag has not been
+// negotiated yet when the type1 message is sent. Empty unless
+// NEGOTIATE_OEM_DOMAIN_SUPPLIED is set.
 func (nm NegotiateMsg) DomainName() string {
-	if nm.DomainNameLen == 0 {
+	if nm.DomainNameLen == 0 || nm.NegotiateFlags&NEGOTIATE_OEM_DOMAIN_SUPPLIED == 0 {
 		return ""
 	}
-	return string(nm.Payload[nm.DomainNameBufferOffset-NegotiateMsgPayloadOffset : nm.DomainNameBufferOffset-NegotiateMsgPayloadOffset+uint32(nm.DomainNameLen)])
+	return string(payloadField(nm.Payload, NegotiateMsgPayloadOffset, nm.DomainNameBufferOffset, nm.DomainNameLen))
+}
+
+// Same as DomainName
+func (nm NegotiateMsg) Domain() string {
+	return nm.DomainName()
 }
 
-// Must be OEM charset
+// Must be OEM charset, empty unless NEGOTIATE_OEM_WORKSTATION_SUPPLIED is
+// set
 func (nm NegotiateMsg) Workstation() string {
-	if nm.WorkstationLen == 0 {
+	if nm.WorkstationLen == 0 || nm.NegotiateFlags&NEGOTIATE_OEM_WORKSTATION_SUPPLIED == 0 {
 		return ""
 	}
-	return string(nm.Payload[nm.WorkstationBufferOffset-NegotiateMsgPayloadOffset : nm.WorkstationBufferOffset-NegotiateMsgPayloadOffset+uint32(nm.WorkstationLen)])
+	return string(payloadField(nm.Payload, NegotiateMsgPayloadOffset, nm.WorkstationBufferOffset, nm.WorkstationLen))
 }
 
 func (nm *NegotiateMsg) SetDomainName(dname []byte) {
@@ -164,14 +226,35 @@ func (nm *NegotiateMsg) SetWorkstation(ws []byte) {
 }
 
 func (nm NegotiateMsg) Version() []byte {
-	if nm.NegotiateFlags&NEGOTIATE_VERSION != 0 {
-		return nm.Payload[:8]
-	} else {
+	if nm.NegotiateFlags&NEGOTIATE_VERSION == 0 || len(nm.Payload) < 8 {
 		return nil
 	}
+	return nm.Payload[:8]
+}
+
+// Set NEGOTIATE_VERSION and the Version field, it must be called before
+// any payload field is set
+func (nm *NegotiateMsg) SetVersion(v Version) {
+	if nm.DomainNameLen != 0 || nm.WorkstationLen != 0 {
+		panic("Version field must be set before the payload fields")
+	}
+
+	nm.NegotiateFlags |= NEGOTIATE_VERSION
+	version := v.Marshal()
+	if len(nm.Payload) == 0 {
+		nm.Payload = append(nm.Payload, version[:]...)
+	} else {
+		copy(nm.Payload[:8], version[:])
+	}
+	nm.offset = NegotiateMsgPayloadOffset + uint32(len(nm.Payload))
 }
 
+// Back to the state of NewNegotiateMsg(nil), see ChallengeMsg.Reset
 func (nm *NegotiateMsg) Reset() {
-	nm.Payload = nil
-	nm.offset = NegotiateMsgPayloadOffset
+	*nm = NegotiateMsg{
+		Signature:   [8]byte{'N', 'T', 'L', 'M', 'S', 'S', 'P', 0},
+		MessageType: 0x01,
+		Payload:     nm.Payload[:0],
+		offset:      NegotiateMsgPayloadOffset,
+	}
 }
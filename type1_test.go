@@ -0,0 +1,173 @@
+package ntlmssp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestNegotiateMsg_RoundTrip(t *testing.T) {
+	version := []byte{0x0a, 0x00, 0x63, 0x45, 0x00, 0x00, 0x00, 0x0f}
+
+	type1, _ := NewNegotiateMsg(nil)
+	type1.NegotiateFlags |= NEGOTIATE_UNICODE_CHARSET |
+		NEGOTIATE_REQUEST_TARGET_NAME |
+		NEGOTIATE_NTLM |
+		NEGOTIATE_EXTENDED_SESSION_SECURITY |
+		NEGOTIATE_VERSION
+	// Version is the first field of the payload
+	type1.Payload = append(type1.Payload, version...)
+	type1.offset += 8
+	type1.SetDomainName([]byte("CC.LAB"))
+	type1.SetWorkstation([]byte("WIN-123456"))
+
+	bs := type1.Marshal('<')
+	parsed, err := NewNegotiateMsg(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.Signature != type1.Signature || parsed.MessageType != 1 {
+		t.Errorf("bad header: %v %d", parsed.Signature, parsed.MessageType)
+	}
+	if parsed.NegotiateFlags != type1.NegotiateFlags {
+		t.Errorf("NegotiateFlags = %x, want %x", parsed.NegotiateFlags, type1.NegotiateFlags)
+	}
+	if parsed.NegotiateFlags&(NEGOTIATE_OEM_DOMAIN_SUPPLIED|NEGOTIATE_OEM_WORKSTATION_SUPPLIED) !=
+		NEGOTIATE_OEM_DOMAIN_SUPPLIED|NEGOTIATE_OEM_WORKSTATION_SUPPLIED {
+		t.Errorf("supplied flags not set: %x", parsed.NegotiateFlags)
+	}
+	if parsed.DomainName() != "CC.LAB" {
+		t.Errorf("DomainName = %q", parsed.DomainName())
+	}
+	if parsed.Workstation() != "WIN-123456" {
+		t.Errorf("Workstation = %q", parsed.Workstation())
+	}
+	if !bytes.Equal(parsed.Version(), version) {
+		t.Errorf("Version = %x, want %x", parsed.Version(), version)
+	}
+	if !bytes.Equal(parsed.Marshal('<'), bs) {
+		t.Errorf("Marshal(UnMarshal(x)) != x")
+	}
+}
+
+func TestNegotiateMsg_UnMarshalTruncated(t *testing.T) {
+	type1, _ := NewNegotiateMsg(nil)
+	type1.SetDomainName([]byte("CC.LAB"))
+	bs := type1.Marshal('<')
+
+	for _, c := range [][]byte{{}, bs[:NegotiateMsgPayloadOffset-1], bs[:len(bs)-1]} {
+		if _, err := NewNegotiateMsg(c); err == nil {
+			t.Errorf("expected error for %d bytes", len(c))
+		}
+	}
+}
+
+func TestNegotiateMsg_SetVersion(t *testing.T) {
+	v := Version{MajorVersion: 10, MinorVersion: 0, BuildNumber: 19041}
+	bs := v.Marshal()
+	if got, want := hex.EncodeToString(bs[:]), "0a00614a0000000f"; got != want {
+		t.Errorf("Marshal = %s, want %s", got, want)
+	}
+	parsed, err := ReadVersionStruct(bs[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.ProductMajorVersion != 10 || parsed.ProductMinorVersion != 0 || parsed.ProductBuild != 19041 || parsed.NTLMRevisionCurrent != 0x0f {
+		t.Errorf("ReadVersionStruct = %+v", parsed)
+	}
+
+	type1, _ := NewNegotiateMsg(nil)
+	type1.SetVersion(v)
+	type1.SetWorkstation([]byte("WS"))
+	msg, err := NewNegotiateMsg(type1.Marshal('<'))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msg.Version(), bs[:]) || msg.Workstation() != "WS" {
+		t.Errorf("Version = %x, Workstation = %q", msg.Version(), msg.Workstation())
+	}
+
+	type3, _ := NewAuthenticateMsg(nil)
+	type3.ReserveMIC()
+	type3.SetVersion(v)
+	type3.SetUserName([]byte("User"))
+	am, err := NewAuthenticateMsg(type3.Marshal('<'))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(am.Version(), bs[:]) || am.MIC() == nil || am.UserName() != "User" {
+		t.Errorf("Version = %x, MIC = %x, UserName = %q", am.Version(), am.MIC(), am.UserName())
+	}
+}
+
+func TestNegotiateMsg_RoundTripCorpus(t *testing.T) {
+	corpus := []string{
+		// MS-NLMP 4.2.4.3
+		"4e544c4d5353500001000000338202e20000000000000000000000000000000006007017" + "0000000f",
+		// OEM domain and workstation
+		"4e544c4d53535000010000000732000006000600230000000300030020000000" + hex.EncodeToString([]byte("WKSDOMAIN")),
+	}
+	for _, h := range corpus {
+		bs, _ := hex.DecodeString(h)
+		type1, err := NewNegotiateMsg(bs)
+		if err != nil {
+			t.Errorf("%s: %v", h, err)
+			continue
+		}
+		if got := type1.Marshal('<'); !bytes.Equal(got, bs) {
+			t.Errorf("round trip\n got %x\nwant %x", got, bs)
+		}
+	}
+}
+
+func TestNegotiateMsg_Domain(t *testing.T) {
+	type1, _ := NewNegotiateMsg(nil)
+	type1.NegotiateFlags = NEGOTIATE_OEM_CHARSET | NEGOTIATE_NTLM
+	type1.SetDomainName([]byte("DOMAIN"))
+	type1.SetWorkstation([]byte("WKS"))
+
+	parsed, err := NewNegotiateMsg(type1.Marshal('<'))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Domain() != "DOMAIN" || parsed.Workstation() != "WKS" {
+		t.Errorf("Domain = %q, Workstation = %q", parsed.Domain(), parsed.Workstation())
+	}
+
+	// the fields are ignored without the supplied flags
+	parsed.NegotiateFlags &^= NEGOTIATE_OEM_DOMAIN_SUPPLIED | NEGOTIATE_OEM_WORKSTATION_SUPPLIED
+	if parsed.Domain() != "" || parsed.Workstation() != "" {
+		t.Errorf("without supplied flags: Domain = %q, Workstation = %q", parsed.Domain(), parsed.Workstation())
+	}
+}
+
+func TestNegotiateMsg_Validate(t *testing.T) {
+	valid, _ := NewNegotiateMsg(nil)
+	valid.NegotiateFlags = NEGOTIATE_OEM_DOMAIN_SUPPLIED | NEGOTIATE_OEM_WORKSTATION_SUPPLIED
+	valid.SetDomainName([]byte("DOMAIN"))
+	valid.SetWorkstation([]byte("WS"))
+	if err := valid.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, patch := range map[string]func(nm *NegotiateMsg){
+		"overlap": func(nm *NegotiateMsg) { nm.WorkstationBufferOffset = nm.DomainNameBufferOffset + 2 },
+		"maxlen":  func(nm *NegotiateMsg) { nm.DomainNameMaxLen = nm.DomainNameLen - 1 },
+		"header":  func(nm *NegotiateMsg) { nm.DomainNameBufferOffset = 24 },
+		"version": func(nm *NegotiateMsg) { nm.NegotiateFlags |= NEGOTIATE_VERSION },
+		"end": func(nm *NegotiateMsg) {
+			nm.WorkstationBufferOffset = NegotiateMsgPayloadOffset + uint32(len(nm.Payload)) - 1
+		},
+	} {
+		nm, err := NewNegotiateMsg(valid.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		patch(nm)
+		if err := nm.Validate(); !errors.Is(err, ErrMalformedMessage) {
+			t.Errorf("%s: Validate = %v, want ErrMalformedMessage", name, err)
+		}
+	}
+}
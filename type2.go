@@ -1,14 +1,13 @@
 package ntlmssp
 
 import (
-	"crypto/rand"
 	"encoding/binary"
 	"fmt"
-	"math/bits"
+	"io"
+	"os"
 	"sort"
 	"strings"
 	"time"
-	"unsafe"
 )
 
 const ChallengeMsgPayloadOffset = 48
@@ -23,7 +22,8 @@ type ChallengeMsg struct {
 
 	NegotiateFlags  uint32
 	ServerChallenge [8]byte
-	Reserved        [8]byte
+	// Server context handle with NEGOTIATE_LOCAL_CALL, zero otherwise
+	Reserved [8]byte
 
 	TargetInfoLen          uint16
 	TargetInfoMaxLen       uint16
@@ -37,62 +37,86 @@ type ChallengeMsg struct {
 }
 
 func (cm ChallengeMsg) Display() {
-	fmt.Println("Challenge Message (type2)")
-	fmt.Printf("Signature: %v (%s)\n", cm.Signature[:], cm.Signature[:])
-	fmt.Printf("MessageType: %x\n", cm.MessageType)
-	fmt.Printf("TargetName: %s\n", cm.TargetName())
-	fmt.Printf("    (Len: %d  Offset: %d)\n", cm.TargetNameLen, cm.TargetNameBufferOffset)
+	cm.Dump(os.Stdout)
+}
+
+func (cm ChallengeMsg) Dump(w io.Writer) {
+	fmt.Fprintln(w, "Challenge Message (type2)")
+	fmt.Fprintf(w, "Signature: %v (%s)\n", cm.Signature[:], cm.Signature[:])
+	fmt.Fprintf(w, "MessageType: %x\n", cm.MessageType)
+	charset := "OEM"
+	if cm.NegotiateFlags&NEGOTIATE_UNICODE_CHARSET != 0 {
+		charset = "Unicode"
+	}
+	fmt.Fprintf(w, "TargetName: %s\n", cm.TargetName())
+	fmt.Fprintf(w, "    (Len: %d  Offset: %d  Charset: %s  Raw: %x)\n", cm.TargetNameLen, cm.TargetNameBufferOffset, charset,
+		payloadField(cm.Payload, ChallengeMsgPayloadOffset, cm.TargetNameBufferOffset, cm.TargetNameLen))
 
-	fmt.Printf("NegotiateFlags: %x\n", cm.NegotiateFlags)
-	fmt.Println("NegotiateFlags Details:")
-	DisplayNegotiateFlags(cm.NegotiateFlags)
+	fmt.Fprintf(w, "NegotiateFlags: %x\n", cm.NegotiateFlags)
+	fmt.Fprintln(w, "NegotiateFlags Details:")
+	WriteNegotiateFlags(w, cm.NegotiateFlags)
 
-	fmt.Printf("ServerChallenge: %x\n", cm.ServerChallenge)
+	fmt.Fprintf(w, "ServerChallenge: %x\n", cm.ServerChallenge)
 
 	tinfo := ParseAVPair(cm.TargetInfo())
-	fmt.Printf("TargetInfo: (Len: %d  Offset: %d)\n", cm.TargetInfoLen, cm.TargetInfoBufferOffset)
+	fmt.Fprintf(w, "TargetInfo: (Len: %d  Offset: %d)\n", cm.TargetInfoLen, cm.TargetInfoBufferOffset)
 	for k, v := range tinfo {
-		fmt.Printf("    %s: %v\n", k, v)
+		fmt.Fprintf(w, "    %s: %v\n", k, v)
 	}
-	fmt.Println()
+	fmt.Fprintln(w)
 }
 
+// Deprecated: use Bytes, or MarshalOrder for '>'
 func (cm ChallengeMsg) Marshal(endian byte) []byte {
-	bs := []byte{}
-	if endian == '>' {
-		cm.MessageType = bits.ReverseBytes32(cm.MessageType)
-		cm.NegotiateFlags = bits.ReverseBytes32(cm.NegotiateFlags)
+	return cm.MarshalOrder(byteOrder(endian))
+}
 
-		cm.TargetNameLen = bits.ReverseBytes16(cm.TargetNameLen)
-		cm.TargetNameMaxLen = bits.ReverseBytes16(cm.TargetNameMaxLen)
-		cm.TargetNameBufferOffset = bits.ReverseBytes32(cm.TargetNameBufferOffset)
+// The message on the wire, which is always little endian
+func (cm ChallengeMsg) Bytes() []byte {
+	return cm.MarshalOrder(binary.LittleEndian)
+}
 
-		cm.TargetInfoLen = bits.ReverseBytes16(cm.TargetInfoLen)
-		cm.TargetInfoMaxLen = bits.ReverseBytes16(cm.TargetInfoMaxLen)
-		cm.TargetInfoBufferOffset = bits.ReverseBytes32(cm.TargetInfoBufferOffset)
-	}
+// The message with its integer fields in order
+func (cm ChallengeMsg) MarshalOrder(order binary.ByteOrder) []byte {
+	return cm.appendTo(make([]byte, 0, ChallengeMsgPayloadOffset+len(cm.Payload)), order)
+}
 
+// Append the wire form of the message to dst, which can be reused across
+// messages to avoid allocations
+func (cm ChallengeMsg) MarshalTo(dst []byte) []byte {
+	return cm.appendTo(dst, binary.LittleEndian)
+}
+
+func (cm ChallengeMsg) appendTo(bs []byte, order binary.ByteOrder) []byte {
 	bs = append(bs, cm.Signature[:]...)
 
-	bs = append(bs, (*(*[4]byte)(unsafe.Pointer(&cm.MessageType)))[:]...)
+	bs = appendUint32(bs, order, cm.MessageType)
 
-	bs = append(bs, (*(*[2]byte)(unsafe.Pointer(&cm.TargetNameLen)))[:]...)
-	bs = append(bs, (*(*[2]byte)(unsafe.Pointer(&cm.TargetNameMaxLen)))[:]...)
-	bs = append(bs, (*(*[4]byte)(unsafe.Pointer(&cm.TargetNameBufferOffset)))[:]...)
+	bs = appendUint16(bs, order, cm.TargetNameLen)
+	bs = appendUint16(bs, order, cm.TargetNameMaxLen)
+	bs = appendUint32(bs, order, cm.TargetNameBufferOffset)
 
-	bs = append(bs, (*(*[4]byte)(unsafe.Pointer(&cm.NegotiateFlags)))[:]...)
+	bs = appendUint32(bs, order, cm.NegotiateFlags)
 	bs = append(bs, cm.ServerChallenge[:]...)
 	bs = append(bs, cm.Reserved[:]...)
 
-	bs = append(bs, (*(*[2]byte)(unsafe.Pointer(&cm.TargetInfoLen)))[:]...)
-	bs = append(bs, (*(*[2]byte)(unsafe.Pointer(&cm.TargetInfoMaxLen)))[:]...)
-	bs = append(bs, (*(*[4]byte)(unsafe.Pointer(&cm.TargetInfoBufferOffset)))[:]...)
+	bs = appendUint16(bs, order, cm.TargetInfoLen)
+	bs = appendUint16(bs, order, cm.TargetInfoMaxLen)
+	bs = appendUint32(bs, order, cm.TargetInfoBufferOffset)
 	bs = append(bs, cm.Payload...)
 
 	return bs
 }
 
-func (cm *ChallengeMsg) UnMarshal(bs []byte) {
+func (cm *ChallengeMsg) UnMarshal(bs []byte) error {
+	if len(bs) < ChallengeMsgPayloadOffset {
+		return fmt.Errorf("%w: challenge message of %d bytes", ErrMessageTooShort, len(bs))
+	}
+
+	if err := checkMessageType(bs, 2); err != nil {
+		return err
+	}
+
 	copy(cm.Signature[:], bs[:8])
 	cm.MessageType = uint32(bytes2Uint(bs[8:12], '<'))
 
@@ -107,58 +131,112 @@ func (cm *ChallengeMsg) UnMarshal(bs []byte) {
 	cm.TargetInfoLen = uint16(bytes2Uint(bs[40:42], '<'))
 	cm.TargetInfoMaxLen = uint16(bytes2Uint(bs[42:44], '<'))
 	cm.TargetInfoBufferOffset = uint32(bytes2Uint(bs[44:48], '<'))
-	cm.offset = ChallengeMsgPayloadOffset
-
-	plen := 0
-	if cm.TargetNameBufferOffset != 0 && cm.TargetNameLen != 0 {
-		plen += int(cm.TargetNameLen)
+	if uint64(cm.TargetNameBufferOffset)+uint64(cm.TargetNameLen) > uint64(len(bs)) {
+		return fmt.Errorf("%w: TargetName (offset %d, len %d) exceeds message length %d", ErrMalformedMessage,
+			cm.TargetNameBufferOffset, cm.TargetNameLen, len(bs))
 	}
-	if cm.TargetInfoBufferOffset != 0 && cm.TargetInfoLen != 0 {
-		plen += int(cm.TargetInfoLen)
+	if uint64(cm.TargetInfoBufferOffset)+uint64(cm.TargetInfoLen) > uint64(len(bs)) {
+		return fmt.Errorf("%w: TargetInfo (offset %d, len %d) exceeds message length %d", ErrMalformedMessage,
+			cm.TargetInfoBufferOffset, cm.TargetInfoLen, len(bs))
 	}
 
+	// keep the payload verbatim, up to the end of the last field
+	end := uint64(ChallengeMsgPayloadOffset)
 	if cm.NegotiateFlags&NEGOTIATE_VERSION != 0 {
-		plen += 8
+		end += 8
+	}
+	for _, f := range [][2]uint64{
+		{uint64(cm.TargetNameBufferOffset), uint64(cm.TargetNameLen)},
+		{uint64(cm.TargetInfoBufferOffset), uint64(cm.TargetInfoLen)},
+	} {
+		if f[1] == 0 {
+			continue
+		}
+		if f[0] < ChallengeMsgPayloadOffset {
+			return fmt.Errorf("%w: buffer offset %d inside the challenge header", ErrMalformedMessage, f[0])
+		}
+		if f[0]+f[1] > end {
+			end = f[0] + f[1]
+		}
+	}
+
+	if err := checkMessageSize(end); err != nil {
+		return err
+	}
+	if end > uint64(len(bs)) {
+		return fmt.Errorf("%w: challenge payload (%d bytes) exceeds message length %d", ErrMalformedMessage, end-ChallengeMsgPayloadOffset, len(bs))
 	}
 
-	cm.Payload = make([]byte, plen)
-	copy(cm.Payload, bs[ChallengeMsgPayloadOffset:ChallengeMsgPayloadOffset+uint32(plen)])
+	cm.Payload = make([]byte, end-ChallengeMsgPayloadOffset)
+	copy(cm.Payload, bs[ChallengeMsgPayloadOffset:end])
+	cm.offset = uint32(end)
+	return nil
 }
 
-func NewChallengeMsg(bs []byte) *ChallengeMsg {
+// Check TargetName and TargetInfo lie after the header and Version,
+// within the message and don't overlap
+func (cm ChallengeMsg) Validate() error {
+	start := uint64(ChallengeMsgPayloadOffset)
+	if cm.NegotiateFlags&NEGOTIATE_VERSION != 0 {
+		start += 8
+	}
+	return validateBuffers(start, ChallengeMsgPayloadOffset+uint64(len(cm.Payload)),
+		securityBuffer{"TargetName", cm.TargetNameLen, cm.TargetNameMaxLen, cm.TargetNameBufferOffset},
+		securityBuffer{"TargetInfo", cm.TargetInfoLen, cm.TargetInfoMaxLen, cm.TargetInfoBufferOffset},
+	)
+}
+
+func NewChallengeMsg(bs []byte) (*ChallengeMsg, error) {
 	cm := ChallengeMsg{}
 	if bs == nil {
 		cm.Signature = [8]byte{'N', 'T', 'L', 'M', 'S', 'S', 'P', 0}
 		cm.MessageType = 0x02
 		cm.offset = ChallengeMsgPayloadOffset
-	} else {
-		cm.UnMarshal(bs)
+	} else if err := cm.UnMarshal(bs); err != nil {
+		return nil, err
 	}
-	return &cm
+	return &cm, nil
 }
 
+// Empty, like TargetInfo nil, when the field lies outside the payload
 func (cm ChallengeMsg) TargetName() string {
 	if cm.TargetNameLen == 0 {
 		return ""
 	}
-	tname := cm.Payload[cm.TargetNameBufferOffset-ChallengeMsgPayloadOffset : cm.TargetNameBufferOffset-ChallengeMsgPayloadOffset+uint32(cm.TargetNameLen)]
+	tname := payloadField(cm.Payload, ChallengeMsgPayloadOffset, cm.TargetNameBufferOffset, cm.TargetNameLen)
 
-	if cm.NegotiateFlags&1 == 1 {
+	if cm.NegotiateFlags&NEGOTIATE_UNICODE_CHARSET != 0 {
 		return bytes2StringUTF16(tname)
 	}
 	return string(tname)
 }
 
+// Kind of TargetName by the NEGOTIATE_TARGET_TYPE_* flags: "Domain",
+// "Server", "Share" or "" if none is set
+func (cm ChallengeMsg) TargetType() string {
+	switch {
+	case cm.NegotiateFlags&NEGOTIATE_TARGET_TYPE_DOMAIN != 0:
+		return "Domain"
+	case cm.NegotiateFlags&NEGOTIATE_TARGET_TYPE_SERVER != 0:
+		return "Server"
+	case cm.NegotiateFlags&NEGOTIATE_TARGET_TYPE_SHARE != 0:
+		return "Share"
+	}
+	return ""
+}
+
+// Panics if TargetName is already set, see ReplaceTargetName
 func (cm *ChallengeMsg) SetTargetName(tname []byte) {
 	if cm.TargetNameLen != 0 {
 		panic("Can't set TargetName field repeatedly")
 	}
 
 	if cm.NegotiateFlags&NEGOTIATE_UNICODE_CHARSET != 0 {
-		cm.TargetNameLen = uint16(2 * len(tname))
+		encoded := encodeUTF16LE(tname)
+		cm.TargetNameLen = uint16(len(encoded))
 		cm.TargetNameMaxLen = cm.TargetNameLen
 		cm.TargetNameBufferOffset = cm.offset
-		cm.Payload = append(cm.Payload, encodeUTF16LE(tname)...)
+		cm.Payload = append(cm.Payload, encoded...)
 	} else {
 		cm.TargetNameLen = uint16(len(tname))
 		cm.TargetNameMaxLen = cm.TargetNameLen
@@ -173,83 +251,238 @@ func (cm ChallengeMsg) TargetInfo() []byte {
 	if cm.TargetInfoLen == 0 {
 		return nil
 	}
-	return cm.Payload[cm.TargetInfoBufferOffset-ChallengeMsgPayloadOffset : cm.TargetInfoBufferOffset-ChallengeMsgPayloadOffset+uint32(cm.TargetInfoLen)]
+	return payloadField(cm.Payload, ChallengeMsgPayloadOffset, cm.TargetInfoBufferOffset, cm.TargetInfoLen)
 }
 
-func (cm *ChallengeMsg) SetTargetInfo(tinfo map[string]interface{}) {
+// Order in which SetTargetInfo writes the AV pairs, the same as Windows
+// servers: NetBIOS domain and computer, DNS domain, computer and tree, then
+// the rest by AvId. MsvAvEOL always comes last.
+var targetInfoOrder = []AvPairType{
+	MsvAvNbDomainName, MsvAvNbComputerName, MsvAvDnsDomainName, MsvAvDnsComputerName, MsvAvDnsTreeName,
+	MsvAvFlags, MsvAvTimestamp, MsAvRestrictions, MsvAvTargetName, MsvChannelBindings,
+}
+
+// Names are strings, MsvAvFlags and MsvAvChannelBindings []byte,
+// MsvAvTimestamp time.Time or []byte and MsvAvSingleHost SingleHostData or
+// []byte, so the result of ParseAVPair can be set again. Unknown keys are
+// skipped, a value of the wrong type is an error and nothing is set.
+func (cm *ChallengeMsg) SetTargetInfo(tinfo map[string]interface{}) error {
 	if cm.TargetInfoLen != 0 {
 		panic("Can't set TargetInfo field repeatedly")
 	}
+	bs, err := marshalTargetInfo(tinfo)
+	if err != nil {
+		return err
+	}
+	return cm.setTargetInfo(bs)
+}
 
-	cm.NegotiateFlags |= NEGOTIATE_TARGET_INFO
-
+// AV pairs of the SetTargetInfo map in targetInfoOrder
+func marshalTargetInfo(tinfo map[string]interface{}) ([]byte, error) {
 	bs := []byte{}
-	for k, v := range tinfo {
-		if avIdsRev[k] == 0 {
+	for _, id := range targetInfoOrder {
+		v, ok := tinfo[avIds[uint16(id)]]
+		if !ok {
 			continue
 		}
-		bs = append(bs, avIdsRev[k], 0)
-
-		if avIdsRev[k] != 6 && avIdsRev[k] != 7 && avIdsRev[k] != 8 && avIdsRev[k] != 10 {
-			length := len(v.(string)) * 2
-			bs = append(bs, byte(length&0xff), byte((length&0xff00)>>8))
-			bs = append(bs, encodeUTF16LE([]byte(v.(string)))...)
-		} else {
-			length := len(v.([]byte))
-			bs = append(bs, byte(length&0xff), byte((length&0xff00)>>8))
-			bs = append(bs, v.([]byte)...)
+
+		var value []byte
+		switch v := v.(type) {
+		case string:
+			if !isUnicodeAvPair(id) {
+				return nil, fmt.Errorf("ntlmssp: %s must be []byte, not a string", avIds[uint16(id)])
+			}
+			value = encodeUTF16LE([]byte(v))
+		case []byte:
+			if isUnicodeAvPair(id) {
+				return nil, fmt.Errorf("ntlmssp: %s must be a string, not []byte", avIds[uint16(id)])
+			}
+			value = v
+		case time.Time:
+			if id != MsvAvTimestamp {
+				return nil, fmt.Errorf("ntlmssp: %s can't be time.Time", avIds[uint16(id)])
+			}
+			value = WindowsTimestamp(v)
+		case SingleHostData:
+			if id != MsAvRestrictions {
+				return nil, fmt.Errorf("ntlmssp: %s can't be SingleHostData", avIds[uint16(id)])
+			}
+			value = v.Encode()
+		default:
+			return nil, fmt.Errorf("ntlmssp: %s has unsupported type %T", avIds[uint16(id)], v)
+		}
+		if len(value) > 0xffff {
+			return nil, fmt.Errorf("ntlmssp: %s too long (%d bytes)", avIds[uint16(id)], len(value))
 		}
+
+		bs = append(bs, byte(id), 0)
+		bs = append(bs, byte(len(value)&0xff), byte((len(value)&0xff00)>>8))
+		bs = append(bs, value...)
 	}
 	bs = append(bs, []byte{0, 0, 0, 0}...)
+	return bs, nil
+}
 
+// Same as SetTargetInfo with the typed TargetInfo
+func (cm *ChallengeMsg) SetTargetInfoStruct(ti TargetInfo) error {
+	if cm.TargetInfoLen != 0 {
+		panic("Can't set TargetInfo field repeatedly")
+	}
+	return cm.setTargetInfo(ti.Marshal())
+}
+
+// Like SetTargetName, but an existing TargetName is replaced. The payload
+// is rebuilt, TargetInfo keeps its value and moves after the new name.
+func (cm *ChallengeMsg) ReplaceTargetName(tname []byte) {
+	if cm.NegotiateFlags&NEGOTIATE_UNICODE_CHARSET != 0 {
+		tname = encodeUTF16LE(tname)
+	}
+	cm.rebuildPayload(append([]byte{}, tname...), append([]byte{}, cm.TargetInfo()...))
+}
+
+// Like SetTargetInfo, but an existing TargetInfo is replaced and the
+// payload rebuilt. Nothing is changed on error.
+func (cm *ChallengeMsg) ReplaceTargetInfo(tinfo map[string]interface{}) error {
+	bs, err := marshalTargetInfo(tinfo)
+	if err != nil {
+		return err
+	}
+	if len(bs) > 0xffff {
+		return fmt.Errorf("ntlmssp: target info too long (%d bytes)", len(bs))
+	}
+	tname := payloadField(cm.Payload, ChallengeMsgPayloadOffset, cm.TargetNameBufferOffset, cm.TargetNameLen)
+	cm.NegotiateFlags |= NEGOTIATE_TARGET_INFO
+	cm.rebuildPayload(append([]byte{}, tname...), bs)
+	return nil
+}
+
+// Payload of the Version, if any, then the encoded target name and the
+// target info, neither of which may alias the payload
+func (cm *ChallengeMsg) rebuildPayload(tname, tinfo []byte) {
+	n := 0
+	if cm.NegotiateFlags&NEGOTIATE_VERSION != 0 && len(cm.Payload) >= 8 {
+		n = 8
+	}
+	cm.Payload = cm.Payload[:n]
+	cm.offset = ChallengeMsgPayloadOffset + uint32(n)
+	cm.TargetNameLen, cm.TargetNameMaxLen, cm.TargetNameBufferOffset = 0, 0, 0
+	cm.TargetInfoLen, cm.TargetInfoMaxLen, cm.TargetInfoBufferOffset = 0, 0, 0
+
+	if len(tname) > 0 {
+		cm.TargetNameLen = uint16(len(tname))
+		cm.TargetNameMaxLen = cm.TargetNameLen
+		cm.TargetNameBufferOffset = cm.offset
+		cm.Payload = append(cm.Payload, tname...)
+		cm.offset += uint32(cm.TargetNameLen)
+	}
+	if len(tinfo) > 0 {
+		cm.TargetInfoLen = uint16(len(tinfo))
+		cm.TargetInfoMaxLen = cm.TargetInfoLen
+		cm.TargetInfoBufferOffset = cm.offset
+		cm.Payload = append(cm.Payload, tinfo...)
+		cm.offset += uint32(cm.TargetInfoLen)
+	}
+}
+
+func (cm *ChallengeMsg) setTargetInfo(bs []byte) error {
+	if len(bs) > 0xffff {
+		return fmt.Errorf("ntlmssp: target info too long (%d bytes)", len(bs))
+	}
+
+	cm.NegotiateFlags |= NEGOTIATE_TARGET_INFO
 	cm.TargetInfoLen = uint16(len(bs))
 	cm.TargetInfoMaxLen = cm.TargetInfoLen
 	cm.TargetInfoBufferOffset = cm.offset
 	cm.Payload = append(cm.Payload, bs...)
 	cm.offset += uint32(cm.TargetInfoLen)
+	return nil
 }
 
 func (cm ChallengeMsg) Version() []byte {
-	if cm.NegotiateFlags&NEGOTIATE_VERSION != 0 {
-		return cm.Payload[:8]
-	} else {
+	// a message built in code may set the flag without the field
+	if cm.NegotiateFlags&NEGOTIATE_VERSION == 0 || len(cm.Payload) < 8 {
 		return nil
 	}
+	return cm.Payload[:8]
+}
+
+func (cm ChallengeMsg) Flags() uint32 {
+	return cm.NegotiateFlags
+}
+
+func (cm ChallengeMsg) Challenge() [8]byte {
+	return cm.ServerChallenge
+}
+
+// Strings of the message are UTF-16LE rather than OEM
+func (cm ChallengeMsg) SupportsUnicode() bool {
+	return cm.NegotiateFlags&NEGOTIATE_UNICODE_CHARSET != 0
+}
+
+func (cm ChallengeMsg) SupportsSign() bool {
+	return cm.NegotiateFlags&NEGOTIATE_SIGN != 0
+}
+
+func (cm ChallengeMsg) SupportsSeal() bool {
+	return cm.NegotiateFlags&NEGOTIATE_SEAL != 0
 }
 
 func (cm *ChallengeMsg) SetServerChallenge(challenge []byte) {
 	if challenge == nil {
-		rand.Read(cm.ServerChallenge[:])
+		io.ReadFull(Rand, cm.ServerChallenge[:])
 	} else {
 		copy(cm.ServerChallenge[:], challenge)
 	}
 }
 
+// Set NEGOTIATE_VERSION and the Version field, it must be called before
+// any payload field is set
+func (cm *ChallengeMsg) SetVersion(v Version) {
+	if cm.TargetNameLen != 0 || cm.TargetInfoLen != 0 {
+		panic("Version field must be set before the payload fields")
+	}
+
+	cm.NegotiateFlags |= NEGOTIATE_VERSION
+	version := v.Marshal()
+	if len(cm.Payload) == 0 {
+		cm.Payload = append(cm.Payload, version[:]...)
+	} else {
+		copy(cm.Payload[:8], version[:])
+	}
+	cm.offset = ChallengeMsgPayloadOffset + uint32(len(cm.Payload))
+}
+
+// Back to the state of NewChallengeMsg(nil) for building another message.
+// The Payload allocation is reused, slices returned by the getters are
+// overwritten by the next setters.
 func (cm *ChallengeMsg) Reset() {
-	cm.Payload = nil
-	cm.offset = ChallengeMsgPayloadOffset
+	*cm = ChallengeMsg{
+		Signature:   [8]byte{'N', 'T', 'L', 'M', 'S', 'S', 'P', 0},
+		MessageType: 0x02,
+		Payload:     cm.Payload[:0],
+		offset:      ChallengeMsgPayloadOffset,
+	}
 }
 
 func (cm *ChallengeMsg) String(bs []byte) string {
 	var s []string
-	type2 := NewChallengeMsg(bs)
+	type2, err := NewChallengeMsg(bs)
+	if err != nil {
+		return ""
+	}
 	tinfo := ParseAVPair(type2.TargetInfo())
 	for k, v := range tinfo {
-		if k == "MsvAvTimestamp" {
-			byteKey := []byte(fmt.Sprintf("%s", v.(interface{})))
-			//fmt.Println(byteKey)
-			i := binary.LittleEndian.Uint64(byteKey)
-			i2 := i - 116444736000000000
-			tm := time.Unix(0, int64(i2*100))
-			v = tm
-		}
-		//fmt.Printf("    %s: %v\n", k, v)
 		s = append(s, fmt.Sprintf("%-20s: %v\n", k, v))
 	}
-	offset_version := 48
-	version := bs[offset_version : offset_version+8]
-	v, _ := ReadVersionStruct(version)
-	s = append(s, fmt.Sprintf("%s", v.String()))
+	// Version is only present with NEGOTIATE_VERSION
+	if version := type2.Version(); version != nil {
+		if v, err := ReadVersionStruct(version); err == nil {
+			s = append(s, v.String())
+			if name := v.OSName(); name != "" {
+				s = append(s, fmt.Sprintf("OS: %s\n", name))
+			}
+		}
+	}
 	sort.Sort(sort.Reverse(sort.StringSlice(s)))
 	return strings.Join(s, "")
 }
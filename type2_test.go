@@ -1,29 +1,26 @@
 package ntlmssp
 
 import (
+	"bytes"
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 )
 
 func TestType2(t *testing.T) {
 	bs, _ := hex.DecodeString("4e544c4d53535000020000001e001e003800000005828aa25c0f5dfc015710c7000000000000000094009400560000000501280a0000000f5700570057002d003900460034003600380033004600430045003500420002001e005700570057002d003900460034003600380033004600430045003500420001001e005700570057002d003900460034003600380033004600430045003500420004001e007700770077002d003900660034003600380033006600630065003500620003001e007700770077002d0039006600340036003800330066006300650035006200060004000100000000000000")
-	type2 := NewChallengeMsg(bs)
+	type2, err := NewChallengeMsg(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	//tinfo := ReadAvPairs(type2.TargetInfo())
 	//fmt.Println(tinfo)
 	tinfo := ParseAVPair(type2.TargetInfo())
 	for k, v := range tinfo {
-		if k == "MsvAvTimestamp" {
-			byteKey := []byte(fmt.Sprintf("%s", v.(interface{})))
-			//fmt.Println(byteKey)
-			i := binary.LittleEndian.Uint64(byteKey)
-			i2 := i - 116444736000000000
-			tm := time.Unix(0, int64(i2*100))
-			v = tm
-		}
 		fmt.Printf("%s: %v\n", k, v)
 	}
 	offset_version := 48
@@ -39,6 +36,20 @@ func TestChallengeMsg_String(t *testing.T) {
 	fmt.Println(info)
 }
 
+func TestChallengeMsg_StringTimestamp(t *testing.T) {
+	// bytes above 0x7f, which did not survive a round trip through a string
+	timestamp := decodeHex("809a0c8f8bffd901")
+	type2, _ := NewChallengeMsg(nil)
+	type2.NegotiateFlags = NEGOTIATE_UNICODE_CHARSET | NEGOTIATE_TARGET_INFO
+	type2.SetTargetInfo(map[string]interface{}{"MsvAvTimestamp": timestamp})
+
+	want := time.Date(2023, 10, 15, 17, 18, 4, 964518400, time.UTC)
+	cm := ChallengeMsg{}
+	if info := cm.String(type2.Bytes()); !strings.Contains(info, fmt.Sprintf("MsvAvTimestamp      : %v\n", want)) {
+		t.Errorf("String, want MsvAvTimestamp %v:\n%s", want, info)
+	}
+}
+
 func TestTime(t *testing.T) {
 	//https://github.com/hirochachacha/go-smb2/blob/f071e13222d669bd071ff798608e85107d679e09/internal/ntlm/client.go#L237
 	var timestamp []byte
@@ -55,3 +66,473 @@ func TestTime(t *testing.T) {
 	tm := time.Unix(0, int64(i2*100))
 	fmt.Println(tm)
 }
+
+func TestChallengeMsg_UnMarshalTruncated(t *testing.T) {
+	bs, _ := hex.DecodeString("4e544c4d53535000020000001e001e003800000005828aa25c0f5dfc015710c7000000000000000094009400560000000501280a0000000f5700570057002d003900460034003600380033004600430045003500420002001e005700570057002d003900460034003600380033004600430045003500420001001e005700570057002d003900460034003600380033004600430045003500420004001e007700770077002d003900660034003600380033006600630065003500620003001e007700770077002d0039006600340036003800330066006300650035006200060004000100000000000000")
+
+	overflow := make([]byte, len(bs))
+	copy(overflow, bs)
+	// TargetInfoLen = 0xffff
+	overflow[40], overflow[41] = 0xff, 0xff
+
+	cases := map[string][]byte{
+		"empty":    {},
+		"47 bytes": bs[:47],
+		"header":   bs[:ChallengeMsgPayloadOffset],
+		"overflow": overflow,
+	}
+	for name, c := range cases {
+		if _, err := NewChallengeMsg(c); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	if _, err := NewChallengeMsg(bs); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChallengeMsg_SetTargetInfoOrder(t *testing.T) {
+	tinfo := map[string]interface{}{
+		"MsvAvTimestamp":       []byte{0, 0, 0, 0, 0, 0, 0, 0},
+		"MsvAvDnsComputerName": "server.domain.local",
+		"MsvAvDnsDomainName":   "domain.local",
+		"MsvAvNbComputerName":  "SERVER",
+		"MsvAvNbDomainName":    "DOMAIN",
+		"MsvAvDnsTreeName":     "domain.local",
+	}
+
+	var first []byte
+	for i := 0; i < 20; i++ {
+		cm, _ := NewChallengeMsg(nil)
+		cm.SetTargetInfo(tinfo)
+		if first == nil {
+			first = cm.TargetInfo()
+		} else if !bytes.Equal(cm.TargetInfo(), first) {
+			t.Fatalf("SetTargetInfo is not deterministic:\n%x\n%x", first, cm.TargetInfo())
+		}
+	}
+
+	var ids []AvPairType
+	for _, p := range ReadAvPairs(first).List {
+		ids = append(ids, p.AvId)
+	}
+	want := []AvPairType{MsvAvNbDomainName, MsvAvNbComputerName, MsvAvDnsDomainName, MsvAvDnsComputerName, MsvAvDnsTreeName, MsvAvTimestamp, MsvAvEOL}
+	if fmt.Sprint(ids) != fmt.Sprint(want) {
+		t.Errorf("AvIds = %v, want %v", ids, want)
+	}
+}
+
+func TestChallengeMsg_TargetNameOEM(t *testing.T) {
+	// NEGOTIATE_OEM_CHARSET | NEGOTIATE_REQUEST_TARGET_NAME | NEGOTIATE_NTLM, TargetName "DOMAIN"
+	bs, _ := hex.DecodeString("4e544c4d535350000200000006000600300000000602000001234567" +
+		"89abcdef000000000000000000000000300000" + "00" + hex.EncodeToString([]byte("DOMAIN")))
+	type2, err := NewChallengeMsg(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := type2.TargetName(); got != "DOMAIN" {
+		t.Errorf("TargetName = %q, want %q", got, "DOMAIN")
+	}
+
+	type2, _ = NewChallengeMsg(nil)
+	type2.NegotiateFlags = NEGOTIATE_UNICODE_CHARSET
+	type2.SetTargetName([]byte("DOMAIN"))
+	if got := type2.TargetName(); got != "DOMAIN" {
+		t.Errorf("Unicode TargetName = %q, want %q", got, "DOMAIN")
+	}
+}
+
+func TestChallengeMsg_MarshalEndian(t *testing.T) {
+	cm := ChallengeMsg{
+		Signature:              [8]byte{'N', 'T', 'L', 'M', 'S', 'S', 'P', 0},
+		MessageType:            2,
+		TargetNameLen:          0x0102,
+		TargetNameMaxLen:       0x0304,
+		TargetNameBufferOffset: 0x05060708,
+		NegotiateFlags:         0x11223344,
+		ServerChallenge:        [8]byte{1, 2, 3, 4, 5, 6, 7, 8},
+		TargetInfoLen:          0x0a0b,
+		TargetInfoMaxLen:       0x0c0d,
+		TargetInfoBufferOffset: 0x30,
+	}
+
+	le := "4e544c4d53535000" + "02000000" + "0201" + "0403" + "08070605" + "44332211" +
+		"0102030405060708" + "0000000000000000" + "0b0a" + "0d0c" + "30000000"
+	if got := hex.EncodeToString(cm.Marshal('<')); got != le {
+		t.Errorf("Marshal('<') = %s, want %s", got, le)
+	}
+
+	be := "4e544c4d53535000" + "00000002" + "0102" + "0304" + "05060708" + "11223344" +
+		"0102030405060708" + "0000000000000000" + "0a0b" + "0c0d" + "00000030"
+	if got := hex.EncodeToString(cm.Marshal('>')); got != be {
+		t.Errorf("Marshal('>') = %s, want %s", got, be)
+	}
+}
+
+func TestChallengeMsg_Dump(t *testing.T) {
+	bs, _ := hex.DecodeString("4e544c4d53535000020000001e001e003800000005828aa25c0f5dfc015710c7000000000000000094009400560000000501280a0000000f5700570057002d003900460034003600380033004600430045003500420002001e005700570057002d003900460034003600380033004600430045003500420001001e005700570057002d003900460034003600380033004600430045003500420004001e007700770077002d003900660034003600380033006600630065003500620003001e007700770077002d0039006600340036003800330066006300650035006200060004000100000000000000")
+	type2, err := NewChallengeMsg(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	type2.Dump(&buf)
+	for _, want := range []string{
+		"Challenge Message (type2)",
+		"TargetName: WWW-9F4683FCE5B\n",
+		"ServerChallenge: 5c0f5dfc015710c7\n",
+		"NEGOTIATE_UNICODE_CHARSET: Set\n",
+		"NEGOTIATE_SEAL: Not set\n",
+		"    MsvAvDnsComputerName: www-9f4683fce5b\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Dump does not contain %q:\n%s", want, buf.String())
+		}
+	}
+
+	buf.Reset()
+	WriteNegotiateFlags(&buf, NEGOTIATE_SIGN)
+	if !strings.Contains(buf.String(), "NEGOTIATE_SIGN: Set\n") || strings.Count(buf.String(), "\n") != 32 {
+		t.Errorf("WriteNegotiateFlags:\n%s", buf.String())
+	}
+}
+
+func TestChallengeMsg_DumpOEM(t *testing.T) {
+	// NEGOTIATE_OEM_CHARSET | NEGOTIATE_REQUEST_TARGET_NAME | NEGOTIATE_NTLM, TargetName "DOMAIN"
+	bs := decodeHex("4e544c4d535350000200000006000600300000000602000001234567" +
+		"89abcdef000000000000000000000000300000" + "00" + hex.EncodeToString([]byte("DOMAIN")))
+	type2, err := NewChallengeMsg(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	type2.Dump(&buf)
+	for _, want := range []string{
+		"TargetName: DOMAIN\n",
+		"    (Len: 6  Offset: 48  Charset: OEM  Raw: 444f4d41494e)\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Dump does not contain %q:\n%s", want, buf.String())
+		}
+	}
+
+	type2.NegotiateFlags = NEGOTIATE_UNICODE_CHARSET
+	buf.Reset()
+	type2.Dump(&buf)
+	if !strings.Contains(buf.String(), "Charset: Unicode  Raw: 444f4d41494e)") {
+		t.Errorf("Dump with NEGOTIATE_UNICODE_CHARSET:\n%s", buf.String())
+	}
+}
+
+func TestChallengeMsg_StringVersion(t *testing.T) {
+	// NEGOTIATE_VERSION set, Windows XP 5.1.2600
+	bs, _ := hex.DecodeString("4e544c4d53535000020000001e001e003800000005828aa25c0f5dfc015710c7000000000000000094009400560000000501280a0000000f5700570057002d003900460034003600380033004600430045003500420002001e005700570057002d003900460034003600380033004600430045003500420001001e005700570057002d003900460034003600380033004600430045003500420004001e007700770077002d003900660034003600380033006600630065003500620003001e007700770077002d0039006600340036003800330066006300650035006200060004000100000000000000")
+	cm := ChallengeMsg{}
+	if info := cm.String(bs); !strings.Contains(info, "Build: 5.1.2600\n") || !strings.Contains(info, "OS: Windows XP\n") {
+		t.Errorf("String with NEGOTIATE_VERSION:\n%s", info)
+	}
+
+	type2, _ := NewChallengeMsg(nil)
+	type2.NegotiateFlags = NEGOTIATE_UNICODE_CHARSET | NEGOTIATE_TARGET_INFO
+	type2.SetTargetInfo(map[string]interface{}{"MsvAvNbComputerName": "SERVER"})
+	info := cm.String(type2.Marshal('<'))
+	if strings.Contains(info, "Build:") {
+		t.Errorf("String without NEGOTIATE_VERSION reports a version:\n%s", info)
+	}
+	if !strings.Contains(info, "SERVER") {
+		t.Errorf("String without NEGOTIATE_VERSION:\n%s", info)
+	}
+
+	if _, err := ReadVersionStruct([]byte{10, 0}); err == nil {
+		t.Error("ReadVersionStruct(short): expected error")
+	}
+}
+
+func TestChallengeMsg_RoundTrip(t *testing.T) {
+	corpus := map[string]string{
+		// IIS, Windows XP version
+		"iis": "4e544c4d53535000020000001e001e003800000005828aa25c0f5dfc015710c7000000000000000094009400560000000501280a0000000f5700570057002d003900460034003600380033004600430045003500420002001e005700570057002d003900460034003600380033004600430045003500420001001e005700570057002d003900460034003600380033004600430045003500420004001e007700770077002d003900660034003600380033006600630065003500620003001e007700770077002d0039006600340036003800330066006300650035006200060004000100000000000000",
+		// MS-NLMP 4.2.2.3, no target info
+		"ntlmv1": "4e544c4d53535000020000000c000c003800000033820a820123456789abcdef00000000000000000000000000000000060070170000000f530065007200760065007200",
+		// MS-NLMP 4.2.4.3
+		"ntlmv2": "4e544c4d53535000020000000c000c003800000033828ae20123456789abcdef00000000000000002400240044000000060070170000000f53006500720076006500720002000c0044006f006d00610069006e0001000c0053006500720076006500720000000000",
+		// target info written before the target name
+		"reordered": "4e544c4d535350000200000006000600420000000582028a0123456789abcdef00000000000000000a000a0038000000" +
+			"060070170000000f" + "01000200410000000000" + hex.EncodeToString([]byte("DOMAIN")),
+	}
+	for name, h := range corpus {
+		bs, _ := hex.DecodeString(h)
+		type2, err := NewChallengeMsg(bs)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if got := type2.Marshal('<'); !bytes.Equal(got, bs) {
+			t.Errorf("%s: round trip\n got %x\nwant %x", name, got, bs)
+		}
+	}
+
+	// a field inside the header is malformed
+	bs, _ := hex.DecodeString(corpus["ntlmv1"])
+	bs[16] = 0x20
+	if _, err := NewChallengeMsg(bs); err == nil {
+		t.Error("TargetName offset inside the header: expected error")
+	}
+}
+
+func TestChallengeMsg_Accessors(t *testing.T) {
+	// MS-NLMP 4.2.4.3, flags 0xe28a8233
+	type2, err := NewChallengeMsg(decodeHex("4e544c4d53535000020000000c000c003800000033828ae20123456789abcdef00000000000000002400240044000000060070170000000f53006500720076006500720002000c0044006f006d00610069006e0001000c0053006500720076006500720000000000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := type2.Flags(); got != 0xe28a8233 {
+		t.Errorf("Flags = %x, want e28a8233", got)
+	}
+	if got := type2.Challenge(); hex.EncodeToString(got[:]) != "0123456789abcdef" {
+		t.Errorf("Challenge = %x, want 0123456789abcdef", got)
+	}
+	if !type2.SupportsUnicode() || !type2.SupportsSign() || !type2.SupportsSeal() {
+		t.Errorf("Supports* false for flags %x", type2.Flags())
+	}
+
+	type2.NegotiateFlags = NEGOTIATE_OEM_CHARSET | NEGOTIATE_NTLM
+	if type2.SupportsUnicode() || type2.SupportsSign() || type2.SupportsSeal() {
+		t.Errorf("Supports* true for flags %x", type2.Flags())
+	}
+}
+
+func TestChallengeMsg_SetTargetInfoTypes(t *testing.T) {
+	for _, tinfo := range []map[string]interface{}{
+		{"MsvAvTimestamp": "2026-10-14"},
+		{"MsvAvNbComputerName": []byte("SERVER")},
+		{"MsvAvFlags": uint32(2)},
+		{"MsvAvDnsComputerName": SingleHostData{}},
+		{"MsvAvNbDomainName": strings.Repeat("x", 0x8000)},
+	} {
+		cm, _ := NewChallengeMsg(nil)
+		if err := cm.SetTargetInfo(tinfo); err == nil {
+			t.Errorf("SetTargetInfo(%.40v): expected error", tinfo)
+		}
+		if cm.TargetInfoLen != 0 || len(cm.Payload) != 0 || cm.NegotiateFlags != 0 {
+			t.Errorf("SetTargetInfo(%.40v) changed the message on error", tinfo)
+		}
+	}
+
+	cm, _ := NewChallengeMsg(nil)
+	if err := cm.SetTargetInfo(map[string]interface{}{"MsvAvNbComputerName": "SERVER", "Unknown": 1}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestChallengeMsg_Validate(t *testing.T) {
+	// MS-NLMP 4.2.4.3, TargetName at 56, TargetInfo at 68
+	valid := "4e544c4d53535000020000000c000c003800000033828ae20123456789abcdef00000000000000002400240044000000060070170000000f53006500720076006500720002000c0044006f006d00610069006e0001000c0053006500720076006500720000000000"
+	if cm, _ := NewChallengeMsg(decodeHex(valid)); cm.Validate() != nil {
+		t.Fatal(cm.Validate())
+	}
+
+	for name, patch := range map[string]func(bs []byte){
+		// TargetInfo at 60 overlaps TargetName
+		"overlap": func(bs []byte) { bs[44] = 60 },
+		// TargetName at 48, on the Version
+		"version": func(bs []byte) { bs[16] = 48 },
+		// TargetName at 40 in the header
+		"header": func(bs []byte) { bs[16] = 40 },
+		// TargetInfoMaxLen 0x20 < TargetInfoLen 0x24
+		"maxlen": func(bs []byte) { bs[42] = 0x20 },
+	} {
+		bs := decodeHex(valid)
+		patch(bs)
+		cm, err := NewChallengeMsg(bs)
+		if err == nil {
+			err = cm.Validate()
+		}
+		if err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestChallengeMsg_Reset(t *testing.T) {
+	build := func(cm *ChallengeMsg, name string) []byte {
+		cm.NegotiateFlags = NEGOTIATE_UNICODE_CHARSET | NEGOTIATE_NTLM | NEGOTIATE_TARGET_INFO
+		cm.SetVersion(Version{MajorVersion: 10, BuildNumber: 17763})
+		cm.SetServerChallenge(decodeHex("0123456789abcdef"))
+		cm.SetTargetName([]byte(name))
+		if err := cm.SetTargetInfo(map[string]interface{}{"MsvAvNbDomainName": name}); err != nil {
+			t.Fatal(err)
+		}
+		return cm.Marshal('<')
+	}
+
+	cm, _ := NewChallengeMsg(nil)
+	for _, name := range []string{"Domain", "Other", "Domain"} {
+		cm.Reset()
+		fresh, _ := NewChallengeMsg(nil)
+		if got, want := build(cm, name), build(fresh, name); !bytes.Equal(got, want) {
+			t.Errorf("%s: rebuilt = %x, want %x", name, got, want)
+		}
+	}
+
+	am, _ := NewAuthenticateMsg(nil)
+	for i := 0; i < 2; i++ {
+		am.Reset()
+		am.ReserveMIC()
+		am.SetUserName([]byte("User"))
+		if am.UserName() != "User" || am.MIC() == nil {
+			t.Errorf("%d: UserName = %q, MIC = %x", i, am.UserName(), am.MIC())
+		}
+	}
+	nm, _ := NewNegotiateMsg(nil)
+	for i := 0; i < 2; i++ {
+		nm.Reset()
+		nm.NegotiateFlags = NEGOTIATE_OEM_DOMAIN_SUPPLIED
+		nm.SetDomainName([]byte("Domain"))
+		if nm.DomainName() != "Domain" {
+			t.Errorf("%d: DomainName = %q", i, nm.DomainName())
+		}
+	}
+}
+
+func TestMarshalTo(t *testing.T) {
+	cm, _ := NewChallengeMsg(decodeHex("4e544c4d53535000020000000c000c003800000033820a820123456789abcdef00000000000000000000000000000000060070170000000f530065007200760065007200"))
+	nm, _ := NewNegotiateMsg(nil)
+	nm.SetDomainName([]byte("Domain"))
+	am, _ := NewAuthenticateMsg(nil)
+	am.SetNtChallengeResponse(make([]byte, 24))
+	am.SetUserName([]byte("User"))
+
+	prefix := []byte("prefix")
+	for _, msg := range []interface {
+		Bytes() []byte
+		MarshalTo([]byte) []byte
+	}{cm, nm, am} {
+		want := msg.Bytes()
+		if got := msg.MarshalTo(nil); !bytes.Equal(got, want) {
+			t.Errorf("%T: MarshalTo(nil) = %x, want %x", msg, got, want)
+		}
+		if got := msg.MarshalTo(append([]byte{}, prefix...)); !bytes.Equal(got, append(append([]byte{}, prefix...), want...)) {
+			t.Errorf("%T: MarshalTo(prefix) = %x", msg, got)
+		}
+	}
+
+	buf := make([]byte, 0, 256)
+	if allocs := testing.AllocsPerRun(100, func() { buf = cm.MarshalTo(buf[:0]) }); allocs != 0 {
+		t.Errorf("MarshalTo into a large enough buffer: %v allocations", allocs)
+	}
+	if allocs := testing.AllocsPerRun(100, func() { cm.Bytes() }); allocs != 1 {
+		t.Errorf("Bytes: %v allocations, want 1", allocs)
+	}
+}
+
+func BenchmarkChallengeMsg_Marshal(b *testing.B) {
+	cm, _ := NewChallengeMsg(decodeHex("4e544c4d53535000020000000c000c003800000033820a820123456789abcdef00000000000000000000000000000000060070170000000f530065007200760065007200"))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		cm.Marshal('<')
+	}
+}
+
+func BenchmarkChallengeMsg_MarshalTo(b *testing.B) {
+	cm, _ := NewChallengeMsg(decodeHex("4e544c4d53535000020000000c000c003800000033820a820123456789abcdef00000000000000000000000000000000060070170000000f530065007200760065007200"))
+	buf := make([]byte, 0, 256)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf = cm.MarshalTo(buf[:0])
+	}
+}
+
+func TestChallengeMsg_FieldBounds(t *testing.T) {
+	cm, _ := NewChallengeMsg(nil)
+	cm.SetTargetName([]byte("Domain"))
+	cm.SetTargetInfo(map[string]interface{}{"MsvAvNbDomainName": "Domain"})
+
+	// 0 and 47 underflow the payload index, the last one is past its end
+	for _, offset := range []uint32{0, ChallengeMsgPayloadOffset - 1, ChallengeMsgPayloadOffset + uint32(len(cm.Payload)) + 1} {
+		c := *cm
+		c.TargetNameBufferOffset = offset
+		c.TargetInfoBufferOffset = offset
+		if got := c.TargetName(); got != "" {
+			t.Errorf("offset %d: TargetName = %q", offset, got)
+		}
+		if got := c.TargetInfo(); got != nil {
+			t.Errorf("offset %d: TargetInfo = %x", offset, got)
+		}
+	}
+
+	// a field ending exactly at the end of the payload is fine
+	c := *cm
+	c.TargetNameBufferOffset = ChallengeMsgPayloadOffset + uint32(len(cm.Payload)) - uint32(c.TargetNameLen)
+	if c.TargetName() == "" {
+		t.Error("TargetName at the end of the payload is empty")
+	}
+}
+
+func TestChallengeMsg_SetTargetInfoStruct(t *testing.T) {
+	byMap, _ := NewChallengeMsg(nil)
+	byMap.SetTargetInfo(map[string]interface{}{
+		"MsvAvNbDomainName":    "DOMAIN",
+		"MsvAvNbComputerName":  "SERVER",
+		"MsvAvDnsDomainName":   "domain.local",
+		"MsvAvDnsComputerName": "server.domain.local",
+		"MsvAvDnsTreeName":     "domain.local",
+	})
+	byStruct, _ := NewChallengeMsg(nil)
+	if err := byStruct.SetTargetInfoStruct(TargetInfo{
+		NetBIOSDomainName:   "DOMAIN",
+		NetBIOSComputerName: "SERVER",
+		DNSDomainName:       "domain.local",
+		DNSComputerName:     "server.domain.local",
+		DNSTreeName:         "domain.local",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(byStruct.Bytes(), byMap.Bytes()) {
+		t.Errorf("SetTargetInfoStruct = %x, want %x", byStruct.Bytes(), byMap.Bytes())
+	}
+}
+
+func TestChallengeMsg_ReplaceTargetName(t *testing.T) {
+	cm, _ := NewChallengeMsg(nil)
+	cm.NegotiateFlags = NEGOTIATE_UNICODE_CHARSET
+	cm.SetVersion(Version{MajorVersion: 10})
+	cm.SetTargetName([]byte("FIRST"))
+	cm.SetTargetInfo(map[string]interface{}{"MsvAvNbComputerName": "SERVER"})
+	tinfo := append([]byte{}, cm.TargetInfo()...)
+
+	cm.ReplaceTargetName([]byte("SECOND-NAME"))
+	if got := cm.TargetName(); got != "SECOND-NAME" {
+		t.Errorf("TargetName = %q", got)
+	}
+	if cm.TargetNameBufferOffset != ChallengeMsgPayloadOffset+8 || cm.TargetNameLen != 22 {
+		t.Errorf("TargetName offset %d len %d", cm.TargetNameBufferOffset, cm.TargetNameLen)
+	}
+	if cm.TargetInfoBufferOffset != ChallengeMsgPayloadOffset+8+22 || !bytes.Equal(cm.TargetInfo(), tinfo) {
+		t.Errorf("TargetInfo offset %d = %x, want %x", cm.TargetInfoBufferOffset, cm.TargetInfo(), tinfo)
+	}
+	if len(cm.Payload) != 8+22+len(tinfo) || cm.Version()[0] != 10 {
+		t.Errorf("Payload = %x", cm.Payload)
+	}
+
+	if err := cm.ReplaceTargetInfo(map[string]interface{}{"MsvAvNbDomainName": "DOMAIN"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := ParseAVPair(cm.TargetInfo()); len(got) != 1 || got["MsvAvNbDomainName"] != "DOMAIN" {
+		t.Errorf("TargetInfo = %v", got)
+	}
+	if err := cm.ReplaceTargetInfo(map[string]interface{}{"MsvAvFlags": "x"}); err == nil {
+		t.Error("ReplaceTargetInfo with a bad value: expected error")
+	}
+
+	parsed, err := NewChallengeMsg(cm.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parsed.Validate(); err != nil || parsed.TargetName() != "SECOND-NAME" {
+		t.Errorf("parsed TargetName %q, Validate: %v", parsed.TargetName(), err)
+	}
+}
@@ -1,10 +1,12 @@
 package ntlmssp
 
 import (
-	"crypto/rand"
+	"crypto/hmac"
+	"encoding/binary"
 	"fmt"
-	"math/bits"
-	"unsafe"
+	"io"
+	"os"
+	"time"
 )
 
 const AuthenticateMsgPayloadOffset = 64
@@ -42,67 +44,82 @@ type AuthenticateMsg struct {
 	// Version        [8]byte
 
 	// The MIC field is omitted in Windows NT, Windows 2000, Windows XP, and Windows Server 2003.
+	// MIC is variable, saved in Payload field after the 8 bytes Version
 	// MIC     [16]byte
 	Payload []byte
 
 	offset uint32
+	hasMIC bool
 }
 
 func (am AuthenticateMsg) Display() {
-	fmt.Println("Authenticate Message (type3)")
-	fmt.Printf("Signature: %v (%s)\n", am.Signature[:], am.Signature[:])
-	fmt.Printf("MessageType: %x\n", am.MessageType)
+	am.Dump(os.Stdout)
+}
+
+func (am AuthenticateMsg) Dump(w io.Writer) {
+	fmt.Fprintln(w, "Authenticate Message (type3)")
+	fmt.Fprintf(w, "Signature: %v (%s)\n", am.Signature[:], am.Signature[:])
+	fmt.Fprintf(w, "MessageType: %x\n", am.MessageType)
 
-	fmt.Printf("Response Version: ")
+	fmt.Fprintf(w, "Response Version: ")
 	if am.NtChallengeResponseLen <= 24 {
 		if am.NegotiateFlags&NEGOTIATE_EXTENDED_SESSION_SECURITY != 0 {
-			fmt.Println("NTLMv2 Session")
+			fmt.Fprintln(w, "NTLMv2 Session")
 		} else {
-			fmt.Println("NTLMv1")
+			fmt.Fprintln(w, "NTLMv1")
 		}
 	} else {
-		fmt.Println("NTLMv2")
+		fmt.Fprintln(w, "NTLMv2")
 	}
 
-	fmt.Printf("LmChallengeResponse: %x\n", am.LmChallengeResponse())
-	fmt.Printf("    (Len: %d  Offset: %d)\n", am.LmChallengeResponseLen, am.LmChallengeResponseBufferOffset)
+	fmt.Fprintf(w, "LmChallengeResponse: %x\n", am.LmChallengeResponse())
+	fmt.Fprintf(w, "    (Len: %d  Offset: %d)\n", am.LmChallengeResponseLen, am.LmChallengeResponseBufferOffset)
 
 	ntresp := am.NtChallengeResponse()
 	if ntv2, ok := ntresp.(*NTLMv2Response); ok {
-		fmt.Printf("NtChallengeResponse: %x\n", am.NtChallengeResponseBytes())
-		fmt.Printf("    (Len: %d  offset: %d)\n",
+		fmt.Fprintf(w, "NtChallengeResponse: %x\n", am.NtChallengeResponseBytes())
+		fmt.Fprintf(w, "    (Len: %d  offset: %d)\n",
 			am.NtChallengeResponseLen, am.NtChallengeResponseBufferOffset)
-		fmt.Printf("    Response: %x\n", ntv2.Response)
-		fmt.Printf("    NTLMv2ClientChallenge: \n")
-		fmt.Printf("      ChallengeFromClient: %x\n", ntv2.ClientChallenge.ChallengeFromClient)
-		fmt.Printf("      RespType: %d\n", ntv2.ClientChallenge.RespType)
-		fmt.Printf("      HiRespType: %d\n", ntv2.ClientChallenge.HiRespType)
-		fmt.Printf("      TimeStamp: %d\n", ntv2.ClientChallenge.TimeStamp)
-		fmt.Printf("      AVPair: \n")
+		fmt.Fprintf(w, "    Response: %x\n", ntv2.Response)
+		fmt.Fprintf(w, "    NTLMv2ClientChallenge: \n")
+		fmt.Fprintf(w, "      ChallengeFromClient: %x\n", ntv2.ClientChallenge.ChallengeFromClient)
+		fmt.Fprintf(w, "      RespType: %d\n", ntv2.ClientChallenge.RespType)
+		fmt.Fprintf(w, "      HiRespType: %d\n", ntv2.ClientChallenge.HiRespType)
+		fmt.Fprintf(w, "      TimeStamp: %d\n", ntv2.ClientChallenge.TimeStamp)
+		fmt.Fprintf(w, "      AVPair: \n")
 		for k, v := range ntv2.ClientChallenge.AVPair {
-			fmt.Printf("        %s: %v\n", k, v)
+			fmt.Fprintf(w, "        %s: %v\n", k, v)
 		}
 	} else {
-		fmt.Printf("NtChallengeResponse: %x\n", ntresp.(*NTLMResponse).Response)
-		fmt.Printf("    (Len: %d  Offset: %d)\n", am.NtChallengeResponseLen, am.NtChallengeResponseBufferOffset)
+		fmt.Fprintf(w, "NtChallengeResponse: %x\n", am.NtChallengeResponseBytes())
+		fmt.Fprintf(w, "    (Len: %d  Offset: %d)\n", am.NtChallengeResponseLen, am.NtChallengeResponseBufferOffset)
 	}
 
-	fmt.Printf("DomainName: %s\n", am.DomainName())
-	fmt.Printf("    (Len: %d  Offset: %d)\n", am.DomainNameLen, am.DomainNameBufferOffset)
+	fmt.Fprintf(w, "DomainName: %s\n", am.DomainName())
+	fmt.Fprintf(w, "    (Len: %d  Offset: %d)\n", am.DomainNameLen, am.DomainNameBufferOffset)
 
-	fmt.Printf("UserName: %s\n", am.UserName())
-	fmt.Printf("    (Len: %d  Offset: %d)\n", am.UserNameLen, am.UserNameBufferOffset)
+	fmt.Fprintf(w, "UserName: %s\n", am.UserName())
+	fmt.Fprintf(w, "    (Len: %d  Offset: %d)\n", am.UserNameLen, am.UserNameBufferOffset)
 
-	fmt.Printf("Workstation: %s\n", am.Workstation())
-	fmt.Printf("    (Len: %d  Offset: %d)\n", am.WorkstationLen, am.WorkstationBufferOffset)
+	fmt.Fprintf(w, "Workstation: %s\n", am.Workstation())
+	fmt.Fprintf(w, "    (Len: %d  Offset: %d)\n", am.WorkstationLen, am.WorkstationBufferOffset)
 
-	fmt.Printf("EncryptedRandomSessionKey: %v\n", am.EncryptedRandomSessionKey())
-	fmt.Printf("    (Len: %d  Offset: %d)\n", am.EncryptedRandomSessionKeyLen, am.EncryptedRandomSessionKeyBufferOffset)
-	DisplayNegotiateFlags(am.NegotiateFlags)
-	fmt.Println()
+	fmt.Fprintf(w, "EncryptedRandomSessionKey: %v\n", am.EncryptedRandomSessionKey())
+	fmt.Fprintf(w, "    (Len: %d  Offset: %d)\n", am.EncryptedRandomSessionKeyLen, am.EncryptedRandomSessionKeyBufferOffset)
+	fmt.Fprintf(w, "MIC: %x\n", am.MIC())
+	WriteNegotiateFlags(w, am.NegotiateFlags)
+	fmt.Fprintln(w)
 }
 
-func (am *AuthenticateMsg) UnMarshal(bs []byte) {
+func (am *AuthenticateMsg) UnMarshal(bs []byte) error {
+	if len(bs) < AuthenticateMsgPayloadOffset {
+		return fmt.Errorf("%w: authenticate message of %d bytes", ErrMessageTooShort, len(bs))
+	}
+
+	if err := checkMessageType(bs, 3); err != nil {
+		return err
+	}
+
 	copy(am.Signature[:], bs[:8])
 	am.MessageType = uint32(bytes2Uint(bs[8:12], '<'))
 
@@ -131,130 +148,150 @@ func (am *AuthenticateMsg) UnMarshal(bs []byte) {
 	am.EncryptedRandomSessionKeyBufferOffset = uint32(bytes2Uint(bs[56:60], '<'))
 
 	am.NegotiateFlags = uint32(bytes2Uint(bs[60:64], '<'))
-	am.offset = AuthenticateMsgPayloadOffset
 
-	plen := 0
-	if am.LmChallengeResponseBufferOffset != 0 && am.LmChallengeResponseLen != 0 {
-		plen += int(am.LmChallengeResponseLen)
+	fields := []struct {
+		name   string
+		length uint16
+		offset uint32
+	}{
+		{"LmChallengeResponse", am.LmChallengeResponseLen, am.LmChallengeResponseBufferOffset},
+		{"NtChallengeResponse", am.NtChallengeResponseLen, am.NtChallengeResponseBufferOffset},
+		{"DomainName", am.DomainNameLen, am.DomainNameBufferOffset},
+		{"UserName", am.UserNameLen, am.UserNameBufferOffset},
+		{"Workstation", am.WorkstationLen, am.WorkstationBufferOffset},
+		{"EncryptedRandomSessionKey", am.EncryptedRandomSessionKeyLen, am.EncryptedRandomSessionKeyBufferOffset},
+	}
+
+	// The payload starts at the lowest buffer offset and ends at the highest
+	// buffer end, everything in front of the first buffer is Version and MIC.
+	start := uint64(len(bs))
+	end := uint64(AuthenticateMsgPayloadOffset)
+	for _, f := range fields {
+		if f.length == 0 {
+			continue
+		}
+		if f.offset < AuthenticateMsgPayloadOffset || uint64(f.offset)+uint64(f.length) > uint64(len(bs)) {
+			return fmt.Errorf("%w: %s (offset %d, len %d) exceeds message length %d", ErrMalformedMessage,
+				f.name, f.offset, f.length, len(bs))
+		}
+		if uint64(f.offset) < start {
+			start = uint64(f.offset)
+		}
+		if uint64(f.offset)+uint64(f.length) > end {
+			end = uint64(f.offset) + uint64(f.length)
+		}
 	}
-	if am.NtChallengeResponseBufferOffset != 0 && am.NtChallengeResponseLen != 0 {
-		plen += int(am.NtChallengeResponseLen)
+
+	fixed := uint64(AuthenticateMsgPayloadOffset)
+	if am.NegotiateFlags&NEGOTIATE_VERSION != 0 {
+		fixed += 8
 	}
-	if am.DomainNameBufferOffset != 0 && am.DomainNameLen != 0 {
-		plen += int(am.DomainNameLen)
+	// The MIC always follows the Version field, detect it by the room
+	// left in front of the first buffer.
+	am.hasMIC = start >= AuthenticateMsgPayloadOffset+8+16
+	if am.hasMIC {
+		fixed = AuthenticateMsgPayloadOffset + 8 + 16
 	}
-	if am.UserNameBufferOffset != 0 && am.UserNameLen != 0 {
-		plen += int(am.UserNameLen)
+	if fixed > uint64(len(bs)) {
+		return fmt.Errorf("%w: authenticate message of %d bytes for Version/MIC", ErrMessageTooShort, len(bs))
 	}
-	if am.WorkstationBufferOffset != 0 && am.WorkstationLen != 0 {
-		plen += int(am.WorkstationLen)
+	if fixed > end {
+		end = fixed
 	}
-	if am.EncryptedRandomSessionKeyBufferOffset != 0 && am.EncryptedRandomSessionKeyLen != 0 {
-		plen += int(am.EncryptedRandomSessionKeyLen)
+	if err := checkMessageSize(end); err != nil {
+		return err
 	}
 
-	if am.NegotiateFlags&NEGOTIATE_VERSION != 0 {
-		plen += 8
-
-		// Detect if there is MIC field
-		if am.LmChallengeResponseBufferOffset > AuthenticateMsgPayloadOffset+8 &&
-			am.NtChallengeResponseBufferOffset > AuthenticateMsgPayloadOffset+8 &&
-			am.UserNameBufferOffset > AuthenticateMsgPayloadOffset+8 &&
-			am.EncryptedRandomSessionKeyBufferOffset > AuthenticateMsgPayloadOffset+8 &&
-			am.DomainNameBufferOffset > AuthenticateMsgPayloadOffset+8 &&
-			am.WorkstationBufferOffset > AuthenticateMsgPayloadOffset+8 {
-			plen += 16
-		}
-	} else {
-		if am.LmChallengeResponseBufferOffset > AuthenticateMsgPayloadOffset &&
-			am.NtChallengeResponseBufferOffset > AuthenticateMsgPayloadOffset &&
-			am.UserNameBufferOffset > AuthenticateMsgPayloadOffset &&
-			am.EncryptedRandomSessionKeyBufferOffset > AuthenticateMsgPayloadOffset &&
-			am.DomainNameBufferOffset > AuthenticateMsgPayloadOffset &&
-			am.WorkstationBufferOffset > AuthenticateMsgPayloadOffset {
-			plen += 16
-		}
-	}
-
-	am.Payload = make([]byte, plen)
-	copy(am.Payload, bs[AuthenticateMsgPayloadOffset:AuthenticateMsgPayloadOffset+uint32(plen)])
+	am.Payload = make([]byte, end-AuthenticateMsgPayloadOffset)
+	copy(am.Payload, bs[AuthenticateMsgPayloadOffset:end])
+	am.offset = uint32(end)
+	return nil
 }
 
+// Deprecated: use Bytes, or MarshalOrder for '>'
 func (am AuthenticateMsg) Marshal(endian byte) []byte {
-	bs := []byte{}
-	if endian == '>' {
-		am.MessageType = bits.ReverseBytes32(am.MessageType)
-		am.NegotiateFlags = bits.ReverseBytes32(am.NegotiateFlags)
-
-		am.LmChallengeResponseLen = bits.ReverseBytes16(am.LmChallengeResponseLen)
-		am.LmChallengeResponseMaxLen = bits.ReverseBytes16(am.LmChallengeResponseMaxLen)
-		am.LmChallengeResponseBufferOffset = bits.ReverseBytes32(am.LmChallengeResponseBufferOffset)
-
-		am.NtChallengeResponseLen = bits.ReverseBytes16(am.NtChallengeResponseLen)
-		am.NtChallengeResponseMaxLen = bits.ReverseBytes16(am.NtChallengeResponseMaxLen)
-		am.NtChallengeResponseBufferOffset = bits.ReverseBytes32(am.NtChallengeResponseBufferOffset)
-
-		am.DomainNameLen = bits.ReverseBytes16(am.DomainNameLen)
-		am.DomainNameMaxLen = bits.ReverseBytes16(am.DomainNameMaxLen)
-		am.DomainNameBufferOffset = bits.ReverseBytes32(am.DomainNameBufferOffset)
+	return am.MarshalOrder(byteOrder(endian))
+}
 
-		am.UserNameLen = bits.ReverseBytes16(am.UserNameLen)
-		am.UserNameMaxLen = bits.ReverseBytes16(am.UserNameMaxLen)
-		am.UserNameBufferOffset = bits.ReverseBytes32(am.UserNameBufferOffset)
+// The message on the wire, which is always little endian
+func (am AuthenticateMsg) Bytes() []byte {
+	return am.MarshalOrder(binary.LittleEndian)
+}
 
-		am.WorkstationLen = bits.ReverseBytes16(am.WorkstationLen)
-		am.WorkstationMaxLen = bits.ReverseBytes16(am.WorkstationMaxLen)
-		am.WorkstationBufferOffset = bits.ReverseBytes32(am.WorkstationBufferOffset)
+// The message with its integer fields in order
+func (am AuthenticateMsg) MarshalOrder(order binary.ByteOrder) []byte {
+	return am.appendTo(make([]byte, 0, AuthenticateMsgPayloadOffset+len(am.Payload)), order)
+}
 
-		am.EncryptedRandomSessionKeyLen = bits.ReverseBytes16(am.EncryptedRandomSessionKeyLen)
-		am.EncryptedRandomSessionKeyMaxLen = bits.ReverseBytes16(am.EncryptedRandomSessionKeyMaxLen)
-		am.EncryptedRandomSessionKeyBufferOffset = bits.ReverseBytes32(am.EncryptedRandomSessionKeyBufferOffset)
-	}
+// Append the wire form of the message to dst, see ChallengeMsg.MarshalTo
+func (am AuthenticateMsg) MarshalTo(dst []byte) []byte {
+	return am.appendTo(dst, binary.LittleEndian)
+}
 
+func (am AuthenticateMsg) appendTo(bs []byte, order binary.ByteOrder) []byte {
 	bs = append(bs, am.Signature[:]...)
 
-	bs = append(bs, (*(*[4]byte)(unsafe.Pointer(&am.MessageType)))[:]...)
+	bs = appendUint32(bs, order, am.MessageType)
 
-	bs = append(bs, (*(*[2]byte)(unsafe.Pointer(&am.LmChallengeResponseLen)))[:]...)
-	bs = append(bs, (*(*[2]byte)(unsafe.Pointer(&am.LmChallengeResponseMaxLen)))[:]...)
-	bs = append(bs, (*(*[4]byte)(unsafe.Pointer(&am.LmChallengeResponseBufferOffset)))[:]...)
+	bs = appendUint16(bs, order, am.LmChallengeResponseLen)
+	bs = appendUint16(bs, order, am.LmChallengeResponseMaxLen)
+	bs = appendUint32(bs, order, am.LmChallengeResponseBufferOffset)
 
-	bs = append(bs, (*(*[2]byte)(unsafe.Pointer(&am.NtChallengeResponseLen)))[:]...)
-	bs = append(bs, (*(*[2]byte)(unsafe.Pointer(&am.NtChallengeResponseMaxLen)))[:]...)
-	bs = append(bs, (*(*[4]byte)(unsafe.Pointer(&am.NtChallengeResponseBufferOffset)))[:]...)
+	bs = appendUint16(bs, order, am.NtChallengeResponseLen)
+	bs = appendUint16(bs, order, am.NtChallengeResponseMaxLen)
+	bs = appendUint32(bs, order, am.NtChallengeResponseBufferOffset)
 
-	bs = append(bs, (*(*[2]byte)(unsafe.Pointer(&am.DomainNameLen)))[:]...)
-	bs = append(bs, (*(*[2]byte)(unsafe.Pointer(&am.DomainNameMaxLen)))[:]...)
-	bs = append(bs, (*(*[4]byte)(unsafe.Pointer(&am.DomainNameBufferOffset)))[:]...)
+	bs = appendUint16(bs, order, am.DomainNameLen)
+	bs = appendUint16(bs, order, am.DomainNameMaxLen)
+	bs = appendUint32(bs, order, am.DomainNameBufferOffset)
 
-	bs = append(bs, (*(*[2]byte)(unsafe.Pointer(&am.UserNameLen)))[:]...)
-	bs = append(bs, (*(*[2]byte)(unsafe.Pointer(&am.UserNameMaxLen)))[:]...)
-	bs = append(bs, (*(*[4]byte)(unsafe.Pointer(&am.UserNameBufferOffset)))[:]...)
+	bs = appendUint16(bs, order, am.UserNameLen)
+	bs = appendUint16(bs, order, am.UserNameMaxLen)
+	bs = appendUint32(bs, order, am.UserNameBufferOffset)
 
-	bs = append(bs, (*(*[2]byte)(unsafe.Pointer(&am.WorkstationLen)))[:]...)
-	bs = append(bs, (*(*[2]byte)(unsafe.Pointer(&am.WorkstationMaxLen)))[:]...)
-	bs = append(bs, (*(*[4]byte)(unsafe.Pointer(&am.WorkstationBufferOffset)))[:]...)
+	bs = appendUint16(bs, order, am.WorkstationLen)
+	bs = appendUint16(bs, order, am.WorkstationMaxLen)
+	bs = appendUint32(bs, order, am.WorkstationBufferOffset)
 
-	bs = append(bs, (*(*[2]byte)(unsafe.Pointer(&am.EncryptedRandomSessionKeyLen)))[:]...)
-	bs = append(bs, (*(*[2]byte)(unsafe.Pointer(&am.EncryptedRandomSessionKeyMaxLen)))[:]...)
-	bs = append(bs, (*(*[4]byte)(unsafe.Pointer(&am.EncryptedRandomSessionKeyBufferOffset)))[:]...)
+	bs = appendUint16(bs, order, am.EncryptedRandomSessionKeyLen)
+	bs = appendUint16(bs, order, am.EncryptedRandomSessionKeyMaxLen)
+	bs = appendUint32(bs, order, am.EncryptedRandomSessionKeyBufferOffset)
 
-	bs = append(bs, (*(*[4]byte)(unsafe.Pointer(&am.NegotiateFlags)))[:]...)
+	bs = appendUint32(bs, order, am.NegotiateFlags)
 	bs = append(bs, am.Payload...)
 
 	return bs
 }
 
-func NewAuthenticateMsg(bs []byte) *AuthenticateMsg {
+// Check the payload fields lie after the header, Version and MIC, within
+// the message and don't overlap
+func (am AuthenticateMsg) Validate() error {
+	start := uint64(AuthenticateMsgPayloadOffset)
+	if am.hasMIC {
+		start += 8 + 16
+	} else if am.NegotiateFlags&NEGOTIATE_VERSION != 0 {
+		start += 8
+	}
+	return validateBuffers(start, AuthenticateMsgPayloadOffset+uint64(len(am.Payload)),
+		securityBuffer{"LmChallengeResponse", am.LmChallengeResponseLen, am.LmChallengeResponseMaxLen, am.LmChallengeResponseBufferOffset},
+		securityBuffer{"NtChallengeResponse", am.NtChallengeResponseLen, am.NtChallengeResponseMaxLen, am.NtChallengeResponseBufferOffset},
+		securityBuffer{"DomainName", am.DomainNameLen, am.DomainNameMaxLen, am.DomainNameBufferOffset},
+		securityBuffer{"UserName", am.UserNameLen, am.UserNameMaxLen, am.UserNameBufferOffset},
+		securityBuffer{"Workstation", am.WorkstationLen, am.WorkstationMaxLen, am.WorkstationBufferOffset},
+		securityBuffer{"EncryptedRandomSessionKey", am.EncryptedRandomSessionKeyLen, am.EncryptedRandomSessionKeyMaxLen, am.EncryptedRandomSessionKeyBufferOffset},
+	)
+}
+
+func NewAuthenticateMsg(bs []byte) (*AuthenticateMsg, error) {
 	am := AuthenticateMsg{}
 	if bs == nil {
 		am.Signature = [8]byte{'N', 'T', 'L', 'M', 'S', 'S', 'P', 0}
 		am.MessageType = 0x03
 		am.offset = AuthenticateMsgPayloadOffset
-	} else {
-		am.UnMarshal(bs)
+	} else if err := am.UnMarshal(bs); err != nil {
+		return nil, err
 	}
-	return &am
+	return &am, nil
 }
 
 func (am AuthenticateMsg) LmChallengeResponse() []byte {
@@ -262,7 +299,7 @@ func (am AuthenticateMsg) LmChallengeResponse() []byte {
 		return nil
 	}
 
-	bs := am.Payload[am.LmChallengeResponseBufferOffset-AuthenticateMsgPayloadOffset : am.LmChallengeResponseBufferOffset-AuthenticateMsgPayloadOffset+uint32(am.LmChallengeResponseLen)]
+	bs := payloadField(am.Payload, AuthenticateMsgPayloadOffset, am.LmChallengeResponseBufferOffset, am.LmChallengeResponseLen)
 	return bs
 }
 
@@ -271,15 +308,20 @@ func (am AuthenticateMsg) NtChallengeResponse() interface{} {
 		return nil
 	}
 
-	bs := am.Payload[am.NtChallengeResponseBufferOffset-AuthenticateMsgPayloadOffset : am.NtChallengeResponseBufferOffset-AuthenticateMsgPayloadOffset+uint32(am.NtChallengeResponseLen)]
+	bs := payloadField(am.Payload, AuthenticateMsgPayloadOffset, am.NtChallengeResponseBufferOffset, am.NtChallengeResponseLen)
+	if bs == nil {
+		return nil
+	}
 
 	var resp interface{}
 	if len(bs) > 24 {
 		// NTLMv2
-		resp = ParseNTLMv2Response(bs)
+		if ntv2 := ParseNTLMv2Response(bs); ntv2 != nil {
+			resp = ntv2
+		}
 	} else {
 		arr := [24]byte{}
-		copy(arr[:], bs[:24])
+		copy(arr[:], bs)
 		resp = &NTLMResponse{
 			Response: arr,
 		}
@@ -292,17 +334,49 @@ func (am AuthenticateMsg) NtChallengeResponseBytes() []byte {
 		return nil
 	}
 
-	return am.Payload[am.NtChallengeResponseBufferOffset-AuthenticateMsgPayloadOffset : am.NtChallengeResponseBufferOffset-AuthenticateMsgPayloadOffset+uint32(am.NtChallengeResponseLen)]
+	return payloadField(am.Payload, AuthenticateMsgPayloadOffset, am.NtChallengeResponseBufferOffset, am.NtChallengeResponseLen)
 
 }
 
+// Same as LmChallengeResponse
+func (am AuthenticateMsg) LMResponse() []byte {
+	return am.LmChallengeResponse()
+}
+
+// Same as NtChallengeResponseBytes
+func (am AuthenticateMsg) NTResponse() []byte {
+	return am.NtChallengeResponseBytes()
+}
+
+// NTLMv2 responses are longer than the 24 bytes of NTLMv1
+func (am AuthenticateMsg) IsNTLMv2() bool {
+	return am.NtChallengeResponseLen > 24
+}
+
+// ResponseType of the NT response, "NTLM2Session" for an NTLMv1 response
+// whose LM response is ClientChallenge followed by Z(16), and "LMv1" when
+// only an LM response is sent
+func (am AuthenticateMsg) ResponseType() string {
+	if am.IsAnonymous() {
+		return "Anonymous"
+	}
+	typ := ResponseType(am.NtChallengeResponseBytes())
+	switch {
+	case typ == "Anonymous" && am.LmChallengeResponseLen == 24:
+		return "LMv1"
+	case typ == "NTLMv1" && isNTLM2SessionLmResponse(am.LmChallengeResponse()):
+		return "NTLM2Session"
+	}
+	return typ
+}
+
 func (am AuthenticateMsg) DomainName() string {
 	if am.DomainNameLen == 0 {
 		return ""
 	}
 
-	domain := am.Payload[am.DomainNameBufferOffset-AuthenticateMsgPayloadOffset : am.DomainNameBufferOffset-AuthenticateMsgPayloadOffset+uint32(am.DomainNameLen)]
-	if am.NegotiateFlags&1 == 1 {
+	domain := payloadField(am.Payload, AuthenticateMsgPayloadOffset, am.DomainNameBufferOffset, am.DomainNameLen)
+	if am.NegotiateFlags&NEGOTIATE_UNICODE_CHARSET != 0 {
 		return bytes2StringUTF16(domain)
 	}
 	return string(domain)
@@ -313,16 +387,16 @@ func (am AuthenticateMsg) DomainNameBytes() []byte {
 		return nil
 	}
 
-	return am.Payload[am.DomainNameBufferOffset-AuthenticateMsgPayloadOffset : am.DomainNameBufferOffset-AuthenticateMsgPayloadOffset+uint32(am.DomainNameLen)]
+	return payloadField(am.Payload, AuthenticateMsgPayloadOffset, am.DomainNameBufferOffset, am.DomainNameLen)
 }
 
 func (am AuthenticateMsg) UserName() string {
 	if am.UserNameLen == 0 {
 		return ""
 	}
-	uname := am.Payload[am.UserNameBufferOffset-AuthenticateMsgPayloadOffset : am.UserNameBufferOffset-AuthenticateMsgPayloadOffset+uint32(am.UserNameLen)]
+	uname := payloadField(am.Payload, AuthenticateMsgPayloadOffset, am.UserNameBufferOffset, am.UserNameLen)
 
-	if am.NegotiateFlags&1 == 1 {
+	if am.NegotiateFlags&NEGOTIATE_UNICODE_CHARSET != 0 {
 		return bytes2StringUTF16(uname)
 	}
 	return string(uname)
@@ -332,16 +406,16 @@ func (am AuthenticateMsg) UserNameBytes() []byte {
 	if am.UserNameLen == 0 {
 		return nil
 	}
-	return am.Payload[am.UserNameBufferOffset-AuthenticateMsgPayloadOffset : am.UserNameBufferOffset-AuthenticateMsgPayloadOffset+uint32(am.UserNameLen)]
+	return payloadField(am.Payload, AuthenticateMsgPayloadOffset, am.UserNameBufferOffset, am.UserNameLen)
 }
 
 func (am AuthenticateMsg) Workstation() string {
 	if am.WorkstationLen == 0 {
 		return ""
 	}
-	ws := am.Payload[am.WorkstationBufferOffset-AuthenticateMsgPayloadOffset : am.WorkstationBufferOffset-AuthenticateMsgPayloadOffset+uint32(am.WorkstationMaxLen)]
+	ws := payloadField(am.Payload, AuthenticateMsgPayloadOffset, am.WorkstationBufferOffset, am.WorkstationLen)
 
-	if am.NegotiateFlags&1 == 1 {
+	if am.NegotiateFlags&NEGOTIATE_UNICODE_CHARSET != 0 {
 		return bytes2StringUTF16(ws)
 	}
 	return string(ws)
@@ -351,23 +425,68 @@ func (am AuthenticateMsg) WorkstationBytes() []byte {
 	if am.WorkstationLen == 0 {
 		return nil
 	}
-	return am.Payload[am.WorkstationBufferOffset-AuthenticateMsgPayloadOffset : am.WorkstationBufferOffset-AuthenticateMsgPayloadOffset+uint32(am.WorkstationMaxLen)]
-
+	return payloadField(am.Payload, AuthenticateMsgPayloadOffset, am.WorkstationBufferOffset, am.WorkstationLen)
 }
 
 func (am AuthenticateMsg) EncryptedRandomSessionKey() []byte {
 	if am.EncryptedRandomSessionKeyLen == 0 {
 		return nil
 	}
-	return am.Payload[am.EncryptedRandomSessionKeyBufferOffset-AuthenticateMsgPayloadOffset : am.EncryptedRandomSessionKeyBufferOffset-AuthenticateMsgPayloadOffset+uint32(am.EncryptedRandomSessionKeyLen)]
+	return payloadField(am.Payload, AuthenticateMsgPayloadOffset, am.EncryptedRandomSessionKeyBufferOffset, am.EncryptedRandomSessionKeyLen)
 }
 
 func (am AuthenticateMsg) Version() []byte {
-	if am.NegotiateFlags&NEGOTIATE_VERSION != 0 {
-		return am.Payload[:8]
-	} else {
+	if am.NegotiateFlags&NEGOTIATE_VERSION == 0 || len(am.Payload) < 8 {
 		return nil
 	}
+	return am.Payload[:8]
+}
+
+func (am AuthenticateMsg) MIC() []byte {
+	if !am.hasMIC {
+		return nil
+	}
+	return am.Payload[8:24]
+}
+
+// ReserveMIC makes room for a zeroed MIC field at offset 72. It must be
+// called before any payload field is set. If no Version has been placed in
+// the Payload yet, an empty one is reserved in front of the MIC.
+func (am *AuthenticateMsg) ReserveMIC() {
+	if am.hasMIC {
+		panic("Can't set MIC field repeatedly")
+	}
+	if am.LmChallengeResponseLen != 0 || am.NtChallengeResponseLen != 0 ||
+		am.DomainNameLen != 0 || am.UserNameLen != 0 ||
+		am.WorkstationLen != 0 || am.EncryptedRandomSessionKeyLen != 0 {
+		panic("MIC field must be reserved before the payload fields")
+	}
+
+	if len(am.Payload) == 0 {
+		am.Payload = append(am.Payload, make([]byte, 8)...)
+	}
+	am.Payload = append(am.Payload[:8], make([]byte, 16)...)
+	am.hasMIC = true
+	am.offset = AuthenticateMsgPayloadOffset + uint32(len(am.Payload))
+}
+
+// Set NEGOTIATE_VERSION and the Version field, it must be called before
+// any payload field is set. It keeps a MIC reserved by ReserveMIC.
+func (am *AuthenticateMsg) SetVersion(v Version) {
+	if am.LmChallengeResponseLen != 0 || am.NtChallengeResponseLen != 0 ||
+		am.DomainNameLen != 0 || am.UserNameLen != 0 ||
+		am.WorkstationLen != 0 || am.EncryptedRandomSessionKeyLen != 0 {
+		panic("Version field must be set before the payload fields")
+	}
+
+	am.NegotiateFlags |= NEGOTIATE_VERSION
+	version := v.Marshal()
+	if len(am.Payload) == 0 {
+		am.Payload = append(am.Payload, version[:]...)
+	} else {
+		copy(am.Payload[:8], version[:])
+	}
+	am.offset = AuthenticateMsgPayloadOffset + uint32(len(am.Payload))
 }
 
 func (am *AuthenticateMsg) SetUserName(uname []byte) {
@@ -376,10 +495,11 @@ func (am *AuthenticateMsg) SetUserName(uname []byte) {
 	}
 
 	if am.NegotiateFlags&NEGOTIATE_UNICODE_CHARSET != 0 {
-		am.UserNameLen = uint16(2 * len(uname))
+		encoded := encodeUTF16LE(uname)
+		am.UserNameLen = uint16(len(encoded))
 		am.UserNameMaxLen = am.UserNameLen
 		am.UserNameBufferOffset = am.offset
-		am.Payload = append(am.Payload, encodeUTF16LE(uname)...)
+		am.Payload = append(am.Payload, encoded...)
 	} else {
 		am.UserNameLen = uint16(len(uname))
 		am.UserNameMaxLen = am.UserNameLen
@@ -396,10 +516,11 @@ func (am *AuthenticateMsg) SetDomainName(dname []byte) {
 	}
 
 	if am.NegotiateFlags&NEGOTIATE_UNICODE_CHARSET != 0 {
-		am.DomainNameLen = uint16(2 * len(dname))
+		encoded := encodeUTF16LE(dname)
+		am.DomainNameLen = uint16(len(encoded))
 		am.DomainNameMaxLen = am.DomainNameLen
 		am.DomainNameBufferOffset = am.offset
-		am.Payload = append(am.Payload, encodeUTF16LE(dname)...)
+		am.Payload = append(am.Payload, encoded...)
 	} else {
 		am.DomainNameLen = uint16(len(dname))
 		am.DomainNameMaxLen = am.DomainNameLen
@@ -416,10 +537,11 @@ func (am *AuthenticateMsg) SetWorkstation(ws []byte) {
 	}
 
 	if am.NegotiateFlags&NEGOTIATE_UNICODE_CHARSET != 0 {
-		am.WorkstationLen = uint16(2 * len(ws))
+		encoded := encodeUTF16LE(ws)
+		am.WorkstationLen = uint16(len(encoded))
 		am.WorkstationMaxLen = am.WorkstationLen
 		am.WorkstationBufferOffset = am.offset
-		am.Payload = append(am.Payload, encodeUTF16LE(ws)...)
+		am.Payload = append(am.Payload, encoded...)
 	} else {
 		am.WorkstationLen = uint16(len(ws))
 		am.WorkstationMaxLen = am.WorkstationLen
@@ -430,29 +552,11 @@ func (am *AuthenticateMsg) SetWorkstation(ws []byte) {
 	am.offset += uint32(am.WorkstationLen)
 }
 
-func (am *AuthenticateMsg) SetLmResponse(version int, challenge []byte, pwd []byte) {
+func (am *AuthenticateMsg) SetLmChallengeResponse(lmresp []byte) {
 	if am.LmChallengeResponseLen != 0 {
 		panic("Can't set LmResponse field repeatedly")
 	}
 
-	var lmresp []byte
-	if version == 1 {
-		lmresp = ComputeLMResponse(challenge, LmHash(pwd))
-	} else if version == 2 {
-		usernameWithDomainOrServer := am.UserNameBytes()
-		domain := am.DomainNameBytes()
-		if len(domain) != 0 {
-			usernameWithDomainOrServer = append(usernameWithDomainOrServer, domain...)
-		} else {
-			workstation := am.WorkstationBytes()
-			if len(workstation) != 0 {
-				usernameWithDomainOrServer = append(usernameWithDomainOrServer, workstation...)
-			}
-		}
-
-		lmresp = ComputeLMv2Response(challenge, usernameWithDomainOrServer, NtHash(pwd), nil)
-	}
-
 	am.LmChallengeResponseLen = uint16(len(lmresp))
 	am.LmChallengeResponseMaxLen = am.LmChallengeResponseLen
 	am.LmChallengeResponseBufferOffset = am.offset
@@ -460,60 +564,181 @@ func (am *AuthenticateMsg) SetLmResponse(version int, challenge []byte, pwd []by
 	am.offset += uint32(am.LmChallengeResponseLen)
 }
 
-func (am *AuthenticateMsg) SetNtResponse(version int, challenge []byte, pwd []byte) {
+func (am *AuthenticateMsg) SetNtChallengeResponse(ntresp []byte) {
 	if am.NtChallengeResponseLen != 0 {
 		panic("Can't set NtResponse field repeatedly")
 	}
 
+	am.NtChallengeResponseLen = uint16(len(ntresp))
+	am.NtChallengeResponseMaxLen = am.NtChallengeResponseLen
+	am.NtChallengeResponseBufferOffset = am.offset
+	am.Payload = append(am.Payload, ntresp...)
+	am.offset += uint32(am.NtChallengeResponseLen)
+}
+
+func (am *AuthenticateMsg) SetEncryptedRandomSessionKey(key []byte) {
+	if am.EncryptedRandomSessionKeyLen != 0 {
+		panic("Can't set EncryptedRandomSessionKey field repeatedly")
+	}
+
+	am.EncryptedRandomSessionKeyLen = uint16(len(key))
+	am.EncryptedRandomSessionKeyMaxLen = am.EncryptedRandomSessionKeyLen
+	am.EncryptedRandomSessionKeyBufferOffset = am.offset
+	am.Payload = append(am.Payload, key...)
+	am.offset += uint32(am.EncryptedRandomSessionKeyLen)
+}
+
+// The NTLMv2 hash is keyed by the domain, or by the server name when
+// authenticating a local account.
+func (am AuthenticateMsg) domainOrServer() string {
+	if am.DomainNameLen != 0 {
+		return am.DomainName()
+	}
+	return am.Workstation()
+}
+
+func (am *AuthenticateMsg) SetLmResponse(version int, challenge []byte, pwd []byte) {
+	var lmresp []byte
+	if version == 1 {
+		lmresp = ComputeLMv1Response(LmHash(pwd), challenge)
+	} else if version == 2 {
+		clientChallenge := make([]byte, 8)
+		io.ReadFull(Rand, clientChallenge)
+
+		lmresp = ComputeLMv2Response(ntowfv2(NtHash(pwd), am.UserName(), am.domainOrServer()), challenge, clientChallenge)
+	}
+
+	am.SetLmChallengeResponse(lmresp)
+}
+
+func (am *AuthenticateMsg) SetNtResponse(version int, challenge []byte, pwd []byte) {
 	var ntresp []byte
 	if version == 1 {
-		ntresp = ComputeNTLMv1Response(challenge, NtHash(pwd))
+		ntresp = ComputeNTv1Response(NtHash(pwd), challenge)
 	} else if version == 2 {
-		usernameWithDomainOrServer := am.UserNameBytes()
-		domain := am.DomainNameBytes()
-		if len(domain) != 0 {
-			usernameWithDomainOrServer = append(usernameWithDomainOrServer, domain...)
-		} else {
-			workstation := am.WorkstationBytes()
-			if len(workstation) != 0 {
-				usernameWithDomainOrServer = append(usernameWithDomainOrServer, workstation...)
-			}
-		}
+		clientChallenge := make([]byte, 8)
+		io.ReadFull(Rand, clientChallenge)
+		timestamp := WindowsTimestamp(time.Now())
 
-		ntresp = ComputeNTLMv2Response(challenge, usernameWithDomainOrServer, NtHash(pwd), nil)
+		ntresp, _ = ComputeNTLMv2Response(ntowfv2(NtHash(pwd), am.UserName(), am.domainOrServer()),
+			challenge, clientChallenge, timestamp, []byte{0, 0, 0, 0})
 	}
 
-	am.NtChallengeResponseLen = uint16(len(ntresp))
-	am.NtChallengeResponseMaxLen = am.NtChallengeResponseLen
-	am.NtChallengeResponseBufferOffset = am.offset
-	am.Payload = append(am.Payload, ntresp...)
-	am.offset += uint32(am.NtChallengeResponseLen)
+	am.SetNtChallengeResponse(ntresp)
 }
 
 func (am *AuthenticateMsg) SetNTLMResponse(version int, challenge []byte, pwd []byte) {
 	if version == 1 && am.NegotiateFlags&NEGOTIATE_EXTENDED_SESSION_SECURITY != 0 {
-		nonce := [24]byte{}
-		rand.Read(nonce[:8])
-		am.LmChallengeResponseLen = 24
-		am.LmChallengeResponseMaxLen = 24
-		am.LmChallengeResponseBufferOffset = am.offset
-		am.Payload = append(am.Payload, nonce[:]...)
-		am.offset += uint32(am.LmChallengeResponseLen)
-
-		ntsresp := ComputeNTLMv2SessionResponse(challenge, nonce[:8], NtHash([]byte(pwd)))
-		am.NtChallengeResponseLen = uint16(len(ntsresp))
-		am.NtChallengeResponseMaxLen = am.NtChallengeResponseLen
-		am.NtChallengeResponseBufferOffset = am.offset
-		am.Payload = append(am.Payload, ntsresp...)
-		am.offset += uint32(am.NtChallengeResponseLen)
-
+		nonce := make([]byte, 8)
+		io.ReadFull(Rand, nonce)
+		lmresp, ntresp := ComputeNTLM2SessionResponse(NtHash(pwd), challenge, nonce)
+		am.SetLmChallengeResponse(lmresp)
+		am.SetNtChallengeResponse(ntresp)
 	} else {
 		am.SetLmResponse(version, challenge, pwd)
 		am.SetNtResponse(version, challenge, pwd)
 	}
 }
 
+// Anonymous authentication, MS-NLMP 3.1.5.1.2: NEGOTIATE_ANONYMOUS is
+// set, LmChallengeResponse is a single zero byte and NtChallengeResponse,
+// UserName and DomainName are empty.
+func (am *AuthenticateMsg) SetAnonymous() {
+	am.NegotiateFlags |= NEGOTIATE_ANONYMOUS
+	am.SetLmChallengeResponse([]byte{0})
+	am.SetNtChallengeResponse(nil)
+}
+
+// Empty UserName and NtChallengeResponse and an LmChallengeResponse that is
+// empty or a single zero byte, MS-NLMP 3.3. NEGOTIATE_ANONYMOUS alone does
+// not make a message anonymous.
+func (am AuthenticateMsg) IsAnonymous() bool {
+	lmresp := am.LmChallengeResponse()
+	return am.NtChallengeResponseLen == 0 && am.UserNameLen == 0 &&
+		(len(lmresp) == 0 || (len(lmresp) == 1 && lmresp[0] == 0))
+}
+
+// Return a copy of the challenge's target info with the MIC provided bit
+// set in MsvAvFlags. The NTLMv2 response must be computed over this target
+// info when a MIC is sent, since the bit is protected by the NTProofStr.
+func TargetInfoWithMIC(targetInfo []byte) ([]byte, error) {
+	pairs, err := ParseAVPairsOrdered(targetInfo)
+	if err != nil {
+		return nil, err
+	}
+	pairs.SetMICFlag()
+	return pairs.Bytes(), nil
+}
+
+// MS-NLMP 3.1.5.1.2, MIC is HMAC_MD5(ExportedSessionKey,
+// NEGOTIATE_MESSAGE || CHALLENGE_MESSAGE || AUTHENTICATE_MESSAGE) computed
+// with the MIC field zeroed. The MIC field must have been reserved with
+// ReserveMIC. SetMIC can't set the MIC provided bit itself: for NTLMv2 it
+// is in the target info under the NTProofStr, so the NT response must
+// already have been computed over TargetInfoWithMIC, an error otherwise.
+func (am *AuthenticateMsg) SetMIC(sessionKey, negotiateMsg, challengeMsg []byte) error {
+	if !am.hasMIC {
+		return fmt.Errorf("ntlmssp: MIC field not reserved")
+	}
+
+	if ntresp := am.NtChallengeResponseBytes(); len(ntresp) > 24 {
+		flags, err := am.responseAvFlags()
+		if err != nil {
+			return err
+		}
+		if flags&MsvAvFlagMICProvided == 0 {
+			return fmt.Errorf("ntlmssp: MIC provided bit not set in the NTLMv2 response MsvAvFlags")
+		}
+	}
+
+	copy(am.Payload[8:24], make([]byte, 16))
+	msg := append(append(append([]byte{}, negotiateMsg...), challengeMsg...), am.Bytes()...)
+	copy(am.Payload[8:24], hmacMd5(sessionKey, msg))
+	return nil
+}
+
+// MsvAvFlags of the NTLMv2 response, 0 for an NTLMv1 or empty response
+func (am *AuthenticateMsg) responseAvFlags() (uint32, error) {
+	ntresp := am.NtChallengeResponseBytes()
+	if len(ntresp) <= 24 {
+		return 0, nil
+	}
+	_, blob, err := ParseNTLMv2ResponseBlob(ntresp)
+	if err != nil {
+		return 0, fmt.Errorf("%w: NT response of %d bytes is neither NTLMv1 nor NTLMv2", ErrMalformedMessage, len(ntresp))
+	}
+	return blob.TargetInfo.Flags(), nil
+}
+
+// Verify the MIC of a received AUTHENTICATE_MESSAGE. The comparison is
+// done in constant time.
+func VerifyMIC(auth *AuthenticateMsg, sessionKey, negotiateMsg, challengeMsg []byte) (bool, error) {
+	flags, err := auth.responseAvFlags()
+	if err != nil {
+		return false, err
+	}
+	claimed := flags&MsvAvFlagMICProvided != 0
+
+	mic := auth.MIC()
+	if mic == nil || hmac.Equal(mic, make([]byte, 16)) {
+		if claimed {
+			return false, ErrMICMissing
+		}
+		return false, fmt.Errorf("ntlmssp: no MIC in authenticate message")
+	}
+
+	bs := auth.Bytes()
+	copy(bs[AuthenticateMsgPayloadOffset+8:AuthenticateMsgPayloadOffset+24], make([]byte, 16))
+	expected := hmacMd5(sessionKey, append(append(append([]byte{}, negotiateMsg...), challengeMsg...), bs...))
+	return hmac.Equal(mic, expected), nil
+}
+
+// Back to the state of NewAuthenticateMsg(nil), see ChallengeMsg.Reset
 func (am *AuthenticateMsg) Reset() {
-	am.Payload = nil
-	am.offset = AuthenticateMsgPayloadOffset
+	*am = AuthenticateMsg{
+		Signature:   [8]byte{'N', 'T', 'L', 'M', 'S', 'S', 'P', 0},
+		MessageType: 0x03,
+		Payload:     am.Payload[:0],
+		offset:      AuthenticateMsgPayloadOffset,
+	}
 }
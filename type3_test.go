@@ -0,0 +1,343 @@
+package ntlmssp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestAuthenticateMsg_UnMarshal(t *testing.T) {
+	bs, _ := base64.StdEncoding.DecodeString("TlRMTVNTUAADAAAAGAAYAFAAAAAwADAAaAAAAAYABgBKAAAACgAKAEAAAAAAAAAAAAAAAAAAAAAAAAAABTCJoGEAZABtAGkAbgBMAEEAQgDKWtAQahWyLGUi6N0I3Y89TQ//e2QL4SPYLBXpg00OEIk5edtauBUdAQEAAAAAAAArN+A/oD/WAQRU5zwV4quKAAAAAAAAAAA=")
+	type3, err := NewAuthenticateMsg(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if type3.UserName() != "admin" || type3.DomainName() != "LAB" {
+		t.Errorf("UserName = %q, DomainName = %q", type3.UserName(), type3.DomainName())
+	}
+	if type3.MIC() != nil {
+		t.Errorf("unexpected MIC %x", type3.MIC())
+	}
+	if len(type3.LmChallengeResponse()) != 24 || len(type3.NtChallengeResponseBytes()) != 48 {
+		t.Errorf("bad response lengths")
+	}
+	if !bytes.Equal(type3.Marshal('<'), bs) {
+		t.Errorf("Marshal(UnMarshal(x)) != x")
+	}
+
+	for _, c := range [][]byte{{}, bs[:AuthenticateMsgPayloadOffset-1], bs[:len(bs)-1]} {
+		if _, err := NewAuthenticateMsg(c); err == nil {
+			t.Errorf("expected error for %d bytes", len(c))
+		}
+	}
+}
+
+func TestAuthenticateMsg_Build(t *testing.T) {
+	lmresp := bytes.Repeat([]byte{0x11}, 24)
+	ntresp := bytes.Repeat([]byte{0x22}, 24)
+	key := bytes.Repeat([]byte{0x33}, 16)
+
+	type3, _ := NewAuthenticateMsg(nil)
+	type3.NegotiateFlags = NEGOTIATE_UNICODE_CHARSET | NEGOTIATE_NTLM | NEGOTIATE_EXPLICIT_KEY_EXCHANGE
+	type3.ReserveMIC()
+	type3.SetLmChallengeResponse(lmresp)
+	type3.SetNtChallengeResponse(ntresp)
+	type3.SetDomainName([]byte("LAB"))
+	type3.SetUserName([]byte("admin"))
+	type3.SetWorkstation([]byte("WIN-123456"))
+	type3.SetEncryptedRandomSessionKey(key)
+
+	bs := type3.Marshal('<')
+	parsed, err := NewAuthenticateMsg(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.LmChallengeResponseBufferOffset != AuthenticateMsgPayloadOffset+8+16 {
+		t.Errorf("LmChallengeResponseBufferOffset = %d", parsed.LmChallengeResponseBufferOffset)
+	}
+	if !bytes.Equal(parsed.MIC(), make([]byte, 16)) {
+		t.Errorf("MIC = %x", parsed.MIC())
+	}
+	if !bytes.Equal(parsed.LmChallengeResponse(), lmresp) ||
+		!bytes.Equal(parsed.NtChallengeResponseBytes(), ntresp) ||
+		!bytes.Equal(parsed.EncryptedRandomSessionKey(), key) {
+		t.Errorf("response buffers don't match")
+	}
+	if parsed.UserName() != "admin" || parsed.DomainName() != "LAB" || parsed.Workstation() != "WIN-123456" {
+		t.Errorf("UserName = %q, DomainName = %q, Workstation = %q",
+			parsed.UserName(), parsed.DomainName(), parsed.Workstation())
+	}
+	if !bytes.Equal(parsed.Marshal('<'), bs) {
+		t.Errorf("Marshal(UnMarshal(x)) != x")
+	}
+}
+
+func TestAuthenticateMsg_SetMIC(t *testing.T) {
+	sessionKey := bytes.Repeat([]byte{0x55}, 16)
+	serverChallenge := decodeHex("0123456789abcdef")
+
+	type1, _ := NewNegotiateMsg(nil)
+	type1.NegotiateFlags = NEGOTIATE_UNICODE_CHARSET | NEGOTIATE_NTLM | NEGOTIATE_EXTENDED_SESSION_SECURITY
+	negotiateMsg := type1.Marshal('<')
+
+	type2, _ := NewChallengeMsg(nil)
+	type2.NegotiateFlags = type1.NegotiateFlags
+	type2.SetServerChallenge(serverChallenge)
+	type2.SetTargetInfo(map[string]interface{}{"MsvAvNbDomainName": "Domain"})
+	challengeMsg := type2.Marshal('<')
+
+	build := func(targetInfo []byte) *AuthenticateMsg {
+		type3, _ := NewAuthenticateMsg(nil)
+		type3.NegotiateFlags = type2.NegotiateFlags
+		type3.ReserveMIC()
+		ntresp, _ := ComputeNTLMv2Response(NTOWFv2("Password", "User", "Domain"),
+			serverChallenge, decodeHex("aaaaaaaaaaaaaaaa"), make([]byte, 8), targetInfo)
+		type3.SetLmChallengeResponse(make([]byte, 24))
+		type3.SetNtChallengeResponse(ntresp)
+		type3.SetDomainName([]byte("Domain"))
+		type3.SetUserName([]byte("User"))
+		return type3
+	}
+
+	if err := build(type2.TargetInfo()).SetMIC(sessionKey, negotiateMsg, challengeMsg); err == nil {
+		t.Errorf("expected error without the MIC provided bit")
+	}
+
+	withMIC, err := TargetInfoWithMIC(type2.TargetInfo())
+	if err != nil {
+		t.Fatal(err)
+	}
+	type3 := build(withMIC)
+	if err := type3.SetMIC(sessionKey, negotiateMsg, challengeMsg); err != nil {
+		t.Fatal(err)
+	}
+	mic := append([]byte{}, type3.MIC()...)
+
+	zeroed := type3.Marshal('<')
+	copy(zeroed[72:88], make([]byte, 16))
+	want := hmacMd5(sessionKey, append(append(append([]byte{}, negotiateMsg...), challengeMsg...), zeroed...))
+	if !bytes.Equal(mic, want) {
+		t.Errorf("MIC = %x, want %x", mic, want)
+	}
+
+	other := build(withMIC)
+	if err := other.SetMIC(sessionKey, negotiateMsg, challengeMsg); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(other.MIC(), mic) {
+		t.Errorf("MIC is not deterministic: %x != %x", other.MIC(), mic)
+	}
+	// SetMIC can be called again, the MIC field is zeroed before hashing
+	if err := type3.SetMIC(sessionKey, negotiateMsg, challengeMsg); err != nil || !bytes.Equal(type3.MIC(), mic) {
+		t.Errorf("second SetMIC = %x, %v", type3.MIC(), err)
+	}
+
+	for _, n := range []int{25, 30, 43, 47} {
+		short, _ := NewAuthenticateMsg(nil)
+		short.ReserveMIC()
+		short.SetNtChallengeResponse(make([]byte, n))
+		if err := short.SetMIC(sessionKey, negotiateMsg, challengeMsg); !errors.Is(err, ErrMalformedMessage) {
+			t.Errorf("SetMIC with a %d byte NT response = %v, want ErrMalformedMessage", n, err)
+		}
+	}
+}
+
+func TestVerifyMIC(t *testing.T) {
+	sessionKey := bytes.Repeat([]byte{0x55}, 16)
+	serverChallenge := decodeHex("0123456789abcdef")
+
+	type1, _ := NewNegotiateMsg(nil)
+	type1.NegotiateFlags = NEGOTIATE_UNICODE_CHARSET | NEGOTIATE_NTLM
+	negotiateMsg := type1.Marshal('<')
+
+	type2, _ := NewChallengeMsg(nil)
+	type2.NegotiateFlags = type1.NegotiateFlags
+	type2.SetServerChallenge(serverChallenge)
+	type2.SetTargetInfo(map[string]interface{}{"MsvAvNbDomainName": "Domain"})
+	challengeMsg := type2.Marshal('<')
+
+	type3, _ := NewAuthenticateMsg(nil)
+	type3.NegotiateFlags = type2.NegotiateFlags
+	type3.ReserveMIC()
+	withMIC, err := TargetInfoWithMIC(type2.TargetInfo())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ntresp, _ := ComputeNTLMv2Response(NTOWFv2("Password", "User", "Domain"),
+		serverChallenge, decodeHex("aaaaaaaaaaaaaaaa"), make([]byte, 8), withMIC)
+	type3.SetNtChallengeResponse(ntresp)
+	type3.SetUserName([]byte("User"))
+	if err := type3.SetMIC(sessionKey, negotiateMsg, challengeMsg); err != nil {
+		t.Fatal(err)
+	}
+	bs := type3.Marshal('<')
+
+	parse := func(bs []byte) *AuthenticateMsg {
+		am, err := NewAuthenticateMsg(bs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return am
+	}
+
+	if ok, err := VerifyMIC(parse(bs), sessionKey, negotiateMsg, challengeMsg); !ok || err != nil {
+		t.Errorf("valid MIC: %v, %v", ok, err)
+	}
+
+	tampered := append([]byte{}, bs...)
+	tampered[len(tampered)-1] ^= 1
+	if ok, err := VerifyMIC(parse(tampered), sessionKey, negotiateMsg, challengeMsg); ok || err != nil {
+		t.Errorf("tampered message: %v, %v", ok, err)
+	}
+	if ok, _ := VerifyMIC(parse(bs), sessionKey, negotiateMsg[1:], challengeMsg); ok {
+		t.Errorf("tampered negotiate message accepted")
+	}
+
+	stripped := append([]byte{}, bs...)
+	copy(stripped[72:88], make([]byte, 16))
+	if ok, err := VerifyMIC(parse(stripped), sessionKey, negotiateMsg, challengeMsg); ok || err != ErrMICMissing {
+		t.Errorf("missing MIC: %v, %v", ok, err)
+	}
+
+	for _, n := range []int{25, 30, 43, 47} {
+		short, _ := NewAuthenticateMsg(nil)
+		short.ReserveMIC()
+		short.SetNtChallengeResponse(make([]byte, n))
+		if ok, err := VerifyMIC(parse(short.Bytes()), sessionKey, negotiateMsg, challengeMsg); ok || !errors.Is(err, ErrMalformedMessage) {
+			t.Errorf("VerifyMIC with a %d byte NT response = %v, %v, want ErrMalformedMessage", n, ok, err)
+		}
+	}
+}
+
+func TestAuthenticateMsg_Decompose(t *testing.T) {
+	// NTLMv2 AUTHENTICATE_MESSAGE of LAB\admin
+	bs, _ := base64.StdEncoding.DecodeString("TlRMTVNTUAADAAAAGAAYAFAAAAAwADAAaAAAAAYABgBKAAAACgAKAEAAAAAAAAAAAAAAAAAAAAAAAAAABTCJoGEAZABtAGkAbgBMAEEAQgDKWtAQahWyLGUi6N0I3Y89TQ//e2QL4SPYLBXpg00OEIk5edtauBUdAQEAAAAAAAArN+A/oD/WAQRU5zwV4quKAAAAAAAAAAA=")
+	type3, err := NewAuthenticateMsg(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if type3.UserName() != "admin" || type3.DomainName() != "LAB" || type3.Workstation() != "" {
+		t.Errorf("UserName = %q, DomainName = %q, Workstation = %q", type3.UserName(), type3.DomainName(), type3.Workstation())
+	}
+	if !bytes.Equal(type3.LMResponse(), bs[0x50:0x68]) {
+		t.Errorf("LMResponse = %x", type3.LMResponse())
+	}
+	if !bytes.Equal(type3.NTResponse(), bs[0x68:0x98]) {
+		t.Errorf("NTResponse = %x", type3.NTResponse())
+	}
+	if !type3.IsNTLMv2() {
+		t.Error("IsNTLMv2 = false for a 48 bytes NT response")
+	}
+
+	v1, _ := NewAuthenticateMsg(nil)
+	v1.SetNtChallengeResponse(make([]byte, 24))
+	if v1.IsNTLMv2() {
+		t.Error("IsNTLMv2 = true for a 24 bytes NT response")
+	}
+}
+
+func TestResponseType(t *testing.T) {
+	// MS-NLMP 4.2.2, 4.2.3 and 4.2.4 responses
+	ntlmv2, _ := ComputeNTLMv2Response(NTOWFv2("Password", "User", "Domain"), decodeHex("0123456789abcdef"),
+		decodeHex("aaaaaaaaaaaaaaaa"), make([]byte, 8), decodeHex("02000c0044006f006d00610069006e0000000000"))
+	cases := []struct {
+		name string
+		lm   string
+		nt   string
+		typ  string
+		msg  string
+	}{
+		{"NTLMv1", "98def7b87f88aa5dafe2df779688a172def11c7d5ccdef13",
+			"67c43011f30298a2ad35ece64f16331c44bdbed927841f94", "NTLMv1", "NTLMv1"},
+		{"NTLM2 session", "aaaaaaaaaaaaaaaa00000000000000000000000000000000",
+			"7537f803ae367128ca458204bde7caf81e97ed2683267232", "NTLMv1", "NTLM2Session"},
+		{"NTLMv2", "86c35097ac9cec102554764a57cccc19aaaaaaaaaaaaaaaa", hex.EncodeToString(ntlmv2), "NTLMv2", "NTLMv2"},
+		{"LM only", "98def7b87f88aa5dafe2df779688a172def11c7d5ccdef13", "", "Anonymous", "LMv1"},
+		{"anonymous", "00", "", "Anonymous", "Anonymous"},
+		{"unknown", "", "0102030405060708", "", ""},
+	}
+
+	for _, c := range cases {
+		if got := ResponseType(decodeHex(c.nt)); got != c.typ {
+			t.Errorf("%s: ResponseType = %q, want %q", c.name, got, c.typ)
+		}
+
+		am, _ := NewAuthenticateMsg(nil)
+		am.SetLmChallengeResponse(decodeHex(c.lm))
+		am.SetNtChallengeResponse(decodeHex(c.nt))
+		if c.name != "anonymous" {
+			am.SetUserName([]byte("User"))
+		}
+		if got := am.ResponseType(); got != c.msg {
+			t.Errorf("%s: AuthenticateMsg.ResponseType = %q, want %q", c.name, got, c.msg)
+		}
+	}
+}
+
+func TestAuthenticateMsg_Validate(t *testing.T) {
+	valid, _ := NewAuthenticateMsg(nil)
+	valid.NegotiateFlags = NEGOTIATE_UNICODE_CHARSET | NEGOTIATE_NTLM | NEGOTIATE_EXPLICIT_KEY_EXCHANGE
+	valid.ReserveMIC()
+	valid.SetLmChallengeResponse(bytes.Repeat([]byte{0x11}, 24))
+	valid.SetNtChallengeResponse(bytes.Repeat([]byte{0x22}, 24))
+	valid.SetDomainName([]byte("Domain"))
+	valid.SetUserName([]byte("User"))
+	valid.SetWorkstation([]byte("WS"))
+	valid.SetEncryptedRandomSessionKey(bytes.Repeat([]byte{0x33}, 16))
+	if err := valid.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, patch := range map[string]func(am *AuthenticateMsg){
+		"overlap": func(am *AuthenticateMsg) { am.UserNameBufferOffset = am.NtChallengeResponseBufferOffset + 4 },
+		"maxlen":  func(am *AuthenticateMsg) { am.NtChallengeResponseMaxLen = am.NtChallengeResponseLen - 1 },
+		"header":  func(am *AuthenticateMsg) { am.DomainNameBufferOffset = 40 },
+		"MIC":     func(am *AuthenticateMsg) { am.LmChallengeResponseBufferOffset = AuthenticateMsgPayloadOffset + 8 },
+		"end": func(am *AuthenticateMsg) {
+			am.EncryptedRandomSessionKeyBufferOffset = AuthenticateMsgPayloadOffset + uint32(len(am.Payload)) - 8
+		},
+	} {
+		am, err := NewAuthenticateMsg(valid.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		patch(am)
+		if err := am.Validate(); !errors.Is(err, ErrMalformedMessage) {
+			t.Errorf("%s: Validate = %v, want ErrMalformedMessage", name, err)
+		}
+	}
+}
+
+func TestTargetInfoWithMIC(t *testing.T) {
+	// more than the 11 pairs ReadAvPairs stops at, channel bindings last
+	pairs := new(AvPairs)
+	for i := 0; i < 12; i++ {
+		pairs.List = append(pairs.List, AvPair{AvId: MsvAvTargetName, AvLen: 2, Value: []byte{byte('a' + i), 0}})
+	}
+	pairs.Set(MsvChannelBindings, bytes.Repeat([]byte{0xcb}, 16))
+	targetInfo := pairs.Marshal()
+
+	bs, err := TargetInfoWithMIC(targetInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseAVPairsOrdered(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.List) != len(pairs.List)+1 || got.Flags() != MsvAvFlagMICProvided {
+		t.Errorf("TargetInfoWithMIC(%x) = %x", targetInfo, bs)
+	}
+	if cb := got.Get(MsvChannelBindings); !bytes.Equal(cb, bytes.Repeat([]byte{0xcb}, 16)) {
+		t.Errorf("MsvChannelBindings = %x", cb)
+	}
+
+	if _, err := TargetInfoWithMIC(decodeHex("02000c0044006f00")); !errors.Is(err, ErrMalformedMessage) {
+		t.Errorf("truncated target info: err = %v, want ErrMalformedMessage", err)
+	}
+}
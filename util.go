@@ -4,12 +4,36 @@ import (
 	"crypto/des"
 	"crypto/hmac"
 	"crypto/md5"
-	"reflect"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
 	"strings"
+	"time"
 	"unicode/utf16"
-	"unsafe"
+
+	"golang.org/x/crypto/md4"
 )
 
+// Source of the server challenges, client challenges and session keys.
+// Tests may replace it with a deterministic reader.
+var Rand io.Reader = rand.Reader
+
+// Source of the local host name, for the default Client.Workstation and
+// Server.ComputerName. Tests may replace it.
+var Hostname = os.Hostname
+
+// First label of Hostname, uppercased like a NetBIOS name
+func localComputerName() string {
+	name, _ := Hostname()
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		name = name[:i]
+	}
+	return strings.ToUpper(name)
+}
+
 func displayBits(offset int, set bool) string {
 	buf := strings.Builder{}
 	for i := 0; i < 8; i++ {
@@ -29,6 +53,26 @@ func displayBits(offset int, set bool) string {
 	return buf.String()
 }
 
+// binary.BigEndian for '>', binary.LittleEndian otherwise
+func byteOrder(endian byte) binary.ByteOrder {
+	if endian == '>' {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+func appendUint16(bs []byte, order binary.ByteOrder, v uint16) []byte {
+	bs = append(bs, 0, 0)
+	order.PutUint16(bs[len(bs)-2:], v)
+	return bs
+}
+
+func appendUint32(bs []byte, order binary.ByteOrder, v uint32) []byte {
+	bs = append(bs, 0, 0, 0, 0)
+	order.PutUint32(bs[len(bs)-4:], v)
+	return bs
+}
+
 func bytes2Uint(bs []byte, endian byte) uint64 {
 	var u uint64
 	if endian == '>' {
@@ -43,35 +87,75 @@ func bytes2Uint(bs []byte, endian byte) uint64 {
 	return u
 }
 
-// Only for ascii
-func encodeUTF16LE(bs []byte) []byte {
-	output := make([]byte, 0, len(bs)*2)
-	for i := 0; i < len(bs); i++ {
-		output = append(output, bs[i])
-		output = append(output, 0)
+// UTF-16LE of a UTF-8 string, as NTLM sends Unicode strings and hashes
+// passwords. Code points outside the BMP become surrogate pairs.
+func EncodeUTF16LE(s string) []byte {
+	units := utf16.Encode([]rune(s))
+	output := make([]byte, 2*len(units))
+	for i, u := range units {
+		binary.LittleEndian.PutUint16(output[2*i:], u)
 	}
 	return output
 }
 
-// UTF16 multi bytes to string
-func bytes2StringUTF16(bs []byte) string {
-	ptr := (*reflect.SliceHeader)(unsafe.Pointer(&bs))
-	(*ptr).Len = ptr.Len / 2
+// Inverse of EncodeUTF16LE, an odd number of bytes is an error. Unpaired
+// surrogates decode to U+FFFD.
+func DecodeUTF16LE(bs []byte) (string, error) {
+	if len(bs)%2 != 0 {
+		return "", fmt.Errorf("%w: UTF-16 string of %d bytes", ErrMalformedMessage, len(bs))
+	}
+	return bytes2StringUTF16(bs), nil
+}
 
-	s := (*[]uint16)(unsafe.Pointer(&bs))
-	return string(utf16.Decode(*s))
+func encodeUTF16LE(bs []byte) []byte {
+	return EncodeUTF16LE(string(bs))
 }
 
-func padding(bs []byte) []byte {
+// Like DecodeUTF16LE, a trailing odd byte is dropped
+func bytes2StringUTF16(bs []byte) string {
+	s := make([]uint16, len(bs)/2)
+	for i := range s {
+		s[i] = binary.LittleEndian.Uint16(bs[2*i:])
+	}
+	return string(utf16.Decode(s))
+}
+
+// Expand a 7 bytes key to the 8 bytes DES key of LM, NTLMv1 and the LM
+// session key: every byte takes the next 7 key bits, most significant
+// first, and the lowest bit is the odd parity bit.
+func expandDESKey(bs []byte) []byte {
 	output := make([]byte, 0, 8)
-	output = append(output, 1+(bs[0]>>1)<<1)
-	output = append(output, 1+((bs[0]&1)<<7)+((bs[1]&0b11111100)>>1))
-	output = append(output, 1+((bs[1]&0b11)<<6)+((bs[2]&0b11111000)>>2))
-	output = append(output, 1+((bs[2]&0b111)<<5)+((bs[3]&0b11110000)>>3))
-	output = append(output, 1+((bs[3]&0b1111)<<4)+((bs[4]&0b11100000)>>4))
-	output = append(output, 1+((bs[4]&0b11111)<<3)+((bs[5]&0b11000000)>>5))
-	output = append(output, 1+((bs[5]&0b111111)<<2)+((bs[6]&0b10000000)>>6))
-	output = append(output, 1+(bs[6]&0b1111111)<<1)
+	output = append(output, oddParity(bs[0]&0b11111110))
+	output = append(output, oddParity(((bs[0]&1)<<7)+((bs[1]&0b11111100)>>1)))
+	output = append(output, oddParity(((bs[1]&0b11)<<6)+((bs[2]&0b11111000)>>2)))
+	output = append(output, oddParity(((bs[2]&0b111)<<5)+((bs[3]&0b11110000)>>3)))
+	output = append(output, oddParity(((bs[3]&0b1111)<<4)+((bs[4]&0b11100000)>>4)))
+	output = append(output, oddParity(((bs[4]&0b11111)<<3)+((bs[5]&0b11000000)>>5)))
+	output = append(output, oddParity(((bs[5]&0b111111)<<2)+((bs[6]&0b10000000)>>6)))
+	output = append(output, oddParity((bs[6]&0b1111111)<<1))
+	return output
+}
+
+func oddParity(b byte) byte {
+	if bits.OnesCount8(b)%2 == 0 {
+		b |= 1
+	}
+	return b
+}
+
+// Split a 16 bytes hash padded with zeros to 21 bytes into three DES keys
+func splitDESKeys(hash []byte) [3][]byte {
+	key := make([]byte, 21)
+	copy(key, hash)
+	return [3][]byte{expandDESKey(key[:7]), expandDESKey(key[7:14]), expandDESKey(key[14:21])}
+}
+
+// MS-NLMP 6 DESL()
+func desl(key []byte, data []byte) []byte {
+	output := make([]byte, 0, 24)
+	for _, k := range splitDESKeys(key) {
+		output = append(output, desEnc(k, data)...)
+	}
 	return output
 }
 
@@ -88,8 +172,26 @@ func hmacMd5(key []byte, msg []byte) []byte {
 	return hsh.Sum(nil)
 }
 
+func md4Hash(msg []byte) []byte {
+	hsh := md4.New()
+	hsh.Write(msg)
+	return hsh.Sum(nil)
+}
+
 func md5Hash(msg []byte) []byte {
 	hsh := md5.New()
 	hsh.Write(msg)
 	return hsh.Sum(nil)
 }
+
+// FILETIME is the number of 100ns intervals since 1601-01-01 UTC
+const fileTimeUnixOffset = 116444736000000000
+
+func fileTimeToTime(ft uint64) time.Time {
+	ticks := int64(ft - fileTimeUnixOffset)
+	return time.Unix(ticks/1e7, ticks%1e7*100).UTC()
+}
+
+func timeToFileTime(t time.Time) uint64 {
+	return uint64(t.UnixNano()/100) + fileTimeUnixOffset
+}
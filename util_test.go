@@ -0,0 +1,79 @@
+package ntlmssp
+
+import (
+	"encoding/hex"
+	"errors"
+	"math/bits"
+	"testing"
+)
+
+func TestExpandDESKey(t *testing.T) {
+	for _, c := range []struct {
+		key, want string
+	}{
+		{"00000000000000", "0101010101010101"},
+		{"ffffffffffffff", "fefefefefefefefe"},
+		{"123456789abcde", "131a15ce89d5f2bc"},
+		// "PASSWOR", the first LMOWFv1 key of "Password"
+		{"50415353574f52", "5120546b34ba3da4"},
+	} {
+		if got := hex.EncodeToString(expandDESKey(decodeHex(c.key))); got != c.want {
+			t.Errorf("expandDESKey(%s) = %s, want %s", c.key, got, c.want)
+		}
+	}
+
+	key := []byte{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde}
+	expanded := expandDESKey(key)
+
+	var in, out uint64
+	for i := 0; i < 7; i++ {
+		in = in<<8 | uint64(key[i])
+	}
+	for i := 0; i < 8; i++ {
+		if bits.OnesCount8(expanded[i])%2 != 1 {
+			t.Errorf("byte %d (%08b) has even parity", i, expanded[i])
+		}
+		out = out<<7 | uint64(expanded[i]>>1)
+	}
+	if in != out {
+		t.Errorf("key bits %014x, want %014x", out, in)
+	}
+}
+
+func TestSplitDESKeys(t *testing.T) {
+	keys := splitDESKeys(NtHash([]byte("Password")))
+	// The third key is the last 2 bytes of the hash followed by 5 zero
+	// bytes, so only its first 3 DES bytes carry key bits
+	if got := keys[2][3:]; string(got) != "\x01\x01\x01\x01\x01" {
+		t.Errorf("third key = %x", keys[2])
+	}
+}
+
+func TestEncodeUTF16LE(t *testing.T) {
+	for _, c := range []struct {
+		s, utf16, ntHash string
+	}{
+		{"Password", "500061007300730077006f0072006400", "a4f49c406510bdcab6824ee7c30fd852"},
+		{"Pässword", "5000e4007300730077006f0072006400", "60da32612d814e31b59f18c43e1ce783"},
+		// U+1F600 is the surrogate pair d83d de00
+		{"P@ss\U0001F600", "50004000730073003dd800de", "f6a1436530bebdf611068268435d5e95"},
+	} {
+		if got := hex.EncodeToString(EncodeUTF16LE(c.s)); got != c.utf16 {
+			t.Errorf("EncodeUTF16LE(%q) = %s, want %s", c.s, got, c.utf16)
+		}
+		if got, err := DecodeUTF16LE(decodeHex(c.utf16)); err != nil || got != c.s {
+			t.Errorf("DecodeUTF16LE(%s) = %q, %v", c.utf16, got, err)
+		}
+		if got := hex.EncodeToString(NTHash(c.s)); got != c.ntHash {
+			t.Errorf("NTHash(%q) = %s, want %s", c.s, got, c.ntHash)
+		}
+	}
+
+	if _, err := DecodeUTF16LE([]byte{0x50, 0, 0x61, 0, 0x73}); !errors.Is(err, ErrMalformedMessage) {
+		t.Errorf("DecodeUTF16LE of 5 bytes: %v", err)
+	}
+	// a lone high surrogate
+	if got, _ := DecodeUTF16LE(decodeHex("3dd8")); got != "\uFFFD" {
+		t.Errorf("DecodeUTF16LE(d83d) = %q", got)
+	}
+}
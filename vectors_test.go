@@ -0,0 +1,110 @@
+package ntlmssp
+
+import (
+	"bytes"
+	"testing"
+
+	tv "github.com/JKme/go-ntlmssp/internal/testvectors"
+)
+
+type vector struct {
+	name string
+	got  func() []byte
+	want []byte
+}
+
+func runVectors(t *testing.T, vectors []vector) {
+	for _, v := range vectors {
+		v := v
+		t.Run(v.name, func(t *testing.T) {
+			if got := v.got(); !bytes.Equal(got, v.want) {
+				t.Fatalf("%s = %x, want %x", v.name, got, v.want)
+			}
+		})
+	}
+}
+
+func seal(flags uint32, sessionKey []byte, signature bool) func() []byte {
+	return func() []byte {
+		sealed, sig, err := NewSecurityContext(flags, sessionKey, "Client").Seal(tv.Plaintext)
+		if err != nil {
+			return nil
+		}
+		if signature {
+			return sig
+		}
+		return sealed
+	}
+}
+
+// MS-NLMP 4.2.2
+func TestVectors_NTLMv1(t *testing.T) {
+	flags := uint32(NEGOTIATE_SIGN | NEGOTIATE_SEAL | NEGOTIATE_128BIT_SESSION_KEY | NEGOTIATE_56BIT_ENCRYPTION)
+	runVectors(t, []vector{
+		{"NTOWFv1", func() []byte { return NTHash(tv.Password) }, tv.NTLMv1NTOWF},
+		{"LMOWFv1", func() []byte { return LMHash(tv.Password) }, tv.NTLMv1LMOWF},
+		{"SessionBaseKey", func() []byte { return md4Hash(tv.NTLMv1NTOWF) }, tv.NTLMv1SessionBaseKey},
+		{"LMKeyExchangeKey", func() []byte {
+			return KXKey(NEGOTIATE_LM_SESSION_KEY, tv.NTLMv1SessionBaseKey, tv.NTLMv1LMChallengeResponse, tv.ServerChallenge, tv.NTLMv1LMOWF)
+		}, tv.NTLMv1LMKeyExchangeKey},
+		{"NonNTKeyExchangeKey", func() []byte {
+			return KXKey(NEGOTIATE_REQUEST_NON_NT_SESSION_KEY, tv.NTLMv1SessionBaseKey, tv.NTLMv1LMChallengeResponse, tv.ServerChallenge, tv.NTLMv1LMOWF)
+		}, tv.NTLMv1NonNTKeyExchangeKey},
+		{"NTChallengeResponse", func() []byte { return ComputeNTv1Response(tv.NTLMv1NTOWF, tv.ServerChallenge) }, tv.NTLMv1NTChallengeResponse},
+		{"LMChallengeResponse", func() []byte { return ComputeLMv1Response(tv.NTLMv1LMOWF, tv.ServerChallenge) }, tv.NTLMv1LMChallengeResponse},
+		{"EncryptedSessionKey", func() []byte { return EncryptSessionKey(tv.NTLMv1SessionBaseKey, tv.RandomSessionKey) }, tv.NTLMv1EncryptedSessionKey},
+		{"SealedPlaintext", seal(flags, tv.RandomSessionKey, false), tv.NTLMv1SealedPlaintext},
+		{"Signature", seal(flags, tv.RandomSessionKey, true), tv.NTLMv1Signature},
+	})
+}
+
+// MS-NLMP 4.2.3
+func TestVectors_NTLM2Session(t *testing.T) {
+	flags := uint32(NEGOTIATE_SIGN | NEGOTIATE_SEAL | NEGOTIATE_EXTENDED_SESSION_SECURITY | NEGOTIATE_56BIT_ENCRYPTION)
+	runVectors(t, []vector{
+		{"LMChallengeResponse", func() []byte {
+			lm, _ := ComputeNTLM2SessionResponse(tv.NTLMv1NTOWF, tv.ServerChallenge, tv.ClientChallenge)
+			return lm
+		}, tv.NTLM2SessionLMChallengeResponse},
+		{"NTChallengeResponse", func() []byte {
+			_, nt := ComputeNTLM2SessionResponse(tv.NTLMv1NTOWF, tv.ServerChallenge, tv.ClientChallenge)
+			return nt
+		}, tv.NTLM2SessionNTChallengeResponse},
+		{"KeyExchangeKey", func() []byte {
+			return KXKey(flags, tv.NTLMv1SessionBaseKey, tv.NTLM2SessionLMChallengeResponse, tv.ServerChallenge, tv.NTLMv1LMOWF)
+		}, tv.NTLM2SessionKeyExchangeKey},
+		{"ClientSignKey", func() []byte { return SignKey(flags, tv.NTLM2SessionKeyExchangeKey, "Client") }, tv.NTLM2SessionClientSignKey},
+		{"ClientSealKey", func() []byte { return SealKey(flags, tv.NTLM2SessionKeyExchangeKey, "Client") }, tv.NTLM2SessionClientSealKey},
+		{"SealedPlaintext", seal(flags, tv.NTLM2SessionKeyExchangeKey, false), tv.NTLM2SessionSealedPlaintext},
+		{"Signature", seal(flags, tv.NTLM2SessionKeyExchangeKey, true), tv.NTLM2SessionSignature},
+	})
+}
+
+// MS-NLMP 4.2.4
+func TestVectors_NTLMv2(t *testing.T) {
+	flags := uint32(NEGOTIATE_SIGN | NEGOTIATE_SEAL | NEGOTIATE_EXTENDED_SESSION_SECURITY | NEGOTIATE_EXPLICIT_KEY_EXCHANGE |
+		NEGOTIATE_128BIT_SESSION_KEY | NEGOTIATE_56BIT_ENCRYPTION)
+	ntlmv2 := func(i int) func() []byte {
+		return func() []byte {
+			resp, sessionBaseKey := ComputeNTLMv2Response(NTOWFv2(tv.Password, tv.User, tv.UserDom), tv.ServerChallenge,
+				tv.ClientChallenge, tv.Time, tv.NTLMv2TargetInfo)
+			if i == 0 {
+				return resp[:16]
+			}
+			return sessionBaseKey
+		}
+	}
+	runVectors(t, []vector{
+		{"NTOWFv2", func() []byte { return NTOWFv2(tv.Password, tv.User, tv.UserDom) }, tv.NTLMv2NTOWF},
+		{"NTProofStr", ntlmv2(0), tv.NTLMv2NTProofStr},
+		{"SessionBaseKey", ntlmv2(1), tv.NTLMv2SessionBaseKey},
+		{"LMChallengeResponse", func() []byte {
+			return ComputeLMv2Response(tv.NTLMv2NTOWF, tv.ServerChallenge, tv.ClientChallenge)
+		}, tv.NTLMv2LMChallengeResponse},
+		{"EncryptedSessionKey", func() []byte { return EncryptSessionKey(tv.NTLMv2SessionBaseKey, tv.RandomSessionKey) }, tv.NTLMv2EncryptedSessionKey},
+		{"ClientSignKey", func() []byte { return SignKey(flags, tv.RandomSessionKey, "Client") }, tv.NTLMv2ClientSignKey},
+		{"ClientSealKey", func() []byte { return SealKey(flags, tv.RandomSessionKey, "Client") }, tv.NTLMv2ClientSealKey},
+		{"SealedPlaintext", seal(flags, tv.RandomSessionKey, false), tv.NTLMv2SealedPlaintext},
+		{"Signature", seal(flags, tv.RandomSessionKey, true), tv.NTLMv2Signature},
+	})
+}
@@ -16,6 +16,9 @@ type VersionStruct struct {
 }
 
 func ReadVersionStruct(structSource []byte) (*VersionStruct, error) {
+	if len(structSource) < 8 {
+		return nil, fmt.Errorf("%w: version structure of %d bytes", ErrMessageTooShort, len(structSource))
+	}
 	versionStruct := new(VersionStruct)
 
 	versionStruct.ProductMajorVersion = uint8(structSource[0])
@@ -45,3 +48,95 @@ func (v *VersionStruct) Bytes() []byte {
 
 	return buffer.Bytes()
 }
+
+// NTLMSSP_REVISION_W2K3, the only revision in use
+const NTLMSSPRevisionW2K3 = 0x0F
+
+// Version of the OS sent when NEGOTIATE_VERSION is set, e.g.
+// Version{MajorVersion: 10, MinorVersion: 0, BuildNumber: 19041}
+type Version struct {
+	MajorVersion uint8
+	MinorVersion uint8
+	BuildNumber  uint16
+	// NTLMSSPRevisionW2K3 if zero
+	NTLMRevision uint8
+}
+
+// MS-NLMP 2.2.2.10 VERSION
+func (v Version) Marshal() [8]byte {
+	var bs [8]byte
+	bs[0] = v.MajorVersion
+	bs[1] = v.MinorVersion
+	binary.LittleEndian.PutUint16(bs[2:4], v.BuildNumber)
+	bs[7] = v.NTLMRevision
+	if bs[7] == 0 {
+		bs[7] = NTLMSSPRevisionW2K3
+	}
+	return bs
+}
+
+// ProductMajorVersion and ProductMinorVersion values of MS-NLMP 2.2.2.10
+const (
+	WindowsMajorVersion5  = 0x05
+	WindowsMajorVersion6  = 0x06
+	WindowsMajorVersion10 = 0x0A
+
+	WindowsMinorVersion0 = 0x00
+	WindowsMinorVersion1 = 0x01
+	WindowsMinorVersion2 = 0x02
+	WindowsMinorVersion3 = 0x03
+)
+
+// ProductBuild of Windows 10 and 11 releases, which all send 10.0
+const (
+	BuildWindows10v1507    = 10240
+	BuildWindowsServer2016 = 14393
+	BuildWindowsServer2019 = 17763
+	BuildWindowsServer2022 = 20348
+	BuildWindows11v21H2    = 22000
+	BuildWindows11v24H2    = 26100
+)
+
+var windowsVersions = map[[2]uint8]string{
+	{5, 0}: "Windows 2000",
+	{5, 1}: "Windows XP",
+	{5, 2}: "Windows Server 2003",
+	{6, 0}: "Windows Vista / Server 2008",
+	{6, 1}: "Windows 7 / Server 2008 R2",
+	{6, 2}: "Windows 8 / Server 2012",
+	{6, 3}: "Windows 8.1 / Server 2012 R2",
+}
+
+// Windows 10 and later builds shared by a client and a server release
+var windows10Builds = map[uint16]string{
+	BuildWindowsServer2016: "Windows 10 1607 / Server 2016",
+	BuildWindowsServer2019: "Windows 10 1809 / Server 2019",
+	BuildWindowsServer2022: "Windows Server 2022",
+	BuildWindows11v24H2:    "Windows 11 24H2 / Server 2025",
+}
+
+// Friendly name of the sender's OS, e.g. "Windows 10 1809 / Server 2019",
+// "" if unknown
+func (v Version) OSName() string {
+	if v.MajorVersion != WindowsMajorVersion10 {
+		return windowsVersions[[2]uint8{v.MajorVersion, v.MinorVersion}]
+	}
+	if v.MinorVersion != WindowsMinorVersion0 {
+		return ""
+	}
+	if name, ok := windows10Builds[v.BuildNumber]; ok {
+		return name
+	}
+	switch {
+	case v.BuildNumber >= BuildWindows11v21H2:
+		return "Windows 11"
+	case v.BuildNumber >= BuildWindows10v1507:
+		return "Windows 10"
+	}
+	return ""
+}
+
+// Same as Version.OSName
+func (v *VersionStruct) OSName() string {
+	return Version{MajorVersion: v.ProductMajorVersion, MinorVersion: v.ProductMinorVersion, BuildNumber: v.ProductBuild}.OSName()
+}
@@ -0,0 +1,30 @@
+package ntlmssp
+
+import "testing"
+
+func TestVersion_OSName(t *testing.T) {
+	for _, c := range []struct {
+		version Version
+		name    string
+	}{
+		{Version{MajorVersion: 5, MinorVersion: 1, BuildNumber: 2600}, "Windows XP"},
+		{Version{MajorVersion: 6, MinorVersion: 1, BuildNumber: 7601}, "Windows 7 / Server 2008 R2"},
+		{Version{MajorVersion: 6, MinorVersion: 3, BuildNumber: 9600}, "Windows 8.1 / Server 2012 R2"},
+		{Version{MajorVersion: 10, MinorVersion: 0, BuildNumber: 14393}, "Windows 10 1607 / Server 2016"},
+		{Version{MajorVersion: 10, MinorVersion: 0, BuildNumber: 17763}, "Windows 10 1809 / Server 2019"},
+		{Version{MajorVersion: 10, MinorVersion: 0, BuildNumber: 19045}, "Windows 10"},
+		{Version{MajorVersion: 10, MinorVersion: 0, BuildNumber: 20348}, "Windows Server 2022"},
+		{Version{MajorVersion: 10, MinorVersion: 0, BuildNumber: 22631}, "Windows 11"},
+		{Version{MajorVersion: 10, MinorVersion: 0, BuildNumber: 26100}, "Windows 11 24H2 / Server 2025"},
+		{Version{MajorVersion: 10, MinorVersion: 0, BuildNumber: 9200}, ""},
+		{Version{MajorVersion: 7, MinorVersion: 0}, ""},
+	} {
+		if got := c.version.OSName(); got != c.name {
+			t.Errorf("%d.%d.%d: OSName = %q, want %q", c.version.MajorVersion, c.version.MinorVersion, c.version.BuildNumber, got, c.name)
+		}
+		bs := c.version.Marshal()
+		if v, _ := ReadVersionStruct(bs[:]); v.OSName() != c.name {
+			t.Errorf("%d.%d.%d: VersionStruct.OSName = %q, want %q", c.version.MajorVersion, c.version.MinorVersion, c.version.BuildNumber, v.OSName(), c.name)
+		}
+	}
+}